@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Alert watcher settings
+const (
+	DefaultAlertInterval = 15 * time.Minute
+	MinAlertInterval     = time.Minute
+
+	// DefaultSeatAlertInterval is shorter because a route alert polls a
+	// single provider, and freed seats tend to go again quickly
+	DefaultSeatAlertInterval = 5 * time.Minute
+
+	// alertTick is how often the watcher looks for alerts that are due
+	alertTick = 15 * time.Second
+
+	// alertJitter spreads checks by up to this fraction of the interval
+	// either way, so alerts created together do not search together
+	alertJitter = 0.2
+
+	// alertBackoff postpones checks while providers have no spare budget
+	alertBackoff = time.Minute
+
+	// alertReserveTokens are rate limit tokens left for user searches
+	alertReserveTokens = 1
+)
+
+// Alert states
+const (
+	AlertActive  = "active"
+	AlertExpired = "expired"
+)
+
+// ErrAlertNotFound is returned for an unknown alert ID
+var ErrAlertNotFound = errors.New("alert not found")
+
+// AlertConfig configures the alert watcher
+type AlertConfig struct {
+	Interval     Duration   `json:"interval,omitempty"`      // DefaultAlertInterval when unset
+	SeatInterval Duration   `json:"seat_interval,omitempty"` // DefaultSeatAlertInterval when unset
+	SMTP         SMTPConfig `json:"smtp,omitempty"`
+
+	// Hosts webhooks may post to, matched exactly or as ".example.com" for
+	// subdomains. Listed hosts may be internal. When empty, webhooks may go
+	// to any host with a public address.
+	WebhookHosts []string `json:"webhook_hosts,omitempty"`
+
+	// The local sink's webhook URL when BUS_SCANNER_SINKS is set. Webhooks
+	// may always post there, whatever WebhookHosts says.
+	SinkURL string `json:"-"`
+}
+
+// AlertRule watches a search for a fare below a price or enough free seats.
+// It notifies when a route first matches and again whenever a cheaper one
+// appears; once nothing matches it is re-armed.
+//
+// With RouteID and Platform set it instead watches one bus, typically a
+// sold-out one, re-polling only that provider. It notifies once each time
+// the bus goes from short of MinSeats to meeting it.
+type AlertRule struct {
+	ID         string        `json:"id"`
+	Search     SearchRequest `json:"search"`
+	BelowPrice float64       `json:"below_price,omitempty"` // base currency
+	MinSeats   int           `json:"min_seats,omitempty"`
+	RouteID    string        `json:"route_id,omitempty"`
+	Platform   string        `json:"platform,omitempty"` // registry ID
+	Notify     AlertTarget   `json:"notify"`
+
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	NextCheckAt    time.Time  `json:"next_check_at"`
+	LastCheckedAt  *time.Time `json:"last_checked_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Matched        bool       `json:"matched"`
+	LowestSeen     float64    `json:"lowest_seen,omitempty"` // base currency
+	LastNotifiedAt *time.Time `json:"last_notified_at,omitempty"`
+	Notifications  int        `json:"notifications"`
+
+	// The fare last notified, as quoted; an empty currency means the base
+	// currency, as saved before fares were quoted here
+	NotifiedPrice    float64 `json:"notified_price,omitempty"`
+	NotifiedCurrency string  `json:"notified_currency,omitempty"`
+
+	// Route alerts only: the seat count at the last check and when it
+	// last differed from the check before
+	LastSeats      *int       `json:"last_seats,omitempty"`
+	SeatsChangedAt *time.Time `json:"seats_changed_at,omitempty"`
+}
+
+// watchesRoute reports whether the rule follows one bus rather than a search
+func (a AlertRule) watchesRoute() bool {
+	return a.RouteID != "" || a.Platform != ""
+}
+
+// Validate checks the rule can be watched
+func (a AlertRule) Validate() error {
+	if a.Search.FromCity == "" || a.Search.ToCity == "" || a.Search.Date.IsZero() {
+		return fmt.Errorf("search needs from_city, to_city and date")
+	}
+	if a.Search.IsJourney() {
+		return fmt.Errorf("alerts watch a single leg; create one alert per leg")
+	}
+	// Cities and route IDs end up in email headers
+	for _, field := range []string{a.Search.FromCity, a.Search.ToCity, a.RouteID, a.Platform} {
+		if strings.ContainsFunc(field, unicode.IsControl) {
+			return fmt.Errorf("cities, route_id and platform must not contain control characters")
+		}
+	}
+	if a.BelowPrice < 0 || a.MinSeats < 0 {
+		return fmt.Errorf("below_price and min_seats must not be negative")
+	}
+	if a.watchesRoute() {
+		if a.RouteID == "" || a.Platform == "" {
+			return fmt.Errorf("route alerts need both route_id and platform")
+		}
+		return nil
+	}
+	if a.BelowPrice == 0 && a.MinSeats == 0 {
+		return fmt.Errorf("set below_price, min_seats or both")
+	}
+	return a.Search.SearchFilters.Validate()
+}
+
+// matches returns the routes that meet the rule's thresholds, cheapest first
+func (a AlertRule) matches(routes []Route) []Route {
+	var matched []Route
+	for _, route := range routes {
+		if a.BelowPrice > 0 && route.Price.Comparable() >= a.BelowPrice {
+			continue
+		}
+		if a.MinSeats > 0 && route.AvailableSeats < a.MinSeats {
+			continue
+		}
+		matched = append(matched, route)
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Price.Comparable() < matched[j].Price.Comparable() })
+	return matched
+}
+
+// undercutsNotified reports whether price is below the fare last notified.
+// A fare in the notified currency compares as quoted, so a rates refresh
+// alone never notifies again; other fares compare in the base currency at
+// the current rates.
+func (a AlertRule) undercutsNotified(price Price) bool {
+	notified := currencyOr(a.NotifiedCurrency, currencies.Base())
+	if currencyCode(price.Currency) == notified {
+		return price.Amount < a.NotifiedPrice
+	}
+	threshold, err := currencies.Convert(a.NotifiedPrice, notified, price.ComparableCurrency())
+	if err != nil {
+		return true
+	}
+	return price.Comparable() < threshold
+}
+
+// departed reports whether the searched date has passed at the origin
+func (a AlertRule) departed(now time.Time) bool {
+	from := cityCatalogue.Location(a.Search.FromCity)
+	return daysBefore(now, localMidnight(a.Search.Date, from), from) < 0
+}
+
+// AlertWatcher keeps alert rules and re-runs their searches in the
+// background, one at a time, backing off while providers are short of
+// rate limit budget
+type AlertWatcher struct {
+	mu           sync.Mutex
+	rules        map[string]*AlertRule
+	checking     map[string]bool
+	notifiers    map[string]Notifier
+	interval     time.Duration
+	seatInterval time.Duration
+	store        Store
+}
+
+// alertWatcher is the watcher used by the /alerts handlers
+var alertWatcher = NewAlertWatcher(AlertConfig{})
+
+func NewAlertWatcher(cfg AlertConfig) *AlertWatcher {
+	w := &AlertWatcher{
+		rules:    map[string]*AlertRule{},
+		checking: map[string]bool{},
+	}
+	w.Configure(cfg)
+	return w
+}
+
+// Configure sets the check intervals and notification channels
+func (w *AlertWatcher) Configure(cfg AlertConfig) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.interval = DefaultAlertInterval
+	if cfg.Interval > 0 {
+		w.interval = max(time.Duration(cfg.Interval), MinAlertInterval)
+	}
+	w.seatInterval = DefaultSeatAlertInterval
+	if cfg.SeatInterval > 0 {
+		w.seatInterval = max(time.Duration(cfg.SeatInterval), MinAlertInterval)
+	}
+	w.notifiers = newNotifiers(cfg.SMTP, cfg.WebhookHosts, cfg.SinkURL)
+}
+
+// Restore loads the rules saved in s and saves every later change there
+func (w *AlertWatcher) Restore(s Store) error {
+	saved, err := s.Alerts()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.store = s
+	for i := range saved {
+		w.rules[saved[i].ID] = &saved[i]
+	}
+	return nil
+}
+
+func (w *AlertWatcher) saveLocked(rule *AlertRule) {
+	if w.store == nil {
+		return
+	}
+	if err := w.store.SaveAlert(*rule); err != nil {
+		fmt.Printf("Warning: could not save alert %s: %v\n", rule.ID, err)
+	}
+}
+
+// nextCheck is when a rule checked at now is next due
+func (w *AlertWatcher) nextCheck(rule AlertRule, now time.Time) time.Time {
+	w.mu.Lock()
+	interval := w.interval
+	if rule.watchesRoute() {
+		interval = w.seatInterval
+	}
+	w.mu.Unlock()
+	spread := (mathrand.Float64()*2 - 1) * alertJitter
+	return now.Add(time.Duration(float64(interval) * (1 + spread)))
+}
+
+func newAlertID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "alert_" + hex.EncodeToString(b)
+}
+
+// Add validates and stores a new rule. Its first check runs on the
+// watcher's next pass; later ones follow the jittered interval.
+func (w *AlertWatcher) Add(rule AlertRule) (*AlertRule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+	if err := canonicalizeCities(&rule.Search); err != nil {
+		return nil, err
+	}
+	if rule.Search.Passengers < 1 {
+		rule.Search.Passengers = 1
+	}
+	if rule.watchesRoute() {
+		// Only the route's own provider is polled, unfiltered
+		rule.Search.SearchFilters = SearchFilters{Platforms: []string{rule.Platform}}
+		if rule.MinSeats < 1 {
+			rule.MinSeats = 1
+		}
+	}
+	if rule.departed(time.Now()) {
+		return nil, fmt.Errorf("date is in the past")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	notifier, ok := w.notifiers[rule.Notify.Channel]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", rule.Notify.Channel, ErrChannelUnavailable)
+	}
+	if err := notifier.Validate(rule.Notify); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rule.ID = newAlertID()
+	rule.Status = AlertActive
+	rule.CreatedAt = now
+	rule.NextCheckAt = now
+	w.rules[rule.ID] = &rule
+	w.saveLocked(&rule)
+	copied := rule
+	return &copied, nil
+}
+
+// Get returns a copy of a rule
+func (w *AlertWatcher) Get(id string) (*AlertRule, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rule, ok := w.rules[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *rule
+	return &copied, true
+}
+
+// List returns every rule, oldest first
+func (w *AlertWatcher) List() []AlertRule {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := make([]AlertRule, 0, len(w.rules))
+	for _, rule := range w.rules {
+		list = append(list, *rule)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Delete removes a rule
+func (w *AlertWatcher) Delete(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.rules[id]; !ok {
+		return ErrAlertNotFound
+	}
+	delete(w.rules, id)
+	if w.store != nil {
+		return w.store.DeleteAlert(id)
+	}
+	return nil
+}
+
+// Run checks due rules until ctx is done. Checks run one after another so
+// alerts never add more than one search at a time to provider load.
+func (w *AlertWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(alertTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, id := range w.due(time.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+			rule, ok := w.Get(id)
+			if !ok {
+				continue
+			}
+			if !realPlatformManager.Load().backgroundHeadroom(rule.Search.SearchFilters) {
+				// Leave the budget to users and try the rest later too
+				w.postpone(time.Now().Add(alertBackoff))
+				break
+			}
+			if _, err := w.Check(ctx, realPlatformManager.Load(), id); err != nil && !errors.Is(err, ErrAlertNotFound) {
+				fmt.Printf("Warning: alert %s check failed: %v\n", id, err)
+			}
+		}
+	}
+}
+
+// due returns the active rules whose check time has come, most overdue first
+func (w *AlertWatcher) due(now time.Time) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var rules []*AlertRule
+	for _, rule := range w.rules {
+		if rule.Status == AlertActive && !w.checking[rule.ID] && !rule.NextCheckAt.After(now) {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].NextCheckAt.Before(rules[j].NextCheckAt) })
+	ids := make([]string, len(rules))
+	for i, rule := range rules {
+		ids[i] = rule.ID
+	}
+	return ids
+}
+
+// postpone moves every overdue check to until
+func (w *AlertWatcher) postpone(until time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, rule := range w.rules {
+		if rule.Status == AlertActive && rule.NextCheckAt.Before(until) {
+			rule.NextCheckAt = until
+		}
+	}
+}
+
+// Check runs a rule's search now and notifies if it matches
+func (w *AlertWatcher) Check(ctx context.Context, pm *RealPlatformManager, id string) (*AlertRule, error) {
+	w.mu.Lock()
+	rule, ok := w.rules[id]
+	if !ok {
+		w.mu.Unlock()
+		return nil, ErrAlertNotFound
+	}
+	if w.checking[id] {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("alert %s is already being checked", id)
+	}
+	w.checking[id] = true
+	snapshot := *rule
+	notifier := w.notifiers[rule.Notify.Channel]
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		delete(w.checking, id)
+		w.mu.Unlock()
+	}()
+
+	now := time.Now()
+	result := snapshot
+	result.LastCheckedAt = &now
+	result.NextCheckAt = w.nextCheck(snapshot, now)
+	result.LastError = ""
+
+	if snapshot.departed(now) {
+		result.Status = AlertExpired
+		return w.update(id, result)
+	}
+
+	if snapshot.watchesRoute() {
+		routes, err := pm.SearchProvider(ctx, snapshot.Platform, snapshot.Search)
+		if err != nil {
+			result.LastError = err.Error()
+			return w.update(id, result)
+		}
+		checkSeats(ctx, notifier, &result, routes, now)
+		return w.update(id, result)
+	}
+
+	search, err := pm.SearchAllPlatforms(ctx, snapshot.Search)
+	if search == nil {
+		result.LastError = err.Error()
+		return w.update(id, result)
+	}
+	if err != nil {
+		result.LastError = err.Error()
+	}
+
+	matched := snapshot.matches(search.Routes)
+	for _, route := range search.Routes {
+		if result.LowestSeen == 0 || route.Price.Comparable() < result.LowestSeen {
+			result.LowestSeen = route.Price.Comparable()
+		}
+	}
+	if len(matched) == 0 {
+		result.Matched = false
+		result.NotifiedPrice, result.NotifiedCurrency = 0, ""
+		return w.update(id, result)
+	}
+
+	best := matched[0]
+	if snapshot.Matched && !snapshot.undercutsNotified(best.Price) {
+		// Already told them about this fare or a cheaper one
+		return w.update(id, result)
+	}
+
+	deliver(ctx, notifier, &result, alertNotification(snapshot, best, len(matched), now), now)
+	return w.update(id, result)
+}
+
+// checkSeats follows a route alert's bus through the provider's latest
+// results. A bus no longer listed counts as sold out.
+func checkSeats(ctx context.Context, notifier Notifier, result *AlertRule, routes []Route, now time.Time) {
+	var route *Route
+	for i := range routes {
+		if routes[i].ID == result.RouteID {
+			route = &routes[i]
+			break
+		}
+	}
+
+	seats := 0
+	if route != nil {
+		seats = route.AvailableSeats
+		if result.LowestSeen == 0 || route.Price.Comparable() < result.LowestSeen {
+			result.LowestSeen = route.Price.Comparable()
+		}
+	}
+	previous := result.LastSeats
+	if previous == nil || *previous != seats {
+		result.LastSeats = &seats
+		result.SeatsChangedAt = &now
+	}
+
+	if route == nil || len(result.matches([]Route{*route})) == 0 {
+		// Short of seats again, so the next opening notifies
+		result.Matched = false
+		result.NotifiedPrice, result.NotifiedCurrency = 0, ""
+		return
+	}
+	if result.Matched {
+		// Already told them about this opening
+		return
+	}
+	deliver(ctx, notifier, result, seatNotification(*result, *route, previous, now), now)
+}
+
+// deliver sends n and marks the rule notified. On failure it is left
+// un-notified, so the next check tries again.
+func deliver(ctx context.Context, notifier Notifier, result *AlertRule, n Notification, now time.Time) {
+	if notifier == nil {
+		// e.g. SMTP was configured when the alert was made but is not now
+		result.LastError = fmt.Sprintf("%q: %v", result.Notify.Channel, ErrChannelUnavailable)
+		return
+	}
+	if err := notifier.Notify(ctx, result.Notify, n); err != nil {
+		result.LastError = err.Error()
+		return
+	}
+	result.Matched = true
+	result.NotifiedPrice, result.NotifiedCurrency = n.Route.Price.Amount, currencyCode(n.Route.Price.Currency)
+	result.LastNotifiedAt = &now
+	result.Notifications++
+}
+
+// update stores a checked rule unless it was deleted meanwhile
+func (w *AlertWatcher) update(id string, result AlertRule) (*AlertRule, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.rules[id]; !ok {
+		return nil, ErrAlertNotFound
+	}
+	w.rules[id] = &result
+	w.saveLocked(&result)
+	copied := result
+	return &copied, nil
+}
+
+func alertNotification(rule AlertRule, best Route, matches int, at time.Time) Notification {
+	req := rule.Search
+	n := Notification{
+		AlertID:   rule.ID,
+		From:      req.FromCity,
+		To:        req.ToCity,
+		Date:      req.Date.Format("2006-01-02"),
+		Matches:   matches,
+		Route:     best,
+		CheckedAt: at,
+	}
+	n.Subject = fmt.Sprintf("%s → %s on %s: %s %.0f", n.From, n.To, n.Date, best.Price.Currency, best.Price.Amount)
+	n.Message = fmt.Sprintf("%s %s departs %s for %s %.2f with %d seats left",
+		best.Operator.Name, best.BusType.Name, best.DepartureTime.Format("Mon 2 Jan 15:04"),
+		best.Price.Currency, best.Price.Amount, best.AvailableSeats)
+	if matches > 1 {
+		n.Message += fmt.Sprintf(" (%d buses match)", matches)
+	}
+	return n
+}
+
+func seatNotification(rule AlertRule, route Route, previous *int, at time.Time) Notification {
+	req := rule.Search
+	n := Notification{
+		AlertID:   rule.ID,
+		From:      req.FromCity,
+		To:        req.ToCity,
+		Date:      req.Date.Format("2006-01-02"),
+		Matches:   1,
+		Route:     route,
+		CheckedAt: at,
+	}
+	n.Subject = fmt.Sprintf("%s → %s on %s: %d seats free", n.From, n.To, n.Date, route.AvailableSeats)
+	n.Message = fmt.Sprintf("%s %s departing %s now has %d seats at %s %.2f",
+		route.Operator.Name, route.BusType.Name, route.DepartureTime.Format("Mon 2 Jan 15:04"),
+		route.AvailableSeats, route.Price.Currency, route.Price.Amount)
+	if previous != nil {
+		n.Message += fmt.Sprintf(" (was %d)", *previous)
+	}
+	return n
+}
+
+// backgroundHeadroom reports whether every provider a search would call
+// has rate limit budget to spare for background work
+func (pm *RealPlatformManager) backgroundHeadroom(filters SearchFilters) bool {
+	for _, p := range pm.platforms {
+		name := p.GetPlatformName()
+		if !filters.AllowsPlatform(pm.providerIDs[name], name) {
+			continue
+		}
+		if reporter, ok := p.(RateLimitReporter); ok && !reporter.RateLimitStatus().HasHeadroom(alertReserveTokens) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the embedded database
+var (
+	bucketMeta      = []byte("meta")
+	bucketSearches  = []byte("searches")
+	bucketSnapshots = []byte("snapshots")
+	bucketBookings  = []byte("bookings")
+	bucketHealth    = []byte("health")
+	bucketConfig    = []byte("config")
+	bucketFares     = []byte("fares")
+	bucketAlerts    = []byte("alerts")
+
+	keySchemaVersion = []byte("schema_version")
+	keyConfig        = []byte("runtime")
+)
+
+// boltMigrations bring the database up to date. Each runs once, in order,
+// in the same transaction that records the new schema version; append new
+// migrations, never change old ones.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	// 1: one bucket per record kind
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketSearches, bucketSnapshots, bucketBookings, bucketHealth, bucketConfig} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: fare observations for price history
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketFares)
+		return err
+	},
+	// 3: price and seat alert rules
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketAlerts)
+		return err
+	},
+}
+
+// BoltStore keeps records in an embedded bbolt database. Time-ordered
+// records are keyed by timestamp, so range reads and pruning are seeks.
+type BoltStore struct {
+	db *bolt.DB
+
+	// Encrypts secrets in the saved config; nil leaves them out
+	secretKey []byte
+}
+
+// OpenBoltStore opens or creates the database at path and migrates it.
+// Secrets in the saved config are encrypted with SecretKeyEnv.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %v", path, err)
+	}
+	s := &BoltStore{db: db, secretKey: secretKeyFromEnv()}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *BoltStore) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		version := 0
+		if v := meta.Get(keySchemaVersion); len(v) == 8 {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+		if version > len(boltMigrations) {
+			return fmt.Errorf("schema version %d is newer than this server (%d)", version, len(boltMigrations))
+		}
+		for ; version < len(boltMigrations); version++ {
+			if err := boltMigrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d: %v", version+1, err)
+			}
+		}
+		return meta.Put(keySchemaVersion, binary.BigEndian.AppendUint64(nil, uint64(version)))
+	})
+}
+
+// timeKey orders records by time; id keeps records at the same instant apart
+func timeKey(at time.Time, id string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(at.UnixNano())), id...)
+}
+
+// put stores v as JSON under key
+func put(tx *bolt.Tx, bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(key, data)
+}
+
+// scanSince decodes every record from since onwards, oldest first, and
+// hands it to fn until fn returns false
+func scanSince[T any](tx *bolt.Tx, bucket []byte, since time.Time, fn func(T) bool) error {
+	c := tx.Bucket(bucket).Cursor()
+	start := timeKey(since, "")
+	if since.IsZero() {
+		start = nil
+	}
+	k, v := c.First()
+	if start != nil {
+		k, v = c.Seek(start)
+	}
+	for ; k != nil; k, v = c.Next() {
+		var rec T
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return fmt.Errorf("decoding %s record: %v", bucket, err)
+		}
+		if !fn(rec) {
+			break
+		}
+	}
+	return nil
+}
+
+// scanBack decodes records from the newest back to since, handing each to
+// fn until fn returns false
+func scanBack[T any](tx *bolt.Tx, bucket []byte, since time.Time, fn func(T) bool) error {
+	c := tx.Bucket(bucket).Cursor()
+	var stop []byte
+	if !since.IsZero() {
+		stop = timeKey(since, "")
+	}
+	for k, v := c.Last(); k != nil && bytes.Compare(k, stop) >= 0; k, v = c.Prev() {
+		var rec T
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return fmt.Errorf("decoding %s record: %v", bucket, err)
+		}
+		if !fn(rec) {
+			break
+		}
+	}
+	return nil
+}
+
+// pruneBefore deletes the time-keyed records older than before
+func pruneBefore(tx *bolt.Tx, bucket []byte, before time.Time) (int, error) {
+	if before.IsZero() {
+		return 0, nil
+	}
+	end := timeKey(before, "")
+	var stale [][]byte
+	c := tx.Bucket(bucket).Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+		stale = append(stale, append([]byte(nil), k...))
+	}
+	for _, k := range stale {
+		if err := tx.Bucket(bucket).Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
+
+func (s *BoltStore) SaveSearch(rec SearchRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketSearches, timeKey(rec.At, rec.ID), rec)
+	})
+}
+
+// Searches reads back from the newest record and stops once it has limit
+// matches, so a small page never decodes the whole history
+func (s *BoltStore) Searches(fromCity, toCity string, since time.Time, limit int) ([]SearchRecord, error) {
+	var records []SearchRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scanBack(tx, bucketSearches, since, func(rec SearchRecord) bool {
+			if sameRoute(fromCity, toCity, rec.FromCity, rec.ToCity) {
+				records = append(records, rec)
+			}
+			return limit <= 0 || len(records) < limit
+		})
+	})
+	slices.Reverse(records)
+	return records, err
+}
+
+func (s *BoltStore) SaveSnapshot(snap RouteSnapshot) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketSnapshots, timeKey(snap.At, snap.SearchID), snap)
+	})
+}
+
+func (s *BoltStore) Snapshots(fromCity, toCity string, since time.Time) ([]RouteSnapshot, error) {
+	var snaps []RouteSnapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scanSince(tx, bucketSnapshots, since, func(snap RouteSnapshot) bool {
+			if sameRoute(fromCity, toCity, snap.FromCity, snap.ToCity) {
+				snaps = append(snaps, snap)
+			}
+			return true
+		})
+	})
+	return snaps, err
+}
+
+func (s *BoltStore) SaveBooking(b Booking) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketBookings, []byte(b.ID), b)
+	})
+}
+
+func (s *BoltStore) Bookings() ([]Booking, error) {
+	var list []Booking
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketBookings).ForEach(func(_, v []byte) error {
+			var b Booking
+			if err := json.Unmarshal(v, &b); err != nil {
+				return fmt.Errorf("decoding booking: %v", err)
+			}
+			list = append(list, b)
+			return nil
+		})
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, err
+}
+
+func (s *BoltStore) SaveHealthSamples(samples []HealthSample) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, sample := range samples {
+			if err := put(tx, bucketHealth, timeKey(sample.At, sample.Provider), sample); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) HealthSamples(providerID string, since time.Time) ([]HealthSample, error) {
+	var samples []HealthSample
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scanSince(tx, bucketHealth, since, func(sample HealthSample) bool {
+			if providerID == "" || sample.Provider == providerID {
+				samples = append(samples, sample)
+			}
+			return true
+		})
+	})
+	return samples, err
+}
+
+func (s *BoltStore) SaveFareObservations(observations []FareObservation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, o := range observations {
+			if err := put(tx, bucketFares, timeKey(o.ObservedAt, o.Platform+"/"+o.RouteID), o); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) FareObservations(fromCity, toCity string, since time.Time) ([]FareObservation, error) {
+	var observations []FareObservation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return scanSince(tx, bucketFares, since, func(o FareObservation) bool {
+			if sameRoute(fromCity, toCity, o.FromCity, o.ToCity) {
+				observations = append(observations, o)
+			}
+			return true
+		})
+	})
+	return observations, err
+}
+
+func (s *BoltStore) SaveAlert(rule AlertRule) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketAlerts, []byte(rule.ID), rule)
+	})
+}
+
+func (s *BoltStore) DeleteAlert(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAlerts).Delete([]byte(id))
+	})
+}
+
+func (s *BoltStore) Alerts() ([]AlertRule, error) {
+	var list []AlertRule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAlerts).ForEach(func(_, v []byte) error {
+			var rule AlertRule
+			if err := json.Unmarshal(v, &rule); err != nil {
+				return fmt.Errorf("decoding alert: %v", err)
+			}
+			list = append(list, rule)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *BoltStore) SaveConfig(cfg Config) error {
+	cfg, err := cfg.Sealed(s.secretKey)
+	if err != nil {
+		return fmt.Errorf("sealing secrets: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, bucketConfig, keyConfig, cfg)
+	})
+}
+
+func (s *BoltStore) LoadConfig() (*Config, error) {
+	var cfg *Config
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketConfig).Get(keyConfig)
+		if data == nil {
+			return nil
+		}
+		var saved Config
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+		opened, err := saved.Opened(s.secretKey)
+		if err != nil {
+			return fmt.Errorf("opening secrets: %v", err)
+		}
+		cfg = &opened
+		return nil
+	})
+	return cfg, err
+}
+
+func (s *BoltStore) Prune(policy RetentionPolicy, now time.Time) (int, error) {
+	policy = policy.withDefaults()
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, p := range []struct {
+			bucket []byte
+			keep   Duration
+		}{
+			{bucketSearches, policy.Searches},
+			{bucketSnapshots, policy.Snapshots},
+			{bucketHealth, policy.HealthSamples},
+			{bucketFares, policy.Fares},
+		} {
+			n, err := pruneBefore(tx, p.bucket, cutoff(now, p.keep))
+			if err != nil {
+				return err
+			}
+			removed += n
+		}
+
+		before := cutoff(now, policy.Bookings)
+		var stale [][]byte
+		err := tx.Bucket(bucketBookings).ForEach(func(k, v []byte) error {
+			var b Booking
+			if err := json.Unmarshal(v, &b); err != nil {
+				return fmt.Errorf("decoding booking: %v", err)
+			}
+			if b.State != BookingHeld && b.UpdatedAt.Before(before) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := tx.Bucket(bucketBookings).Delete(k); err != nil {
+				return err
+			}
+		}
+		removed += len(stale)
+		return nil
+	})
+	return removed, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestBoltStore(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func schemaVersion(t *testing.T, s *BoltStore) int {
+	t.Helper()
+	version := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		version = int(binary.BigEndian.Uint64(tx.Bucket(bucketMeta).Get(keySchemaVersion)))
+		return nil
+	})
+	return version
+}
+
+func TestBoltStoreMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int // schema version written before opening; 0 is a new file
+		wantErr string
+	}{
+		{name: "new database", version: 0},
+		{name: "from first schema", version: 1},
+		{name: "up to date", version: len(boltMigrations)},
+		{name: "newer than server", version: len(boltMigrations) + 1, wantErr: "newer than this server"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scanner.db")
+			if tt.version > 0 {
+				db, err := bolt.Open(path, 0o600, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = db.Update(func(tx *bolt.Tx) error {
+					for _, migrate := range boltMigrations[:min(tt.version, len(boltMigrations))] {
+						if err := migrate(tx); err != nil {
+							return err
+						}
+					}
+					meta, err := tx.CreateBucket(bucketMeta)
+					if err != nil {
+						return err
+					}
+					return meta.Put(keySchemaVersion, binary.BigEndian.AppendUint64(nil, uint64(tt.version)))
+				})
+				db.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s, err := OpenBoltStore(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("OpenBoltStore() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenBoltStore: %v", err)
+			}
+			if got := schemaVersion(t, s); got != len(boltMigrations) {
+				t.Errorf("schema version = %d, want %d", got, len(boltMigrations))
+			}
+			if err := s.SaveAlert(AlertRule{ID: "a1"}); err != nil {
+				t.Errorf("SaveAlert after migrating: %v", err)
+			}
+			s.Close()
+
+			// Reopening runs nothing and keeps the data
+			s = openTestBoltStore(t, path)
+			if got := schemaVersion(t, s); got != len(boltMigrations) {
+				t.Errorf("schema version after reopening = %d, want %d", got, len(boltMigrations))
+			}
+			if alerts, err := s.Alerts(); err != nil || len(alerts) != 1 {
+				t.Errorf("Alerts() after reopening = %v, %v; want the saved rule", alerts, err)
+			}
+		})
+	}
+}
+
+func TestBoltStorePrune(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name         string
+		policy       RetentionPolicy
+		wantRemoved  int
+		wantSearches int
+		wantBookings []string
+	}{
+		{
+			name:         "defaults",
+			wantRemoved:  3, // a 40 day old search, an 8 day old snapshot, a two year old booking
+			wantSearches: 1,
+			wantBookings: []string{"held", "recent"},
+		},
+		{
+			name:         "shorter periods",
+			policy:       RetentionPolicy{Searches: Duration(12 * time.Hour), Bookings: Duration(30 * day)},
+			wantRemoved:  5,
+			wantSearches: 0,
+			wantBookings: []string{"held"},
+		},
+		{
+			name:         "negative keeps forever",
+			policy:       RetentionPolicy{Searches: -1, Snapshots: -1, Bookings: -1},
+			wantRemoved:  0,
+			wantSearches: 2,
+			wantBookings: []string{"held", "old", "recent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openTestBoltStore(t, filepath.Join(t.TempDir(), "scanner.db"))
+			s.SaveSearch(SearchRecord{ID: "s-old", At: now.Add(-40 * day)})
+			s.SaveSearch(SearchRecord{ID: "s-new", At: now.Add(-day)})
+			s.SaveSnapshot(RouteSnapshot{SearchID: "s-old", At: now.Add(-8 * day)})
+			s.SaveBooking(Booking{ID: "old", State: BookingCancelled, UpdatedAt: now.Add(-730 * day)})
+			s.SaveBooking(Booking{ID: "recent", State: BookingConfirmed, UpdatedAt: now.Add(-60 * day)})
+			s.SaveBooking(Booking{ID: "held", State: BookingHeld, UpdatedAt: now.Add(-730 * day)})
+
+			removed, err := s.Prune(tt.policy, now)
+			if err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("removed %d records, want %d", removed, tt.wantRemoved)
+			}
+
+			searches, _ := s.Searches("", "", time.Time{}, 0)
+			if len(searches) != tt.wantSearches {
+				t.Errorf("%d searches left, want %d", len(searches), tt.wantSearches)
+			}
+			saved, _ := s.Bookings()
+			var ids []string
+			for _, b := range saved {
+				ids = append(ids, b.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantBookings, ",") {
+				t.Errorf("bookings left = %v, want %v", ids, tt.wantBookings)
+			}
+		})
+	}
+}
+
+func TestBoltStoreSearches(t *testing.T) {
+	s := openTestBoltStore(t, filepath.Join(t.TempDir(), "scanner.db"))
+	start := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i, route := range [][2]string{{"Delhi", "Jaipur"}, {"Pune", "Goa"}, {"Delhi", "Jaipur"}, {"Delhi", "Agra"}, {"Delhi", "Jaipur"}} {
+		s.SaveSearch(SearchRecord{ID: string(rune('a' + i)), At: start.Add(time.Duration(i) * time.Hour), FromCity: route[0], ToCity: route[1]})
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		since    time.Time
+		limit    int
+		want     string
+	}{
+		{name: "all", want: "abcde"},
+		{name: "one route", from: "delhi", to: "jaipur", want: "ace"},
+		{name: "from city only", from: "Delhi", want: "acde"},
+		{name: "latest two", from: "Delhi", to: "Jaipur", limit: 2, want: "ce"},
+		{name: "since", since: start.Add(2 * time.Hour), want: "cde"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := s.Searches(tt.from, tt.to, tt.since, tt.limit)
+			if err != nil {
+				t.Fatalf("Searches: %v", err)
+			}
+			got := ""
+			for _, rec := range records {
+				got += rec.ID
+			}
+			if got != tt.want {
+				t.Errorf("Searches() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Booking states. A booking starts held and ends confirmed, cancelled or
+// expired; only held bookings can be confirmed, and a hold that is never
+// confirmed expires.
+const (
+	BookingHeld      = "held"
+	BookingConfirmed = "confirmed"
+	BookingCancelled = "cancelled"
+	BookingExpired   = "expired"
+)
+
+// Booking limits
+const (
+	// DefaultHoldTTL is how long a hold lasts when the provider does not say
+	DefaultHoldTTL = 10 * time.Minute
+
+	// MaxPassengers bounds one booking, as operators do
+	MaxPassengers = 6
+)
+
+var (
+	// ErrBookingNotFound is returned for an unknown booking ID
+	ErrBookingNotFound = errors.New("booking not found")
+
+	// ErrBookingState is returned for a transition the booking's state does
+	// not allow, such as confirming a cancelled booking
+	ErrBookingState = errors.New("not allowed in the booking's current state")
+
+	// ErrBookingBusy is returned while another request is changing the
+	// same booking
+	ErrBookingBusy = errors.New("booking is being updated by another request")
+
+	// ErrHoldExpired is returned when confirming a hold that has lapsed
+	ErrHoldExpired = errors.New("seat hold has expired")
+
+	// ErrSeatUnavailable is returned when a requested seat is booked, held
+	// by someone else or reserved for women
+	ErrSeatUnavailable = errors.New("seat not available")
+
+	// ErrIdempotencyConflict is returned when an idempotency key is reused
+	// for a different booking request
+	ErrIdempotencyConflict = errors.New("idempotency key already used for a different request")
+)
+
+// Passenger is one traveller on a booking and the seat they will take
+type Passenger struct {
+	Name   string `json:"name"`
+	Age    int    `json:"age"`
+	Gender string `json:"gender"` // "male", "female" or "other"
+	Seat   string `json:"seat"`
+}
+
+// Contact is where the ticket is sent
+type Contact struct {
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+}
+
+// BookingRequest asks a provider to hold seats on one of its routes
+type BookingRequest struct {
+	Provider   string      `json:"provider"` // registry ID
+	RouteID    string      `json:"route_id"`
+	Passengers []Passenger `json:"passengers"`
+	Contact    Contact     `json:"contact"`
+}
+
+// Validate checks the request has everything a provider needs to hold seats
+func (r BookingRequest) Validate() error {
+	if r.Provider == "" || r.RouteID == "" {
+		return fmt.Errorf("provider and route_id are required")
+	}
+	if len(r.Passengers) == 0 {
+		return fmt.Errorf("at least one passenger is required")
+	}
+	if len(r.Passengers) > MaxPassengers {
+		return fmt.Errorf("at most %d passengers are allowed", MaxPassengers)
+	}
+	seats := map[string]bool{}
+	for i, p := range r.Passengers {
+		if strings.TrimSpace(p.Name) == "" || p.Seat == "" {
+			return fmt.Errorf("passenger %d needs a name and a seat", i+1)
+		}
+		if p.Age <= 0 || p.Age > 120 {
+			return fmt.Errorf("passenger %d has an invalid age", i+1)
+		}
+		switch p.Gender {
+		case "male", "female", "other":
+		default:
+			return fmt.Errorf("passenger %d gender must be male, female or other", i+1)
+		}
+		if seats[p.Seat] {
+			return fmt.Errorf("seat %s is given to more than one passenger", p.Seat)
+		}
+		seats[p.Seat] = true
+	}
+	if r.Contact.Email == "" && r.Contact.Phone == "" {
+		return fmt.Errorf("contact needs an email or a phone number")
+	}
+	return nil
+}
+
+// fingerprint identifies the request's contents, so a reused idempotency
+// key can be told apart from a retry
+func (r BookingRequest) fingerprint() string {
+	body, _ := json.Marshal(r)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// SeatHold is a provider's temporary block on seats
+type SeatHold struct {
+	HoldID    string    `json:"hold_id"`
+	Fare      Price     `json:"fare"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// BookingConfirmation is a provider's ticket for a confirmed hold
+type BookingConfirmation struct {
+	PNR          string `json:"pnr"`
+	TicketNumber string `json:"ticket_number"`
+}
+
+// BookingCancellation is a provider's answer to a cancellation
+type BookingCancellation struct {
+	Refund float64 `json:"refund"`
+}
+
+// BookingProvider is implemented by providers that can book seats in-app
+// rather than through a BookingURL. Holds lapse on the provider's side at
+// ExpiresAt; CancelBooking releases a hold or cancels a ticket.
+type BookingProvider interface {
+	BlockSeats(ctx context.Context, req BookingRequest) (*SeatHold, error)
+	ConfirmBooking(ctx context.Context, holdID string) (*BookingConfirmation, error)
+	CancelBooking(ctx context.Context, holdID string) (*BookingCancellation, error)
+}
+
+// BookingEvent records one state change
+type BookingEvent struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+	Note  string    `json:"note,omitempty"`
+}
+
+// Booking is a booking made through the aggregator
+type Booking struct {
+	ID         string      `json:"id"`
+	State      string      `json:"state"`
+	Provider   string      `json:"provider"`
+	RouteID    string      `json:"route_id"`
+	Passengers []Passenger `json:"passengers"`
+	Contact    Contact     `json:"contact"`
+	Fare       Price       `json:"fare"`
+
+	HoldID        string    `json:"hold_id"`
+	HoldExpiresAt time.Time `json:"hold_expires_at"`
+	PNR           string    `json:"pnr,omitempty"`
+	TicketNumber  string    `json:"ticket_number,omitempty"`
+	Refund        *float64  `json:"refund,omitempty"`
+
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	History   []BookingEvent `json:"history"`
+}
+
+func (b *Booking) transition(state, note string) {
+	b.State = state
+	b.UpdatedAt = time.Now()
+	b.History = append(b.History, BookingEvent{State: state, At: b.UpdatedAt, Note: note})
+}
+
+// BookingManager runs the booking state machine. It remembers idempotency
+// keys so a retried hold returns the original booking instead of holding
+// seats twice, and expires holds that are not confirmed in time.
+type BookingManager struct {
+	mu       sync.Mutex
+	bookings map[string]*Booking
+	keys     map[string]idempotencyEntry
+	busy     map[string]bool
+	timers   map[string]*time.Timer
+
+	// Every change is saved here, so bookings survive a restart
+	store Store
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	bookingID   string // empty while the hold is in flight
+}
+
+// bookings is the manager used by the /bookings handlers
+var bookings = NewBookingManager()
+
+func NewBookingManager() *BookingManager {
+	return &BookingManager{
+		bookings: map[string]*Booking{},
+		keys:     map[string]idempotencyEntry{},
+		busy:     map[string]bool{},
+		timers:   map[string]*time.Timer{},
+	}
+}
+
+// Restore loads the bookings saved in s and saves every later change
+// there. Holds that lapsed while the server was down are expired; the rest
+// get their expiry timers back. Idempotency keys are not kept across
+// restarts.
+func (m *BookingManager) Restore(s Store) error {
+	saved, err := s.Bookings()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store = s
+	for i := range saved {
+		b := &saved[i]
+		m.bookings[b.ID] = b
+		if b.State != BookingHeld {
+			continue
+		}
+		if time.Now().After(b.HoldExpiresAt) {
+			m.expireLocked(b)
+			continue
+		}
+		id := b.ID
+		m.timers[id] = time.AfterFunc(time.Until(b.HoldExpiresAt), func() { m.expire(id) })
+	}
+	return nil
+}
+
+// saveLocked writes a booking to the store. A failed write is logged; the
+// booking itself has still changed with the provider.
+func (m *BookingManager) saveLocked(b *Booking) {
+	if m.store == nil {
+		return
+	}
+	if err := m.store.SaveBooking(*b); err != nil {
+		fmt.Printf("Warning: could not save booking %s: %v\n", b.ID, err)
+	}
+}
+
+func newBookingID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "bkg_" + hex.EncodeToString(b)
+}
+
+// Hold blocks the requested seats with the provider and records a held
+// booking. With an idempotency key, repeating the same request returns the
+// booking it created (replayed is true) and reusing the key for a different
+// request fails with ErrIdempotencyConflict.
+func (m *BookingManager) Hold(ctx context.Context, pm *RealPlatformManager, req BookingRequest, key string) (booking *Booking, replayed bool, err error) {
+	fingerprint := req.fingerprint()
+	if key != "" {
+		m.mu.Lock()
+		entry, ok := m.keys[key]
+		switch {
+		case ok && entry.fingerprint != fingerprint:
+			m.mu.Unlock()
+			return nil, false, ErrIdempotencyConflict
+		case ok && entry.bookingID == "":
+			m.mu.Unlock()
+			return nil, false, ErrBookingBusy
+		case ok:
+			booking := m.snapshotLocked(entry.bookingID)
+			m.mu.Unlock()
+			return booking, true, nil
+		}
+		m.keys[key] = idempotencyEntry{fingerprint: fingerprint}
+		m.mu.Unlock()
+	}
+
+	var hold *SeatHold
+	err = pm.withBookingProvider(ctx, req.Provider, func(ctx context.Context, p BookingProvider) error {
+		var err error
+		hold, err = p.BlockSeats(ctx, req)
+		return err
+	})
+	if err != nil {
+		// A failed hold may be retried with the same key
+		if key != "" {
+			m.mu.Lock()
+			delete(m.keys, key)
+			m.mu.Unlock()
+		}
+		return nil, false, err
+	}
+
+	now := time.Now()
+	if hold.ExpiresAt.IsZero() {
+		hold.ExpiresAt = now.Add(DefaultHoldTTL)
+	}
+	b := &Booking{
+		ID:            newBookingID(),
+		Provider:      req.Provider,
+		RouteID:       req.RouteID,
+		Passengers:    req.Passengers,
+		Contact:       req.Contact,
+		Fare:          hold.Fare,
+		HoldID:        hold.HoldID,
+		HoldExpiresAt: hold.ExpiresAt,
+		CreatedAt:     now,
+	}
+	b.transition(BookingHeld, "seats held until "+hold.ExpiresAt.Format(time.RFC3339))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bookings[b.ID] = b
+	m.saveLocked(b)
+	if key != "" {
+		m.keys[key] = idempotencyEntry{fingerprint: fingerprint, bookingID: b.ID}
+	}
+	id := b.ID
+	m.timers[id] = time.AfterFunc(time.Until(hold.ExpiresAt), func() { m.expire(id) })
+	return m.snapshotLocked(id), false, nil
+}
+
+// Get returns a copy of a booking
+func (m *BookingManager) Get(id string) (*Booking, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	booking := m.snapshotLocked(id)
+	return booking, booking != nil
+}
+
+// Confirm turns a held booking into a ticket. Confirming a booking that is
+// already confirmed returns it unchanged, so retries are safe.
+func (m *BookingManager) Confirm(ctx context.Context, pm *RealPlatformManager, id string) (*Booking, error) {
+	b, err := m.begin(id, func(b *Booking) (bool, error) {
+		switch b.State {
+		case BookingConfirmed:
+			return true, nil
+		case BookingHeld:
+			if time.Now().After(b.HoldExpiresAt) {
+				m.expireLocked(b)
+				return false, ErrHoldExpired
+			}
+			return false, nil
+		case BookingExpired:
+			return false, ErrHoldExpired
+		}
+		return false, fmt.Errorf("cannot confirm a %s booking: %w", b.State, ErrBookingState)
+	})
+	if err != nil || b == nil {
+		return m.finish(id, err)
+	}
+
+	var confirmation *BookingConfirmation
+	err = pm.withBookingProvider(ctx, b.Provider, func(ctx context.Context, p BookingProvider) error {
+		var err error
+		confirmation, err = p.ConfirmBooking(ctx, b.HoldID)
+		return err
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.busy, id)
+	booking := m.bookings[id]
+	switch {
+	case errors.Is(err, ErrHoldExpired):
+		m.expireLocked(booking)
+		return nil, err
+	case err != nil:
+		m.expireIfLapsedLocked(booking)
+		return nil, err
+	}
+	m.stopTimerLocked(id)
+	booking.PNR = confirmation.PNR
+	booking.TicketNumber = confirmation.TicketNumber
+	booking.transition(BookingConfirmed, "PNR "+confirmation.PNR)
+	m.saveLocked(booking)
+	return m.snapshotLocked(id), nil
+}
+
+// Cancel releases a held booking or cancels a confirmed one. Cancelling a
+// booking that is already cancelled returns it unchanged.
+func (m *BookingManager) Cancel(ctx context.Context, pm *RealPlatformManager, id string) (*Booking, error) {
+	b, err := m.begin(id, func(b *Booking) (bool, error) {
+		switch b.State {
+		case BookingCancelled:
+			return true, nil
+		case BookingHeld, BookingConfirmed:
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot cancel a %s booking: %w", b.State, ErrBookingState)
+	})
+	if err != nil || b == nil {
+		return m.finish(id, err)
+	}
+
+	var cancellation *BookingCancellation
+	err = pm.withBookingProvider(ctx, b.Provider, func(ctx context.Context, p BookingProvider) error {
+		var err error
+		cancellation, err = p.CancelBooking(ctx, b.HoldID)
+		return err
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.busy, id)
+	booking := m.bookings[id]
+	if err != nil {
+		m.expireIfLapsedLocked(booking)
+		return nil, err
+	}
+	m.stopTimerLocked(id)
+	note := "hold released"
+	if booking.State == BookingConfirmed {
+		refund := cancellation.Refund
+		booking.Refund = &refund
+		note = fmt.Sprintf("refund %.2f %s", refund, booking.Fare.Currency)
+	}
+	booking.transition(BookingCancelled, note)
+	m.saveLocked(booking)
+	return m.snapshotLocked(id), nil
+}
+
+// begin checks a transition is allowed and marks the booking busy while the
+// provider is called. check returns done when the booking is already in the
+// target state, in which case begin returns a nil booking and no error.
+func (m *BookingManager) begin(id string, check func(*Booking) (done bool, err error)) (*Booking, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.bookings[id]
+	if !ok {
+		return nil, ErrBookingNotFound
+	}
+	if m.busy[id] {
+		return nil, ErrBookingBusy
+	}
+	done, err := check(b)
+	if err != nil || done {
+		return nil, err
+	}
+	m.busy[id] = true
+	return m.snapshotLocked(id), nil
+}
+
+// finish returns the booking as it stands, or err
+func (m *BookingManager) finish(id string, err error) (*Booking, error) {
+	if err != nil {
+		return nil, err
+	}
+	booking, _ := m.Get(id)
+	return booking, nil
+}
+
+// expire moves a hold that was never confirmed to expired. A booking that
+// is mid-confirmation is left to the confirmation, which finds out from the
+// provider whether the hold still stood.
+func (m *BookingManager) expire(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.bookings[id]; ok && !m.busy[id] {
+		m.expireLocked(b)
+	}
+}
+
+func (m *BookingManager) expireLocked(b *Booking) {
+	if b.State != BookingHeld {
+		return
+	}
+	m.stopTimerLocked(b.ID)
+	b.transition(BookingExpired, "hold was not confirmed in time")
+	m.saveLocked(b)
+}
+
+// expireIfLapsedLocked expires a held booking whose hold ran out while a
+// failed provider call was in flight, since its timer skipped it
+func (m *BookingManager) expireIfLapsedLocked(b *Booking) {
+	if time.Now().After(b.HoldExpiresAt) {
+		m.expireLocked(b)
+	}
+}
+
+func (m *BookingManager) stopTimerLocked(id string) {
+	if timer, ok := m.timers[id]; ok {
+		timer.Stop()
+		delete(m.timers, id)
+	}
+}
+
+// snapshotLocked returns a copy of a booking that callers may keep
+func (m *BookingManager) snapshotLocked(id string) *Booking {
+	b, ok := m.bookings[id]
+	if !ok {
+		return nil
+	}
+	snapshot := *b
+	snapshot.Passengers = append([]Passenger(nil), b.Passengers...)
+	snapshot.History = append([]BookingEvent(nil), b.History...)
+	return &snapshot
+}
+
+// withBookingProvider runs call against the provider with the given
+// registry ID, under the same timeout and circuit breaker as searches
+func (pm *RealPlatformManager) withBookingProvider(ctx context.Context, providerID string, call func(context.Context, BookingProvider) error) error {
+	p, ok := pm.ProviderByID(providerID)
+	if !ok {
+		return ErrProviderNotFound
+	}
+	booker, ok := p.(BookingProvider)
+	if !ok {
+		return ErrCapabilityNotSupported
+	}
+
+	breaker := pm.breakers[p.GetPlatformName()]
+	if err := breaker.Allow(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pm.timeoutFor(p))
+	defer cancel()
+
+	err := call(ctx, booker)
+	breaker.Record(err)
+	return err
+}
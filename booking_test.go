@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeBooker is a provider whose booking calls succeed unless told otherwise
+type fakeBooker struct {
+	ttl        time.Duration
+	blockErr   error
+	confirmErr error
+	holds      int
+}
+
+func (f *fakeBooker) GetPlatformName() string { return "Fake" }
+
+func (f *fakeBooker) SearchRoutes(ctx context.Context, req SearchRequest) ([]Route, error) {
+	return nil, nil
+}
+
+func (f *fakeBooker) BlockSeats(ctx context.Context, req BookingRequest) (*SeatHold, error) {
+	if f.blockErr != nil {
+		return nil, f.blockErr
+	}
+	f.holds++
+	hold := &SeatHold{HoldID: fmt.Sprintf("hold-%d", f.holds), Fare: Price{Amount: 500, Currency: "INR", Platform: "Fake"}}
+	if f.ttl > 0 {
+		hold.ExpiresAt = time.Now().Add(f.ttl)
+	}
+	return hold, nil
+}
+
+func (f *fakeBooker) ConfirmBooking(ctx context.Context, holdID string) (*BookingConfirmation, error) {
+	if f.confirmErr != nil {
+		return nil, f.confirmErr
+	}
+	return &BookingConfirmation{PNR: "PNR-" + holdID, TicketNumber: "T-" + holdID}, nil
+}
+
+func (f *fakeBooker) CancelBooking(ctx context.Context, holdID string) (*BookingCancellation, error) {
+	return &BookingCancellation{Refund: 400}, nil
+}
+
+func fakeBookingManager(f *fakeBooker) *RealPlatformManager {
+	return &RealPlatformManager{
+		platforms:      []PlatformService{f},
+		providerIDs:    map[string]string{f.GetPlatformName(): "fake"},
+		breakers:       map[string]*CircuitBreaker{f.GetPlatformName(): NewCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown)},
+		defaultTimeout: time.Second,
+	}
+}
+
+func testBookingRequest(seat string) BookingRequest {
+	return BookingRequest{
+		Provider:   "fake",
+		RouteID:    "fake-1",
+		Passengers: []Passenger{{Name: "Asha", Age: 30, Gender: "female", Seat: seat}},
+		Contact:    Contact{Email: "asha@example.com"},
+	}
+}
+
+func TestBookingTransitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		steps      []string
+		wantState  string
+		wantErr    error
+		wantRefund bool
+	}{
+		{name: "confirm", steps: []string{"confirm"}, wantState: BookingConfirmed},
+		{name: "confirm twice", steps: []string{"confirm", "confirm"}, wantState: BookingConfirmed},
+		{name: "release hold", steps: []string{"cancel"}, wantState: BookingCancelled},
+		{name: "cancel ticket", steps: []string{"confirm", "cancel"}, wantState: BookingCancelled, wantRefund: true},
+		{name: "cancel twice", steps: []string{"cancel", "cancel"}, wantState: BookingCancelled},
+		{name: "confirm cancelled", steps: []string{"cancel", "confirm"}, wantState: BookingCancelled, wantErr: ErrBookingState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := fakeBookingManager(&fakeBooker{})
+			m := NewBookingManager()
+			ctx := context.Background()
+
+			held, _, err := m.Hold(ctx, pm, testBookingRequest("A1"), "")
+			if err != nil {
+				t.Fatalf("Hold: %v", err)
+			}
+			if held.State != BookingHeld {
+				t.Fatalf("state after hold = %s, want %s", held.State, BookingHeld)
+			}
+
+			for _, step := range tt.steps {
+				switch step {
+				case "confirm":
+					_, err = m.Confirm(ctx, pm, held.ID)
+				case "cancel":
+					_, err = m.Cancel(ctx, pm, held.ID)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("last step error = %v, want %v", err, tt.wantErr)
+			}
+
+			got, _ := m.Get(held.ID)
+			if got.State != tt.wantState {
+				t.Errorf("state = %s, want %s", got.State, tt.wantState)
+			}
+			if tt.wantRefund != (got.Refund != nil) {
+				t.Errorf("refund = %v, want refund=%v", got.Refund, tt.wantRefund)
+			}
+		})
+	}
+}
+
+func TestBookingExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		confirmErr error
+		wait       time.Duration
+		wantErr    error
+		wantState  string
+	}{
+		{name: "confirmed in time", ttl: time.Minute, wantState: BookingConfirmed},
+		{name: "timer expires hold", ttl: 10 * time.Millisecond, wait: 50 * time.Millisecond, wantErr: ErrHoldExpired, wantState: BookingExpired},
+		{name: "provider says expired", ttl: time.Minute, confirmErr: ErrHoldExpired, wantErr: ErrHoldExpired, wantState: BookingExpired},
+		{name: "provider failure keeps hold", ttl: time.Minute, confirmErr: &APIError{StatusCode: 502}, wantErr: &APIError{}, wantState: BookingHeld},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm := fakeBookingManager(&fakeBooker{ttl: tt.ttl, confirmErr: tt.confirmErr})
+			m := NewBookingManager()
+			ctx := context.Background()
+
+			held, _, err := m.Hold(ctx, pm, testBookingRequest("A1"), "")
+			if err != nil {
+				t.Fatalf("Hold: %v", err)
+			}
+			time.Sleep(tt.wait)
+
+			_, err = m.Confirm(ctx, pm, held.ID)
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("Confirm() = %v, want success", err)
+				}
+			case *APIError:
+				if !errors.As(err, &want) {
+					t.Errorf("Confirm() = %v, want an APIError", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("Confirm() = %v, want %v", err, want)
+				}
+			}
+
+			got, _ := m.Get(held.ID)
+			if got.State != tt.wantState {
+				t.Errorf("state = %s, want %s", got.State, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestBookingHoldIdempotency(t *testing.T) {
+	f := &fakeBooker{}
+	pm := fakeBookingManager(f)
+	m := NewBookingManager()
+	ctx := context.Background()
+
+	first, replayed, err := m.Hold(ctx, pm, testBookingRequest("A1"), "key-1")
+	if err != nil || replayed {
+		t.Fatalf("first Hold = %v, replayed %v", err, replayed)
+	}
+	again, replayed, err := m.Hold(ctx, pm, testBookingRequest("A1"), "key-1")
+	if err != nil || !replayed || again.ID != first.ID {
+		t.Fatalf("repeated Hold = %v, replayed %v; want booking %s replayed", err, replayed, first.ID)
+	}
+	if f.holds != 1 {
+		t.Errorf("provider held seats %d times, want 1", f.holds)
+	}
+	if _, _, err := m.Hold(ctx, pm, testBookingRequest("B2"), "key-1"); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("Hold with reused key = %v, want ErrIdempotencyConflict", err)
+	}
+
+	// A failed hold frees its key for a retry
+	f.blockErr = ErrSeatUnavailable
+	if _, _, err := m.Hold(ctx, pm, testBookingRequest("C3"), "key-2"); !errors.Is(err, ErrSeatUnavailable) {
+		t.Fatalf("failing Hold = %v, want ErrSeatUnavailable", err)
+	}
+	f.blockErr = nil
+	if _, replayed, err := m.Hold(ctx, pm, testBookingRequest("C3"), "key-2"); err != nil || replayed {
+		t.Errorf("retried Hold = %v, replayed %v; want a new hold", err, replayed)
+	}
+}
+
+func TestBookingRestore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	saved := []Booking{
+		{ID: "lapsed", State: BookingHeld, HoldExpiresAt: now.Add(-time.Minute), CreatedAt: now.Add(-time.Hour)},
+		{ID: "live", State: BookingHeld, HoldExpiresAt: now.Add(100 * time.Millisecond), CreatedAt: now},
+		{ID: "ticket", State: BookingConfirmed, HoldExpiresAt: now.Add(-time.Minute), CreatedAt: now},
+	}
+	for _, b := range saved {
+		store.SaveBooking(b)
+	}
+
+	m := NewBookingManager()
+	if err := m.Restore(store); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	tests := []struct {
+		id        string
+		wait      time.Duration
+		wantState string
+	}{
+		{id: "lapsed", wantState: BookingExpired},
+		{id: "live", wantState: BookingHeld},
+		{id: "ticket", wantState: BookingConfirmed},
+		{id: "live", wait: 200 * time.Millisecond, wantState: BookingExpired},
+	}
+
+	for _, tt := range tests {
+		time.Sleep(tt.wait)
+		got, ok := m.Get(tt.id)
+		if !ok {
+			t.Fatalf("booking %s not restored", tt.id)
+		}
+		if got.State != tt.wantState {
+			t.Errorf("%s state = %s, want %s", tt.id, got.State, tt.wantState)
+		}
+	}
+
+	// Expiry is saved back to the store
+	persisted, _ := store.Bookings()
+	for _, b := range persisted {
+		if b.ID == "lapsed" && b.State != BookingExpired {
+			t.Errorf("stored lapsed booking state = %s, want %s", b.State, BookingExpired)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache statuses reported per platform in search responses
+const (
+	CacheHit    = "hit"    // fresh entry served from cache
+	CacheStale  = "stale"  // expired entry served while it is refreshed
+	CacheMiss   = "miss"   // fetched from the provider
+	CacheShared = "shared" // joined an identical in-flight provider call
+)
+
+// Default cache lifetimes
+const (
+	DefaultCacheTTL   = 2 * time.Minute
+	DefaultCacheStale = 5 * time.Minute
+)
+
+// SearchCache caches provider results per platform and normalised search.
+// Fresh entries are served directly; entries past their TTL but within the
+// stale window are served immediately while a background refresh runs.
+// Concurrent fetches for the same key collapse into one upstream call.
+type SearchCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	flights map[string]*cacheFlight
+
+	ttls       map[string]time.Duration
+	defaultTTL time.Duration
+	staleFor   time.Duration
+}
+
+type cacheEntry struct {
+	routes    []Route
+	fetchedAt time.Time
+	ttl       time.Duration
+}
+
+// cacheFlight is an upstream call that other callers can wait on. A flight
+// runs under its own context, cancelled once every caller waiting on it
+// has gone; background refreshes have no waiters and always run to the end.
+type cacheFlight struct {
+	done   chan struct{}
+	routes []Route
+	err    error
+
+	cancel     context.CancelFunc
+	waiters    int
+	background bool
+}
+
+func NewSearchCache(defaultTTL, staleFor time.Duration) *SearchCache {
+	return &SearchCache{
+		entries:    map[string]*cacheEntry{},
+		flights:    map[string]*cacheFlight{},
+		ttls:       map[string]time.Duration{},
+		defaultTTL: defaultTTL,
+		staleFor:   staleFor,
+	}
+}
+
+// SetTTL overrides the freshness lifetime for one platform's results
+func (c *SearchCache) SetTTL(platform string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttls[platform] = ttl
+}
+
+// Forget drops a platform's cached results and TTL override, for when its
+// provider is rebuilt with a different configuration. Calls still running
+// against the old provider finish for the callers waiting on them, but
+// their results are not cached and later callers start afresh.
+func (c *SearchCache) Forget(platform string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ttls, platform)
+	for key := range c.entries {
+		if strings.HasPrefix(key, platform+"|") {
+			delete(c.entries, key)
+		}
+	}
+	for key, flight := range c.flights {
+		if strings.HasPrefix(key, platform+"|") {
+			delete(c.flights, key)
+			if flight.waiters == 0 {
+				flight.cancel()
+			}
+		}
+	}
+}
+
+// cacheKey normalises the fields of a search that affect provider results.
+// Filters only matter for providers they were pushed down to; for the rest
+// the manager strips them before the request reaches the cache.
+func cacheKey(platform string, req SearchRequest) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s",
+		platform,
+		strings.ToLower(strings.TrimSpace(req.FromCity)),
+		strings.ToLower(strings.TrimSpace(req.ToCity)),
+		req.Date.Format("2006-01-02"),
+		req.Passengers,
+		req.SearchFilters.key(),
+	)
+}
+
+// Fetch returns the platform's routes for req, calling fetch only when the
+// cache cannot answer. fetch gets a context of its own, with the caller's
+// values but not its cancellation, because it may be shared by several
+// callers or refresh a stale entry in the background; it is cancelled when
+// the last caller waiting on it stops waiting. Only successful results are cached.
+func (c *SearchCache) Fetch(ctx context.Context, platform string, req SearchRequest, fetch func(context.Context) ([]Route, error)) ([]Route, string, error) {
+	key := cacheKey(platform, req)
+	now := time.Now()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok {
+		age := now.Sub(entry.fetchedAt)
+		if age < entry.ttl {
+			c.mu.Unlock()
+			return entry.routes, CacheHit, nil
+		}
+		if age < entry.ttl+c.staleFor {
+			c.startFlightLocked(ctx, key, platform, fetch).background = true
+			c.mu.Unlock()
+			return entry.routes, CacheStale, nil
+		}
+	}
+
+	status := CacheMiss
+	flight, inFlight := c.flights[key]
+	if inFlight {
+		status = CacheShared
+	} else {
+		flight = c.startFlightLocked(ctx, key, platform, fetch)
+	}
+	flight.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-flight.done:
+		return flight.routes, status, flight.err
+	case <-ctx.Done():
+		c.leaveFlight(key, flight)
+		return nil, status, ctx.Err()
+	}
+}
+
+// startFlightLocked starts an upstream call for key unless one is already
+// running. c.mu must be held.
+func (c *SearchCache) startFlightLocked(ctx context.Context, key, platform string, fetch func(context.Context) ([]Route, error)) *cacheFlight {
+	if flight, ok := c.flights[key]; ok {
+		return flight
+	}
+
+	fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	flight := &cacheFlight{done: make(chan struct{}), cancel: cancel}
+	c.flights[key] = flight
+
+	go func() {
+		defer cancel()
+		flight.routes, flight.err = fetch(fctx)
+
+		// A flight that was abandoned or forgotten no longer owns the key
+		c.mu.Lock()
+		if c.flights[key] == flight {
+			delete(c.flights, key)
+			if flight.err == nil {
+				c.entries[key] = &cacheEntry{
+					routes:    flight.routes,
+					fetchedAt: time.Now(),
+					ttl:       c.ttlForLocked(platform),
+				}
+			}
+		}
+		c.pruneLocked()
+		c.mu.Unlock()
+
+		close(flight.done)
+	}()
+
+	return flight
+}
+
+// leaveFlight drops a waiter from flight and cancels the upstream call if
+// nobody else needs its result. The abandoned flight stops being joinable
+// straight away, so a later caller starts afresh.
+func (c *SearchCache) leaveFlight(key string, flight *cacheFlight) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flight.waiters--
+	if flight.waiters > 0 || flight.background {
+		return
+	}
+	if c.flights[key] == flight {
+		delete(c.flights, key)
+	}
+	flight.cancel()
+}
+
+func (c *SearchCache) ttlForLocked(platform string) time.Duration {
+	if ttl, ok := c.ttls[platform]; ok {
+		return ttl
+	}
+	return c.defaultTTL
+}
+
+// pruneLocked drops entries that are too old to be served even as stale
+func (c *SearchCache) pruneLocked() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.Sub(entry.fetchedAt) >= entry.ttl+c.staleFor {
+			delete(c.entries, key)
+		}
+	}
+}
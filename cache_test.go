@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubFetch answers with a route whose ID is prefix and the call's number,
+// after release is closed when it is set
+type stubFetch struct {
+	prefix  string
+	calls   atomic.Int32
+	release chan struct{}
+	err     error
+
+	// The context of the latest call, to see whether it was cancelled
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func (f *stubFetch) fetch(ctx context.Context) ([]Route, error) {
+	n := f.calls.Add(1)
+	f.mu.Lock()
+	f.ctx = ctx
+	f.mu.Unlock()
+	if f.release != nil {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []Route{{ID: f.prefix + strconv.Itoa(int(n))}}, nil
+}
+
+func (f *stubFetch) lastCtx() context.Context {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ctx
+}
+
+func testCacheRequest() SearchRequest {
+	return SearchRequest{FromCity: "Mumbai", ToCity: "Pune", Date: time.Date(2026, 11, 20, 0, 0, 0, 0, time.UTC), Passengers: 1}
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSearchCacheFetch(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		pause      time.Duration // between the two fetches
+		firstErr   error
+		wantStatus string
+		wantRoute  string
+		wantCalls  int32
+	}{
+		{name: "hit", ttl: time.Minute, wantStatus: CacheHit, wantRoute: "1", wantCalls: 1},
+		{name: "stale served while refreshing", ttl: 5 * time.Millisecond, pause: 10 * time.Millisecond, wantStatus: CacheStale, wantRoute: "1", wantCalls: 2},
+		{name: "errors are not cached", ttl: time.Minute, firstErr: errors.New("upstream down"), wantStatus: CacheMiss, wantRoute: "2", wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewSearchCache(tt.ttl, time.Hour)
+			f := &stubFetch{err: tt.firstErr}
+			ctx := context.Background()
+
+			if _, status, err := c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch); status != CacheMiss || err != tt.firstErr {
+				t.Fatalf("first Fetch = %s, %v; want miss, %v", status, err, tt.firstErr)
+			}
+			f.err = nil
+			time.Sleep(tt.pause)
+
+			routes, status, err := c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch)
+			if err != nil {
+				t.Fatalf("second Fetch: %v", err)
+			}
+			if status != tt.wantStatus || routes[0].ID != tt.wantRoute {
+				t.Errorf("second Fetch = %s route %s, want %s route %s", status, routes[0].ID, tt.wantStatus, tt.wantRoute)
+			}
+			waitFor(t, "the upstream calls", func() bool { return f.calls.Load() == tt.wantCalls })
+		})
+	}
+}
+
+func TestSearchCacheStaleRefresh(t *testing.T) {
+	c := NewSearchCache(20*time.Millisecond, time.Hour)
+	f := &stubFetch{}
+	ctx := context.Background()
+
+	c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch)
+	time.Sleep(30 * time.Millisecond)
+	if _, status, _ := c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch); status != CacheStale {
+		t.Fatalf("status = %s, want stale", status)
+	}
+
+	// The background refresh replaces the entry
+	waitFor(t, "the refresh", func() bool {
+		routes, status, _ := c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch)
+		return status == CacheHit && routes[0].ID == "2"
+	})
+	if calls := f.calls.Load(); calls != 2 {
+		t.Errorf("upstream called %d times, want 2", calls)
+	}
+}
+
+func TestSearchCacheSharedFlight(t *testing.T) {
+	c := NewSearchCache(time.Minute, time.Hour)
+	f := &stubFetch{release: make(chan struct{})}
+
+	statuses := make(chan string, 2)
+	var wg sync.WaitGroup
+	fetch := func() {
+		defer wg.Done()
+		routes, status, err := c.Fetch(context.Background(), "RedBus", testCacheRequest(), f.fetch)
+		if err != nil || routes[0].ID != "1" {
+			t.Errorf("Fetch = %v, %v; want route 1", routes, err)
+		}
+		statuses <- status
+	}
+
+	wg.Add(1)
+	go fetch()
+	waitFor(t, "the first call", func() bool { return f.calls.Load() == 1 })
+	wg.Add(1)
+	go fetch()
+	waitFor(t, "the second caller to join", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		flight := c.flights[cacheKey("RedBus", testCacheRequest())]
+		return flight != nil && flight.waiters == 2
+	})
+	close(f.release)
+	wg.Wait()
+
+	if got := []string{<-statuses, <-statuses}; !(got[0] == CacheMiss && got[1] == CacheShared || got[0] == CacheShared && got[1] == CacheMiss) {
+		t.Errorf("statuses = %v, want one miss and one shared", got)
+	}
+	if calls := f.calls.Load(); calls != 1 {
+		t.Errorf("upstream called %d times, want 1", calls)
+	}
+}
+
+func TestSearchCacheCancellation(t *testing.T) {
+	tests := []struct {
+		name         string
+		waiters      int
+		leaving      int
+		wantCanceled bool
+	}{
+		{name: "last waiter leaves", waiters: 1, leaving: 1, wantCanceled: true},
+		{name: "one of two leaves", waiters: 2, leaving: 1, wantCanceled: false},
+		{name: "both leave", waiters: 2, leaving: 2, wantCanceled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewSearchCache(time.Minute, time.Hour)
+			f := &stubFetch{release: make(chan struct{})}
+			defer close(f.release)
+
+			cancels := make([]context.CancelFunc, tt.waiters)
+			errs := make(chan error, tt.waiters)
+			for i := range cancels {
+				var ctx context.Context
+				ctx, cancels[i] = context.WithCancel(context.Background())
+				go func() {
+					_, _, err := c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch)
+					errs <- err
+				}()
+				waitFor(t, "the caller to wait", func() bool {
+					c.mu.Lock()
+					defer c.mu.Unlock()
+					flight := c.flights[cacheKey("RedBus", testCacheRequest())]
+					return flight != nil && flight.waiters == i+1
+				})
+			}
+
+			for _, cancel := range cancels[:tt.leaving] {
+				cancel()
+				if err := <-errs; !errors.Is(err, context.Canceled) {
+					t.Errorf("Fetch after cancel = %v, want context.Canceled", err)
+				}
+			}
+			for _, cancel := range cancels[tt.leaving:] {
+				defer cancel()
+			}
+
+			if tt.wantCanceled {
+				waitFor(t, "the upstream call to be cancelled", func() bool { return f.lastCtx().Err() != nil })
+			} else if err := f.lastCtx().Err(); err != nil {
+				t.Errorf("upstream call cancelled while a caller still waits: %v", err)
+			}
+		})
+	}
+}
+
+func TestSearchCacheForget(t *testing.T) {
+	c := NewSearchCache(time.Minute, time.Hour)
+	ctx := context.Background()
+
+	// Cached results are dropped
+	f := &stubFetch{}
+	c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch)
+	c.Fetch(ctx, "AbhiBus", testCacheRequest(), f.fetch)
+	c.Forget("RedBus")
+	if _, status, _ := c.Fetch(ctx, "RedBus", testCacheRequest(), f.fetch); status != CacheMiss {
+		t.Errorf("RedBus after Forget = %s, want miss", status)
+	}
+	if _, status, _ := c.Fetch(ctx, "AbhiBus", testCacheRequest(), f.fetch); status != CacheHit {
+		t.Errorf("AbhiBus after forgetting RedBus = %s, want hit", status)
+	}
+
+	// A call still running under the old configuration answers its caller
+	// but is neither joined nor cached
+	old := &stubFetch{prefix: "old-", release: make(chan struct{})}
+	req := testCacheRequest()
+	req.Passengers = 2
+	done := make(chan []Route)
+	go func() {
+		routes, _, _ := c.Fetch(ctx, "RedBus", req, old.fetch)
+		done <- routes
+	}()
+	waitFor(t, "the old call", func() bool { return old.calls.Load() == 1 })
+	c.Forget("RedBus")
+
+	rebuilt := &stubFetch{prefix: "new-"}
+	joinCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, status, err := c.Fetch(joinCtx, "RedBus", req, rebuilt.fetch); status != CacheMiss || err != nil {
+		t.Errorf("Fetch after Forget = %s, %v; want a miss rather than joining the old call", status, err)
+	}
+	close(old.release)
+	if routes := <-done; len(routes) != 1 || routes[0].ID != "old-1" {
+		t.Errorf("old caller got %v, want its result", routes)
+	}
+
+	routes, status, _ := c.Fetch(ctx, "RedBus", req, rebuilt.fetch)
+	if status != CacheHit || rebuilt.calls.Load() != 1 {
+		t.Errorf("Fetch = %s after %d rebuilt calls, want a hit on the rebuilt result", status, rebuilt.calls.Load())
+	}
+	if len(routes) != 1 || routes[0].ID != "new-1" {
+		t.Errorf("routes = %v, want the rebuilt provider's", routes)
+	}
+}
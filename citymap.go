@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrCityNotSupported matches any UnsupportedCityError via errors.Is
+var ErrCityNotSupported = errors.New("city not supported")
+
+// UnsupportedCityError is returned by providers that have no ID mapping for
+// a city, instead of sending the upstream API an empty ID
+type UnsupportedCityError struct {
+	Provider string
+	City     string
+}
+
+func (e *UnsupportedCityError) Error() string {
+	return fmt.Sprintf("city %q not supported by %s", e.City, e.Provider)
+}
+
+func (e *UnsupportedCityError) Is(target error) bool {
+	return target == ErrCityNotSupported
+}
+
+// CityMapping ties a canonical catalogue location to a provider's own ID
+type CityMapping struct {
+	Provider   string `json:"provider"`
+	LocationID string `json:"location_id"`
+	ExternalID string `json:"external_id"`
+}
+
+// ProviderCity is one entry of a provider's own city list
+type ProviderCity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// CityLister is implemented by providers with a "cities" endpoint, which
+// lets the mapping store refresh their IDs
+type CityLister interface {
+	ListCities(ctx context.Context) ([]ProviderCity, error)
+}
+
+// CityMappingStore holds external city IDs per provider for every canonical
+// Location. Cities are addressed by catalogue location ID; names and aliases
+// are resolved through the catalogue first.
+type CityMappingStore struct {
+	mu        sync.RWMutex
+	catalogue *LocationCatalogue
+	ids       map[string]map[string]string // provider -> location ID -> external ID
+}
+
+// cityMappings is the store used by providers, seeded from the catalogue
+var cityMappings = NewCityMappingStore(cityCatalogue)
+
+// NewCityMappingStore creates a store seeded with the provider IDs listed
+// in the catalogue
+func NewCityMappingStore(catalogue *LocationCatalogue) *CityMappingStore {
+	store := &CityMappingStore{
+		catalogue: catalogue,
+		ids:       map[string]map[string]string{},
+	}
+	for _, city := range catalogue.Cities() {
+		for provider, externalID := range city.ProviderIDs {
+			store.setLocked(provider, city.ID, externalID)
+		}
+	}
+	return store
+}
+
+func (s *CityMappingStore) setLocked(provider, locationID, externalID string) {
+	if s.ids[provider] == nil {
+		s.ids[provider] = map[string]string{}
+	}
+	s.ids[provider][locationID] = externalID
+}
+
+// ExternalID returns the provider's ID for a city name, or "" if unmapped
+func (s *CityMappingStore) ExternalID(provider, city string) string {
+	record, ok := s.catalogue.Resolve(city)
+	if !ok {
+		return ""
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ids[provider][record.ID]
+}
+
+// Mappings lists the mappings for one provider, or for all when provider
+// is empty, sorted by provider and location
+func (s *CityMappingStore) Mappings(provider string) []CityMapping {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mappings := []CityMapping{}
+	for p, ids := range s.ids {
+		if provider != "" && p != provider {
+			continue
+		}
+		for locationID, externalID := range ids {
+			mappings = append(mappings, CityMapping{Provider: p, LocationID: locationID, ExternalID: externalID})
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		if mappings[i].Provider != mappings[j].Provider {
+			return mappings[i].Provider < mappings[j].Provider
+		}
+		return mappings[i].LocationID < mappings[j].LocationID
+	})
+	return mappings
+}
+
+// Import adds mappings for cities that exactly match a catalogue name,
+// alias or ID. Near misses are not guessed at, since a town like "Nagaur"
+// would otherwise overwrite Nagpur's ID. It returns how many were stored and
+// the cities that did not match.
+func (s *CityMappingStore) Import(mappings []CityMapping) (int, []string) {
+	type resolved struct {
+		provider, locationID, externalID string
+	}
+
+	var valid []resolved
+	var unknown []string
+	for _, m := range mappings {
+		record, ok := s.catalogue.Lookup(m.LocationID)
+		if !ok || m.Provider == "" || m.ExternalID == "" {
+			unknown = append(unknown, m.LocationID)
+			continue
+		}
+		valid = append(valid, resolved{m.Provider, record.ID, m.ExternalID})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range valid {
+		s.setLocked(r.provider, r.locationID, r.externalID)
+	}
+	return len(valid), unknown
+}
+
+// ImportCSV reads "provider,city,external_id" rows. A header row is
+// skipped if present.
+func (s *CityMappingStore) ImportCSV(r io.Reader) (int, []string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+
+	var mappings []CityMapping
+	for i, row := range rows {
+		if i == 0 && strings.EqualFold(row[0], "provider") {
+			continue
+		}
+		mappings = append(mappings, CityMapping{Provider: row[0], LocationID: row[1], ExternalID: row[2]})
+	}
+
+	imported, unknown := s.Import(mappings)
+	return imported, unknown, nil
+}
+
+// ImportJSON reads a list of CityMapping objects
+func (s *CityMappingStore) ImportJSON(r io.Reader) (int, []string, error) {
+	var mappings []CityMapping
+	if err := json.NewDecoder(r).Decode(&mappings); err != nil {
+		return 0, nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	imported, unknown := s.Import(mappings)
+	return imported, unknown, nil
+}
+
+// ImportFile imports a .csv or .json mapping file
+func (s *CityMappingStore) ImportFile(path string) (int, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		return s.ImportCSV(f)
+	}
+	return s.ImportJSON(f)
+}
+
+// Refresh pulls a provider's city list and maps every city whose name is
+// in the catalogue
+func (s *CityMappingStore) Refresh(ctx context.Context, provider string, lister CityLister) (int, []string, error) {
+	cities, err := lister.ListCities(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list %s cities: %w", provider, err)
+	}
+
+	mappings := make([]CityMapping, 0, len(cities))
+	for _, city := range cities {
+		mappings = append(mappings, CityMapping{Provider: provider, LocationID: city.Name, ExternalID: city.ID})
+	}
+
+	imported, unknown := s.Import(mappings)
+	return imported, unknown, nil
+}
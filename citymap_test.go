@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type fakeCityLister struct {
+	cities []ProviderCity
+	err    error
+}
+
+func (f fakeCityLister) ListCities(ctx context.Context) ([]ProviderCity, error) {
+	return f.cities, f.err
+}
+
+func TestCityMappingRefresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		cities      []ProviderCity
+		wantCount   int
+		wantUnknown []string
+		want        map[string]string // city -> external ID after the refresh
+	}{
+		{
+			name:      "exact names and aliases",
+			cities:    []ProviderCity{{ID: "P-1", Name: "Pune"}, {ID: "B-1", Name: "Bombay"}, {ID: "D-1", Name: "new-delhi"}},
+			wantCount: 3,
+			want:      map[string]string{"Pune": "P-1", "Mumbai": "B-1", "Delhi": "D-1"},
+		},
+		{
+			name:        "near-miss towns are not mapped",
+			cities:      []ProviderCity{{ID: "NAGAUR77", Name: "Nagaur"}, {ID: "AJRA1", Name: "Ajra"}, {ID: "SURA1", Name: "Sura"}, {ID: "NAG2", Name: "Nagpur"}},
+			wantCount:   1,
+			wantUnknown: []string{"Nagaur", "Ajra", "Sura"},
+			want:        map[string]string{"Nagpur": "NAG2", "Agra": "", "Surat": "SURAT001"},
+		},
+		{
+			name:        "prefix is not enough",
+			cities:      []ProviderCity{{ID: "VAR", Name: "Varan"}},
+			wantUnknown: []string{"Varan"},
+			want:        map[string]string{"Varanasi": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewCityMappingStore(cityCatalogue)
+			count, unknown, err := store.Refresh(context.Background(), "redbus", fakeCityLister{cities: tt.cities})
+			if err != nil {
+				t.Fatalf("Refresh: %v", err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("imported %d, want %d", count, tt.wantCount)
+			}
+			if !slices.Equal(unknown, tt.wantUnknown) {
+				t.Errorf("unknown = %v, want %v", unknown, tt.wantUnknown)
+			}
+			for city, want := range tt.want {
+				if got := store.ExternalID("redbus", city); got != want {
+					t.Errorf("ExternalID(%s) = %q, want %q", city, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCityMappingRefreshError(t *testing.T) {
+	store := NewCityMappingStore(cityCatalogue)
+	upstream := errors.New("upstream down")
+	if _, _, err := store.Refresh(context.Background(), "redbus", fakeCityLister{err: upstream}); !errors.Is(err, upstream) {
+		t.Errorf("Refresh() = %v, want the lister's error", err)
+	}
+	if got := store.ExternalID("redbus", "Nagpur"); got != "NAGPUR001" {
+		t.Errorf("ExternalID(Nagpur) = %q after a failed refresh, want NAGPUR001", got)
+	}
+}
+
+func TestCityMappingImportCSV(t *testing.T) {
+	tests := []struct {
+		name        string
+		csv         string
+		provider    string // whose IDs want lists
+		wantCount   int
+		wantUnknown []string
+		wantErr     bool
+		want        map[string]string
+	}{
+		{
+			name:      "header and ids",
+			csv:       "provider,city,external_id\nabhibus,nagpur,AB-NGP\nabhibus,Poona,AB-PNQ\n",
+			provider:  "abhibus",
+			wantCount: 2,
+			want:      map[string]string{"Nagpur": "AB-NGP", "Pune": "AB-PNQ"},
+		},
+		{
+			name:        "near-miss town keeps the real city",
+			csv:         "redbus,Nagaur,NAGAUR77\nredbus,Sura,SURA1\n",
+			provider:    "redbus",
+			wantUnknown: []string{"Nagaur", "Sura"},
+			want:        map[string]string{"Nagpur": "NAGPUR001", "Surat": "SURAT001"},
+		},
+		{
+			name:        "missing provider or id",
+			csv:         ",Pune,X\nredbus,Pune,\n",
+			provider:    "redbus",
+			wantUnknown: []string{"Pune", "Pune"},
+			want:        map[string]string{"Pune": "PUNE001"},
+		},
+		{name: "wrong column count", csv: "redbus,Pune\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewCityMappingStore(cityCatalogue)
+			count, unknown, err := store.ImportCSV(strings.NewReader(tt.csv))
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("ImportCSV() error = %v, want error=%v", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Errorf("imported %d, want %d", count, tt.wantCount)
+			}
+			if !slices.Equal(unknown, tt.wantUnknown) {
+				t.Errorf("unknown = %v, want %v", unknown, tt.wantUnknown)
+			}
+			for city, want := range tt.want {
+				if got := store.ExternalID(tt.provider, city); got != want {
+					t.Errorf("ExternalID(%s, %s) = %q, want %q", tt.provider, city, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed data/rates.json
+var embeddedRates []byte
+
+// Currency defaults
+const (
+	DefaultBaseCurrency = "INR"
+	DefaultRatesRefresh = 6 * time.Hour
+
+	// MinRatesRefresh keeps a misconfigured interval from hammering the
+	// rates source; failed refreshes are retried after it
+	MinRatesRefresh = time.Minute
+
+	// ratesStartDelay is the least Run waits before its first refresh
+	ratesStartDelay = 5 * time.Second
+)
+
+// ErrUnknownCurrency is returned for a currency with no exchange rate
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// ErrNoRatesSource is returned when refreshing without a rates URL
+var ErrNoRatesSource = errors.New("no rates_url configured")
+
+// CurrencyConfig chooses the base currency and where rates come from
+type CurrencyConfig struct {
+	Base      string   `json:"base,omitempty"`       // DefaultBaseCurrency when unset
+	RatesFile string   `json:"rates_file,omitempty"` // embedded rates when unset
+	RatesURL  string   `json:"rates_url,omitempty"`  // refreshed from here when set
+	Refresh   Duration `json:"refresh,omitempty"`    // DefaultRatesRefresh when unset
+}
+
+// ExchangeRates is the rates file format: how many units of each currency
+// one unit of Base buys
+type ExchangeRates struct {
+	Base      string             `json:"base"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+// Money is an amount in a given currency
+type Money struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// currencyCode normalises a currency code, e.g. " usd" to "USD"
+func currencyCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// currencyOr returns code normalised, or fallback when code is empty
+func currencyOr(code, fallback string) string {
+	if code = currencyCode(code); code != "" {
+		return code
+	}
+	return currencyCode(fallback)
+}
+
+func validCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseExchangeRates reads and checks rates in the rates file format
+func ParseExchangeRates(data []byte) (ExchangeRates, error) {
+	var parsed ExchangeRates
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return ExchangeRates{}, fmt.Errorf("failed to parse rates: %v", err)
+	}
+
+	rates := ExchangeRates{
+		Base:      currencyCode(parsed.Base),
+		UpdatedAt: parsed.UpdatedAt,
+		Rates:     make(map[string]float64, len(parsed.Rates)+1),
+	}
+	if !validCurrencyCode(rates.Base) {
+		return ExchangeRates{}, fmt.Errorf("rates base %q is not a currency code", parsed.Base)
+	}
+	for code, rate := range parsed.Rates {
+		normalized := currencyCode(code)
+		if !validCurrencyCode(normalized) {
+			return ExchangeRates{}, fmt.Errorf("rate %q is not a currency code", code)
+		}
+		if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+			return ExchangeRates{}, fmt.Errorf("rate for %s must be positive", normalized)
+		}
+		rates.Rates[normalized] = rate
+	}
+	if rate, ok := rates.Rates[rates.Base]; ok && rate != 1 {
+		return ExchangeRates{}, fmt.Errorf("rate for the base currency %s must be 1", rates.Base)
+	}
+	rates.Rates[rates.Base] = 1
+	return rates, nil
+}
+
+// LoadExchangeRates reads a rates file
+func LoadExchangeRates(path string) (ExchangeRates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ExchangeRates{}, err
+	}
+	return ParseExchangeRates(data)
+}
+
+// CurrencyService converts prices between currencies. Every offer is
+// normalised to one base currency so offers quoted in different currencies
+// sort and compare correctly; rates may be quoted against any currency.
+type CurrencyService struct {
+	mu     sync.RWMutex
+	base   string
+	rates  ExchangeRates
+	file   string // rewritten after each refresh when set
+	url    string
+	client *http.Client
+}
+
+// currencies is the service used by the providers and handlers. It starts
+// with the embedded rates; main applies the configuration.
+var currencies = mustLoadEmbeddedRates()
+
+func mustLoadEmbeddedRates() *CurrencyService {
+	rates, err := ParseExchangeRates(embeddedRates)
+	if err != nil {
+		panic(fmt.Sprintf("embedded exchange rates are invalid: %v", err))
+	}
+	return &CurrencyService{
+		base:   DefaultBaseCurrency,
+		rates:  rates,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Configure sets the base currency and rates source. A rates file that
+// does not exist yet is created by the first refresh; until then the
+// current rates stay in use.
+func (c *CurrencyService) Configure(cfg CurrencyConfig) error {
+	rates := c.Rates()
+	if cfg.RatesFile != "" {
+		loaded, err := LoadExchangeRates(cfg.RatesFile)
+		switch {
+		case err == nil:
+			rates = loaded
+		case errors.Is(err, os.ErrNotExist) && cfg.RatesURL != "":
+			fmt.Printf("Warning: rates file %s does not exist yet; using built-in rates until the first refresh\n", cfg.RatesFile)
+		default:
+			return fmt.Errorf("loading rates from %s: %v", cfg.RatesFile, err)
+		}
+	}
+
+	base := currencyOr(cfg.Base, DefaultBaseCurrency)
+	if _, ok := rates.Rates[base]; !ok {
+		return fmt.Errorf("base currency %s: %w", base, ErrUnknownCurrency)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.base = base
+	c.rates = rates
+	c.file = cfg.RatesFile
+	c.url = cfg.RatesURL
+	return nil
+}
+
+// Base returns the currency prices are normalised to
+func (c *CurrencyService) Base() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.base
+}
+
+// Source returns the URL rates are refreshed from, if any
+func (c *CurrencyService) Source() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.url
+}
+
+// Rates returns a copy of the current rates
+func (c *CurrencyService) Rates() ExchangeRates {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rates := c.rates
+	rates.Rates = make(map[string]float64, len(c.rates.Rates))
+	for code, rate := range c.rates.Rates {
+		rates.Rates[code] = rate
+	}
+	return rates
+}
+
+// Supports reports whether there is a rate for a currency
+func (c *CurrencyService) Supports(code string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.rates.Rates[currencyCode(code)]
+	return ok
+}
+
+// Convert converts an amount between two currencies, rounded to cents
+func (c *CurrencyService) Convert(amount float64, from, to string) (float64, error) {
+	from, to = currencyCode(from), currencyCode(to)
+	if from == to {
+		return amount, nil
+	}
+	c.mu.RLock()
+	fromRate, fromOK := c.rates.Rates[from]
+	toRate, toOK := c.rates.Rates[to]
+	c.mu.RUnlock()
+	if !fromOK {
+		return 0, fmt.Errorf("%q: %w", from, ErrUnknownCurrency)
+	}
+	if !toOK {
+		return 0, fmt.Errorf("%q: %w", to, ErrUnknownCurrency)
+	}
+	return math.Round(amount/fromRate*toRate*100) / 100, nil
+}
+
+// Normalize sets the base currency amount on every route's price. Prices
+// in a currency without a rate keep their quoted amount and currency, so
+// they only compare with prices in that same currency.
+func (c *CurrencyService) Normalize(routes []Route) {
+	base := c.Base()
+	unknown := map[string]bool{}
+	for i := range routes {
+		price := &routes[i].Price
+		amount, err := c.Convert(price.Amount, price.Currency, base)
+		if err != nil {
+			price.BaseAmount, price.BaseCurrency = price.Amount, currencyCode(price.Currency)
+			unknown[price.Currency] = true
+			continue
+		}
+		price.BaseAmount, price.BaseCurrency = amount, base
+	}
+	for code := range unknown {
+		fmt.Printf("Warning: no exchange rate for %q; those prices are not normalised\n", code)
+	}
+}
+
+// display converts a comparable amount into the display currency. Amounts
+// that cannot be converted are left without a display price.
+func (c *CurrencyService) display(amount float64, currency, code string) *Money {
+	if code == "" {
+		return nil
+	}
+	converted, err := c.Convert(amount, currency, code)
+	if err != nil {
+		return nil
+	}
+	return &Money{Amount: converted, Currency: code}
+}
+
+// DisplayPrice sets a price's display amount in code, leaving its quoted
+// amount and currency as they are
+func (c *CurrencyService) DisplayPrice(price *Price, code string) {
+	price.Display = c.display(price.Amount, price.Currency, code)
+}
+
+// DisplayRoutes returns a copy of routes with display prices in code
+func (c *CurrencyService) DisplayRoutes(routes []Route, code string) []Route {
+	if code == "" {
+		return routes
+	}
+	shown := append([]Route(nil), routes...)
+	for i := range shown {
+		c.DisplayPrice(&shown[i].Price, code)
+	}
+	return shown
+}
+
+// DisplayTrips returns a copy of trips with every offer priced in code
+func (c *CurrencyService) DisplayTrips(trips []Trip, code string) []Trip {
+	if code == "" {
+		return trips
+	}
+	shown := append([]Trip(nil), trips...)
+	for i := range shown {
+		offers := append([]Price(nil), shown[i].Offers...)
+		for j := range offers {
+			c.DisplayPrice(&offers[j], code)
+		}
+		shown[i].Offers = offers
+	}
+	return shown
+}
+
+// DisplayItineraries returns a copy of itineraries with their legs and
+// totals priced in code
+func (c *CurrencyService) DisplayItineraries(itineraries []Itinerary, code string) []Itinerary {
+	if code == "" {
+		return itineraries
+	}
+	shown := append([]Itinerary(nil), itineraries...)
+	for i := range shown {
+		shown[i].Legs = c.DisplayRoutes(shown[i].Legs, code)
+		shown[i].DisplayTotal = c.display(shown[i].TotalPrice, shown[i].Currency, code)
+	}
+	return shown
+}
+
+// Refresh fetches rates from the configured URL and, when there is a rates
+// file, saves them there for the next start
+func (c *CurrencyService) Refresh(ctx context.Context) error {
+	c.mu.RLock()
+	url, file, base := c.url, c.file, c.base
+	c.mu.RUnlock()
+	if url == "" {
+		return ErrNoRatesSource
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching rates: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching rates: %s answered %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("fetching rates: %v", err)
+	}
+	rates, err := ParseExchangeRates(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := rates.Rates[base]; !ok {
+		return fmt.Errorf("fetched rates have no rate for the base currency %s", base)
+	}
+	if rates.UpdatedAt.IsZero() {
+		rates.UpdatedAt = time.Now().UTC()
+	}
+
+	if file != "" {
+		if err := writeRatesFile(file, rates); err != nil {
+			return fmt.Errorf("saving rates to %s: %v", file, err)
+		}
+	}
+	c.mu.Lock()
+	c.rates = rates
+	c.mu.Unlock()
+	return nil
+}
+
+// writeRatesFile replaces the rates file in one step, so a crash never
+// leaves it half written
+func writeRatesFile(path string, rates ExchangeRates) error {
+	data, err := json.MarshalIndent(rates, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".rates-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Run refreshes the rates every interval until ctx is done, starting at
+// once if the current rates are already older than that. A failed refresh
+// keeps the previous rates and is retried sooner.
+func (c *CurrencyService) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRatesRefresh
+	}
+	interval = max(interval, MinRatesRefresh)
+
+	// The source may be this server's own stand-in, so let it start first
+	wait := max(time.Until(c.Rates().UpdatedAt.Add(interval)), ratesStartDelay)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := c.Refresh(ctx); err != nil {
+			fmt.Printf("Warning: exchange rate refresh failed: %v\n", err)
+			wait = MinRatesRefresh
+			continue
+		}
+		fmt.Printf("💱 Exchange rates refreshed from %s\n", c.Source())
+		wait = interval
+	}
+}
+
+// ratesStandIn plays a rates provider for local testing: it serves the
+// built-in rates, nudged by up to 1% each time so refreshes are visible
+func ratesStandIn(w http.ResponseWriter, r *http.Request) {
+	rates, err := ParseExchangeRates(embeddedRates)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, Response{Status: "error", Message: err.Error()})
+		return
+	}
+	for code, rate := range rates.Rates {
+		if code != rates.Base {
+			rates.Rates[code] = math.Round(rate*(1+(rand.Float64()*2-1)*0.01)*1e6) / 1e6
+		}
+	}
+	rates.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
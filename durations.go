@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatDuration renders a journey time as "8h 30m", or "1d 2h 30m" for
+// journeys of a day or more
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	minutes := int(d.Round(time.Minute).Minutes())
+	days, hours, minutes := minutes/(24*60), minutes/60%24, minutes%60
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+// parseProviderDuration reads the journey times providers send, such as
+// "8h 30m", "8h30m", "1d 2h", "08:30" or "510" (minutes)
+func parseProviderDuration(s string) (time.Duration, bool) {
+	s = strings.ToLower(strings.ReplaceAll(s, " ", ""))
+	if s == "" {
+		return 0, false
+	}
+
+	if minutes, err := strconv.Atoi(s); err == nil {
+		return time.Duration(minutes) * time.Minute, minutes >= 0
+	}
+
+	if hh, mm, ok := strings.Cut(s, ":"); ok {
+		hours, err1 := strconv.Atoi(hh)
+		minutes, err2 := strconv.Atoi(mm)
+		if err1 != nil || err2 != nil || hours < 0 || minutes < 0 || minutes > 59 {
+			return 0, false
+		}
+		return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, true
+	}
+
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'h': time.Hour, 'm': time.Minute}
+	var total time.Duration
+	number := ""
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			number += string(c)
+		case units[c] != 0 && number != "":
+			n, _ := strconv.Atoi(number)
+			total += time.Duration(n) * units[c]
+			number = ""
+			// Accept "hrs", "mins" and the like
+			for i+1 < len(s) && s[i+1] >= 'a' && s[i+1] <= 'z' {
+				i++
+			}
+		default:
+			return 0, false
+		}
+	}
+	if number != "" {
+		return 0, false
+	}
+	return total, true
+}
+
+// scheduleJourney turns a provider's local "15:04" departure and arrival
+// clock times into instants. The departure is on date in from's time zone;
+// the arrival is the first matching clock time in to's zone after it. When
+// the provider also states the journey time, the arrival is pushed on by
+// whole days until it agrees, which places multi-day journeys correctly.
+func scheduleJourney(date time.Time, from, to Location, departure, arrival, duration string) (time.Time, time.Time, error) {
+	depClock, err := time.Parse("15:04", departure)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid departure time: %v", err)
+	}
+	arrClock, err := time.Parse("15:04", arrival)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid arrival time: %v", err)
+	}
+
+	year, month, day := date.Date()
+	departAt := time.Date(year, month, day, depClock.Hour(), depClock.Minute(), 0, 0, from.TimeZone())
+
+	// Start from the departure's calendar day at the destination
+	year, month, day = departAt.In(to.TimeZone()).Date()
+	arriveAt := time.Date(year, month, day, arrClock.Hour(), arrClock.Minute(), 0, 0, to.TimeZone())
+	for !arriveAt.After(departAt) {
+		arriveAt = arriveAt.AddDate(0, 0, 1)
+	}
+
+	if stated, ok := parseProviderDuration(duration); ok {
+		for arriveAt.Sub(departAt) < stated-12*time.Hour {
+			arriveAt = arriveAt.AddDate(0, 0, 1)
+		}
+	}
+
+	return departAt, arriveAt, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProviderDuration(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "8h30m", want: 510 * time.Minute, wantOK: true},
+		{value: "8h 30m", want: 510 * time.Minute, wantOK: true},
+		{value: "2hrs 30mins", want: 150 * time.Minute, wantOK: true},
+		{value: "2 Hours 5 Minutes", want: 125 * time.Minute, wantOK: true},
+		{value: "1d 2h", want: 26 * time.Hour, wantOK: true},
+		{value: "08:30", want: 510 * time.Minute, wantOK: true},
+		{value: "26:05", want: 26*time.Hour + 5*time.Minute, wantOK: true},
+		{value: "510", want: 510 * time.Minute, wantOK: true},
+		{value: "0", want: 0, wantOK: true},
+		{value: "", wantOK: false},
+		{value: "-5", wantOK: false},
+		{value: "08:75", wantOK: false},
+		{value: "8:x", wantOK: false},
+		{value: "8h30", wantOK: false},
+		{value: "h30m", wantOK: false},
+		{value: "8 weeks", wantOK: false},
+		{value: "soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseProviderDuration(tt.value)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("parseProviderDuration(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestScheduleJourney(t *testing.T) {
+	india := Location{City: "Mumbai", Timezone: "Asia/Kolkata"}
+	nepal := Location{City: "Kathmandu", Timezone: "Asia/Kathmandu"} // IST + 0:15
+	bangladesh := Location{City: "Dhaka", Timezone: "Asia/Dhaka"}    // IST + 0:30
+	date := time.Date(2026, 11, 20, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name               string
+		from, to           Location
+		departure, arrival string
+		duration           string
+		wantDeparture      string // RFC3339 in from's zone
+		wantArrival        string // RFC3339 in to's zone
+		wantErr            bool
+	}{
+		{
+			name: "same day", from: india, to: india, departure: "08:00", arrival: "14:30",
+			wantDeparture: "2026-11-20T08:00:00+05:30", wantArrival: "2026-11-20T14:30:00+05:30",
+		},
+		{
+			name: "overnight", from: india, to: india, departure: "21:00", arrival: "06:30",
+			wantDeparture: "2026-11-20T21:00:00+05:30", wantArrival: "2026-11-21T06:30:00+05:30",
+		},
+		{
+			name: "stated duration agrees", from: india, to: india, departure: "21:00", arrival: "06:30", duration: "9h 30m",
+			wantDeparture: "2026-11-20T21:00:00+05:30", wantArrival: "2026-11-21T06:30:00+05:30",
+		},
+		{
+			name: "multi-day from stated duration", from: india, to: india, departure: "18:00", arrival: "20:00", duration: "50h",
+			wantDeparture: "2026-11-20T18:00:00+05:30", wantArrival: "2026-11-22T20:00:00+05:30",
+		},
+		{
+			name: "overnight plus a day", from: india, to: india, departure: "22:00", arrival: "06:00", duration: "1d 8h",
+			wantDeparture: "2026-11-20T22:00:00+05:30", wantArrival: "2026-11-22T06:00:00+05:30",
+		},
+		{
+			name: "unreadable duration is ignored", from: india, to: india, departure: "21:00", arrival: "06:30", duration: "long",
+			wantDeparture: "2026-11-20T21:00:00+05:30", wantArrival: "2026-11-21T06:30:00+05:30",
+		},
+		{
+			name: "cross-zone overnight", from: india, to: nepal, departure: "22:00", arrival: "07:15",
+			wantDeparture: "2026-11-20T22:00:00+05:30", wantArrival: "2026-11-21T07:15:00+05:45",
+		},
+		{
+			name: "arrival clock behind departure clock", from: bangladesh, to: india, departure: "08:00", arrival: "07:45",
+			wantDeparture: "2026-11-20T08:00:00+06:00", wantArrival: "2026-11-20T07:45:00+05:30",
+		},
+		{
+			name: "departure is already tomorrow at destination", from: india, to: bangladesh, departure: "23:50", arrival: "06:00",
+			wantDeparture: "2026-11-20T23:50:00+05:30", wantArrival: "2026-11-21T06:00:00+06:00",
+		},
+		{name: "invalid departure", from: india, to: india, departure: "25:00", arrival: "06:00", wantErr: true},
+		{name: "invalid arrival", from: india, to: india, departure: "08:00", arrival: "6pm", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			departAt, arriveAt, err := scheduleJourney(date, tt.from, tt.to, tt.departure, tt.arrival, tt.duration)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("scheduleJourney() = %s, %s; want an error", departAt, arriveAt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("scheduleJourney: %v", err)
+			}
+			if got := departAt.Format(time.RFC3339); got != tt.wantDeparture {
+				t.Errorf("departure = %s, want %s", got, tt.wantDeparture)
+			}
+			if got := arriveAt.In(tt.to.TimeZone()).Format(time.RFC3339); got != tt.wantArrival {
+				t.Errorf("arrival = %s, want %s", got, tt.wantArrival)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 510 * time.Minute, want: "8h 30m"},
+		{d: 26*time.Hour + 5*time.Minute, want: "1d 2h 5m"},
+		{d: 90*time.Second + 29*time.Second, want: "0h 2m"},
+		{d: -time.Hour, want: "0h 0m"},
+	}
+
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fare calendar limits
+const (
+	DefaultCalendarDays = 7
+	MaxCalendarDays     = 31
+	DefaultCalendarTTL  = 30 * time.Minute
+
+	// calendarConcurrency is how many days are searched at once. Day
+	// searches also wait for each provider's token bucket rather than be
+	// refused, so a rate limited provider is paced instead of skipped.
+	calendarConcurrency = 3
+)
+
+// PlatformFare summarises one platform's fares for a day
+type PlatformFare struct {
+	ID         string  `json:"id"`
+	Platform   string  `json:"platform"`
+	Status     string  `json:"status"`
+	MinFare    float64 `json:"min_fare,omitempty"`
+	MedianFare float64 `json:"median_fare,omitempty"`
+	RouteCount int     `json:"route_count"`
+}
+
+// FareCalendarDay summarises fares across platforms for one travel date
+type FareCalendarDay struct {
+	Date       string         `json:"date"`
+	MinFare    float64        `json:"min_fare,omitempty"`
+	MedianFare float64        `json:"median_fare,omitempty"`
+	Currency   string         `json:"currency,omitempty"`
+	RouteCount int            `json:"route_count"`
+	Platforms  []PlatformFare `json:"platforms"`
+	Partial    bool           `json:"partial"`
+	Cache      string         `json:"cache"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// FareCalendar searches a range of dates and keeps each provider's fares
+// for a day for longer than the provider cache does, since fares for a
+// given day move slowly. Only successful provider results are cached, so a
+// later request re-searches just the providers that failed.
+type FareCalendar struct {
+	mu   sync.Mutex
+	days map[string]calendarEntry
+	ttl  time.Duration
+}
+
+// calendarEntry is one provider's fares for a day
+type calendarEntry struct {
+	fare      PlatformFare
+	fares     []float64
+	currency  string
+	fetchedAt time.Time
+}
+
+// fareCalendar is the calendar used by the /fare-calendar handler
+var fareCalendar = NewFareCalendar(DefaultCalendarTTL)
+
+func NewFareCalendar(ttl time.Duration) *FareCalendar {
+	return &FareCalendar{
+		days: map[string]calendarEntry{},
+		ttl:  ttl,
+	}
+}
+
+// calendarKey identifies a provider's fares for a day; it covers everything
+// that changes which routes are counted
+func calendarKey(providerID string, req SearchRequest) string {
+	req.Platforms = nil
+	return cacheKey("calendar|"+providerID, req)
+}
+
+// Days returns a summary for each of days dates starting at req.Date
+func (c *FareCalendar) Days(ctx context.Context, pm *RealPlatformManager, req SearchRequest, days int) ([]FareCalendarDay, error) {
+	results := make([]FareCalendarDay, days)
+	errs := make([]error, days)
+
+	ctx = WithBudgetWait(ctx)
+	sem := make(chan struct{}, calendarConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < days; i++ {
+		dayReq := req
+		dayReq.Date = req.Date.AddDate(0, 0, i)
+
+		wg.Add(1)
+		go func(i int, dayReq SearchRequest) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			results[i], errs[i] = c.day(ctx, pm, dayReq)
+		}(i, dayReq)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// day returns one date's summary, searching only the providers whose fares
+// for it are not cached
+func (c *FareCalendar) day(ctx context.Context, pm *RealPlatformManager, req SearchRequest) (FareCalendarDay, error) {
+	var providers []string
+	entries := map[string]calendarEntry{}
+	var missing []string
+
+	c.mu.Lock()
+	for _, p := range pm.platforms {
+		name := p.GetPlatformName()
+		id := pm.providerIDs[name]
+		if !req.AllowsPlatform(id, name) {
+			continue
+		}
+		providers = append(providers, id)
+		if entry, ok := c.days[calendarKey(id, req)]; ok && time.Since(entry.fetchedAt) < c.ttl {
+			entries[id] = entry
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	c.mu.Unlock()
+	if len(providers) == 0 {
+		return FareCalendarDay{}, ErrNoMatchingPlatforms
+	}
+
+	cache := CacheHit
+	var searchErr error
+	if len(missing) > 0 {
+		cache = CacheMiss
+		if len(missing) < len(providers) {
+			cache = "partial"
+		}
+
+		searchReq := req
+		searchReq.Platforms = missing
+		search, err := pm.SearchAllPlatforms(ctx, searchReq)
+		if err != nil && search == nil {
+			// Cancelled
+			return FareCalendarDay{}, err
+		}
+		searchErr = err
+
+		fresh := summariseProviders(search)
+		now := time.Now()
+		c.mu.Lock()
+		for id, entry := range fresh {
+			entries[id] = entry
+			if entry.fare.Status == PlatformStatusSuccess {
+				entry.fetchedAt = now
+				c.days[calendarKey(id, req)] = entry
+			}
+		}
+		c.pruneLocked()
+		c.mu.Unlock()
+	}
+
+	day := summariseFares(req.Date, providers, entries)
+	day.Cache = cache
+	if searchErr != nil {
+		day.Error = searchErr.Error()
+	}
+	return day, nil
+}
+
+func (c *FareCalendar) pruneLocked() {
+	for key, entry := range c.days {
+		if time.Since(entry.fetchedAt) >= c.ttl {
+			delete(c.days, key)
+		}
+	}
+}
+
+// summariseProviders splits a search's filtered routes into each
+// provider's fares
+func summariseProviders(search *AggregatedSearch) map[string]calendarEntry {
+	entries := make(map[string]calendarEntry, len(search.Platforms))
+	for _, platform := range search.Platforms {
+		entries[platform.ID] = calendarEntry{fare: PlatformFare{
+			ID:       platform.ID,
+			Platform: platform.Platform,
+			Status:   platform.Status,
+		}}
+	}
+	for _, route := range search.Routes {
+		entry := entries[route.Provider]
+		entry.fares = append(entry.fares, route.Price.Comparable())
+		entry.currency = route.Price.ComparableCurrency()
+		entries[route.Provider] = entry
+	}
+	for id, entry := range entries {
+		entry.fare.RouteCount = len(entry.fares)
+		entry.fare.MinFare, entry.fare.MedianFare = fareStats(entry.fares)
+		entries[id] = entry
+	}
+	return entries
+}
+
+// summariseFares builds a day's summary from each provider's fares, in
+// the order providers are listed
+func summariseFares(date time.Time, providers []string, entries map[string]calendarEntry) FareCalendarDay {
+	day := FareCalendarDay{
+		Date:      date.Format("2006-01-02"),
+		Platforms: make([]PlatformFare, 0, len(providers)),
+	}
+
+	var all []float64
+	succeeded := 0
+	for _, id := range providers {
+		entry, ok := entries[id]
+		if !ok {
+			continue
+		}
+		all = append(all, entry.fares...)
+		if day.Currency == "" {
+			day.Currency = entry.currency
+		}
+		if entry.fare.Status == PlatformStatusSuccess {
+			succeeded++
+		}
+		day.Platforms = append(day.Platforms, entry.fare)
+	}
+
+	day.Partial = succeeded > 0 && succeeded < len(day.Platforms)
+	day.RouteCount = len(all)
+	day.MinFare, day.MedianFare = fareStats(all)
+	return day
+}
+
+// fareStats returns the minimum and median of fares, or zeros when empty
+func fareStats(fares []float64) (float64, float64) {
+	if len(fares) == 0 {
+		return 0, 0
+	}
+	sorted := append([]float64(nil), fares...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	median := sorted[mid]
+	if len(sorted)%2 == 0 {
+		median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[0], median
+}
+
+// cheapestDay returns the date with the lowest fare, or "" if none has fares
+func cheapestDay(days []FareCalendarDay) string {
+	best := -1
+	for i, day := range days {
+		if day.RouteCount > 0 && (best < 0 || day.MinFare < days[best].MinFare) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return days[best].Date
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fare trend limits
+const (
+	// DefaultFareLookback is how far back fare history is read by default
+	DefaultFareLookback = 90 * 24 * time.Hour
+
+	// MaxTrendDays groups every observation made earlier than this many
+	// days before departure into one bucket
+	MaxTrendDays = 60
+
+	// minTrendObservations is how many observations a days-before bucket
+	// needs before the buy/wait hint relies on it
+	minTrendObservations = 3
+
+	// nearbyTrendDays is how many days further from departure the hint
+	// may look for history when there is too little for today
+	nearbyTrendDays = 3
+
+	// waitThreshold is how much cheaper, as a fraction, fares must be
+	// expected to get before the hint says to wait
+	waitThreshold = 0.05
+)
+
+// Buy/wait hints
+const (
+	AdviceBuyNow  = "buy_now"
+	AdviceWait    = "wait"
+	AdviceUnknown = "not_enough_data"
+)
+
+// FareObservation is one route's fare as seen by one search
+type FareObservation struct {
+	ObservedAt    time.Time `json:"observed_at"`
+	FromCity      string    `json:"from_city"`
+	ToCity        string    `json:"to_city"`
+	Platform      string    `json:"platform"` // registry ID
+	RouteID       string    `json:"route_id"`
+	Operator      string    `json:"operator"`
+	BusType       string    `json:"bus_type"`
+	DepartureTime time.Time `json:"departure_time"`
+	DepartureDate string    `json:"departure_date"`
+	DaysBefore    int       `json:"days_before"`
+	Price         float64   `json:"price"`
+	Currency      string    `json:"currency"` // the base currency unless the fare had no rate
+}
+
+// seriesKey identifies one departure, so its fare can be followed from
+// search to search
+func (o FareObservation) seriesKey() string {
+	return strings.Join([]string{o.Platform, o.Operator, o.BusType, o.FromCity, o.ToCity,
+		o.DepartureTime.UTC().Format(time.RFC3339)}, "|")
+}
+
+// daysBefore counts calendar days from when a fare was seen to departure,
+// both taken in the origin's time zone
+func daysBefore(observed, departure time.Time, loc Location) int {
+	tz := loc.TimeZone()
+	y1, m1, d1 := observed.In(tz).Date()
+	y2, m2, d2 := departure.In(tz).Date()
+	from := time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// fareObservations turns a search's routes into observations. Fares are
+// recorded in the base currency, so providers quoting in other currencies
+// share one history.
+func fareObservations(routes []Route, at time.Time) []FareObservation {
+	observations := make([]FareObservation, 0, len(routes))
+	for _, route := range routes {
+		if route.Price.Comparable() <= 0 {
+			continue
+		}
+		observations = append(observations, FareObservation{
+			ObservedAt:    at,
+			FromCity:      route.From.City,
+			ToCity:        route.To.City,
+			Platform:      route.Provider,
+			RouteID:       route.ID,
+			Operator:      route.Operator.Name,
+			BusType:       route.BusType.Name,
+			DepartureTime: route.DepartureTime,
+			DepartureDate: route.DepartureTime.Format("2006-01-02"),
+			DaysBefore:    daysBefore(at, route.DepartureTime, route.From),
+			Price:         route.Price.Comparable(),
+			Currency:      route.Price.ComparableCurrency(),
+		})
+	}
+	return observations
+}
+
+// FareQuery narrows fare history; empty fields match everything
+type FareQuery struct {
+	FromCity      string
+	ToCity        string
+	DepartureDate string
+	Operator      string
+	BusType       string
+	Platform      string
+	Currency      string
+	Since         time.Time
+}
+
+func (q FareQuery) matches(o FareObservation) bool {
+	return (q.DepartureDate == "" || o.DepartureDate == q.DepartureDate) &&
+		(q.Operator == "" || strings.EqualFold(o.Operator, q.Operator)) &&
+		(q.BusType == "" || strings.EqualFold(o.BusType, q.BusType)) &&
+		(q.Platform == "" || strings.EqualFold(o.Platform, q.Platform)) &&
+		(q.Currency == "" || o.Currency == q.Currency)
+}
+
+// fareHistory reads the observations matching q
+func fareHistory(s Store, q FareQuery) ([]FareObservation, error) {
+	all, err := s.FareObservations(q.FromCity, q.ToCity, q.Since)
+	if err != nil {
+		return nil, err
+	}
+	var matched []FareObservation
+	for _, o := range all {
+		if q.matches(o) {
+			matched = append(matched, o)
+		}
+	}
+	return matched, nil
+}
+
+// FarePoint is one observation within a series
+type FarePoint struct {
+	ObservedAt time.Time `json:"observed_at"`
+	DaysBefore int       `json:"days_before"`
+	Price      float64   `json:"price"`
+}
+
+// FareSeries is how one departure's fare moved over time
+type FareSeries struct {
+	Platform      string      `json:"platform"`
+	Operator      string      `json:"operator"`
+	BusType       string      `json:"bus_type"`
+	DepartureTime time.Time   `json:"departure_time"`
+	DepartureDate string      `json:"departure_date"`
+	Currency      string      `json:"currency"`
+	Points        []FarePoint `json:"points"`
+	MinFare       float64     `json:"min_fare"`
+	MaxFare       float64     `json:"max_fare"`
+	LatestFare    float64     `json:"latest_fare"`
+}
+
+// FareSeriesOf groups observations by departure, earliest departure first;
+// points run oldest first
+func FareSeriesOf(observations []FareObservation) []FareSeries {
+	index := map[string]int{}
+	var series []FareSeries
+	for _, o := range observations {
+		i, ok := index[o.seriesKey()]
+		if !ok {
+			i = len(series)
+			index[o.seriesKey()] = i
+			series = append(series, FareSeries{
+				Platform:      o.Platform,
+				Operator:      o.Operator,
+				BusType:       o.BusType,
+				DepartureTime: o.DepartureTime,
+				DepartureDate: o.DepartureDate,
+				Currency:      o.Currency,
+				MinFare:       o.Price,
+				MaxFare:       o.Price,
+			})
+		}
+		s := &series[i]
+		s.Points = append(s.Points, FarePoint{ObservedAt: o.ObservedAt, DaysBefore: o.DaysBefore, Price: o.Price})
+		s.MinFare = min(s.MinFare, o.Price)
+		s.MaxFare = max(s.MaxFare, o.Price)
+	}
+
+	for i := range series {
+		points := series[i].Points
+		sort.SliceStable(points, func(a, b int) bool { return points[a].ObservedAt.Before(points[b].ObservedAt) })
+		series[i].LatestFare = points[len(points)-1].Price
+	}
+	sort.SliceStable(series, func(a, b int) bool { return series[a].DepartureTime.Before(series[b].DepartureTime) })
+	return series
+}
+
+// TrendPoint summarises fares seen a given number of days before departure.
+// Index compares them with each departure's average fare, so 1.1 means
+// fares were 10% above average at that point; unlike the fares themselves
+// it is not skewed by which buses happened to be searched.
+type TrendPoint struct {
+	DaysBefore   int     `json:"days_before"`
+	Observations int     `json:"observations"`
+	MinFare      float64 `json:"min_fare"`
+	MedianFare   float64 `json:"median_fare"`
+	Index        float64 `json:"index"`
+}
+
+// FareTrend is how fares move as departure approaches, nearest days first
+func FareTrend(observations []FareObservation) []TrendPoint {
+	// Each departure's average fare, to normalise its observations
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, o := range observations {
+		sums[o.seriesKey()] += o.Price
+		counts[o.seriesKey()]++
+	}
+
+	fares := map[int][]float64{}
+	ratios := map[int][]float64{}
+	for _, o := range observations {
+		days := min(max(o.DaysBefore, 0), MaxTrendDays)
+		mean := sums[o.seriesKey()] / float64(counts[o.seriesKey()])
+		fares[days] = append(fares[days], o.Price)
+		ratios[days] = append(ratios[days], o.Price/mean)
+	}
+
+	trend := make([]TrendPoint, 0, len(fares))
+	for days, bucket := range fares {
+		point := TrendPoint{DaysBefore: days, Observations: len(bucket)}
+		point.MinFare, point.MedianFare = fareStats(bucket)
+		_, index := fareStats(ratios[days])
+		point.Index = math.Round(index*1000) / 1000
+		trend = append(trend, point)
+	}
+	sort.Slice(trend, func(i, j int) bool { return trend[i].DaysBefore < trend[j].DaysBefore })
+	return trend
+}
+
+// FareHint advises whether to book now or wait for a cheaper fare
+type FareHint struct {
+	Advice         string  `json:"advice"`
+	Reason         string  `json:"reason"`
+	DaysBefore     int     `json:"days_before"`
+	BestDaysBefore *int    `json:"best_days_before,omitempty"`
+	ExpectedChange float64 `json:"expected_change_pct"`
+}
+
+// BuyOrWait compares fares at today's distance from departure with those
+// seen closer to departure. It only suggests waiting when some later point
+// with enough history has been clearly cheaper.
+func BuyOrWait(trend []TrendPoint, daysLeft int) FareHint {
+	hint := FareHint{Advice: AdviceUnknown, DaysBefore: daysLeft}
+	if daysLeft <= 0 {
+		hint.Advice = AdviceBuyNow
+		hint.Reason = "the bus leaves today"
+		return hint
+	}
+
+	// Today's bucket, or failing that one a few days further out with
+	// enough history
+	today := min(daysLeft, MaxTrendDays)
+	var now *TrendPoint
+	for i := range trend {
+		p := &trend[i]
+		if p.DaysBefore >= today && p.DaysBefore <= today+nearbyTrendDays && p.Observations >= minTrendObservations {
+			now = p
+			break
+		}
+	}
+	if now == nil {
+		hint.Reason = fmt.Sprintf("too few fares seen about %d days before departure", daysLeft)
+		return hint
+	}
+
+	var best *TrendPoint
+	for i := range trend {
+		p := &trend[i]
+		if p.DaysBefore < daysLeft && p.Observations >= minTrendObservations && (best == nil || p.Index < best.Index) {
+			best = p
+		}
+	}
+	if best == nil {
+		hint.Reason = "too few fares seen closer to departure"
+		return hint
+	}
+
+	change := (best.Index - now.Index) / now.Index
+	hint.ExpectedChange = math.Round(change*1000) / 10
+	if change <= -waitThreshold {
+		days := best.DaysBefore
+		hint.Advice = AdviceWait
+		hint.BestDaysBefore = &days
+		hint.Reason = fmt.Sprintf("fares have been about %.0f%% lower %d days before departure", -change*100, days)
+		return hint
+	}
+	hint.Advice = AdviceBuyNow
+	hint.Reason = "fares have not been meaningfully lower closer to departure"
+	return hint
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchFilters narrows a search. Zero values mean "no filter". Time
+// windows are "HH:MM" in the route's local time and may wrap midnight,
+// e.g. departure_after=22:00 with departure_before=06:00.
+type SearchFilters struct {
+	AC        *bool    `json:"ac,omitempty"`
+	Sleeper   *bool    `json:"sleeper,omitempty"` // false means seater
+	Amenities []string `json:"amenities,omitempty"`
+
+	DepartureAfter  string `json:"departure_after,omitempty"`
+	DepartureBefore string `json:"departure_before,omitempty"`
+	ArrivalAfter    string `json:"arrival_after,omitempty"`
+	ArrivalBefore   string `json:"arrival_before,omitempty"`
+
+	MinPrice  float64 `json:"min_price,omitempty"` // base currency
+	MaxPrice  float64 `json:"max_price,omitempty"` // base currency
+	MinRating float64 `json:"min_rating,omitempty"`
+
+	// Only routes with a boarding point within BoardWithinKM of BoardNear;
+	// the radius defaults to DefaultBoardRadiusKM
+	BoardNear     *GeoPoint `json:"board_near,omitempty"`
+	BoardWithinKM float64   `json:"board_within_km,omitempty"`
+
+	// Registry IDs or platform names to search; empty means all
+	Platforms []string `json:"platforms,omitempty"`
+}
+
+// FilterPushdown is implemented by providers whose API can apply search
+// filters itself. Only these providers receive the filters; results from
+// every provider are still filtered after aggregation.
+type FilterPushdown interface {
+	SupportsFilterPushdown() bool
+}
+
+// Validate checks that time windows parse and ranges make sense
+func (f SearchFilters) Validate() error {
+	for name, value := range map[string]string{
+		"departure_after":  f.DepartureAfter,
+		"departure_before": f.DepartureBefore,
+		"arrival_after":    f.ArrivalAfter,
+		"arrival_before":   f.ArrivalBefore,
+	} {
+		if _, err := parseClock(value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if f.MinPrice < 0 || f.MaxPrice < 0 {
+		return fmt.Errorf("prices must not be negative")
+	}
+	if f.MaxPrice > 0 && f.MinPrice > f.MaxPrice {
+		return fmt.Errorf("min_price is greater than max_price")
+	}
+	if f.MinRating < 0 || f.MinRating > 5 {
+		return fmt.Errorf("min_rating must be between 0 and 5")
+	}
+	if f.BoardNear != nil {
+		if err := f.BoardNear.Validate(); err != nil {
+			return fmt.Errorf("board_near: %v", err)
+		}
+	}
+	if f.BoardWithinKM < 0 {
+		return fmt.Errorf("board_within_km must not be negative")
+	}
+	return nil
+}
+
+// boardRadius returns the board-near radius in kilometres
+func (f SearchFilters) boardRadius() float64 {
+	if f.BoardWithinKM > 0 {
+		return f.BoardWithinKM
+	}
+	return DefaultBoardRadiusKM
+}
+
+// IsZero reports whether no route filter is set. The platform allow-list
+// is not a route filter; it is applied when fanning out.
+func (f SearchFilters) IsZero() bool {
+	return f.key() == ""
+}
+
+// key is a stable representation of the filters for cache keys
+func (f SearchFilters) key() string {
+	var parts []string
+	if f.AC != nil {
+		parts = append(parts, "ac="+strconv.FormatBool(*f.AC))
+	}
+	if f.Sleeper != nil {
+		parts = append(parts, "sleeper="+strconv.FormatBool(*f.Sleeper))
+	}
+	if len(f.Amenities) > 0 {
+		parts = append(parts, "amenities="+normalizeName(strings.Join(f.Amenities, " ")))
+	}
+	for name, value := range map[string]string{"da": f.DepartureAfter, "db": f.DepartureBefore, "aa": f.ArrivalAfter, "ab": f.ArrivalBefore} {
+		if value != "" {
+			parts = append(parts, name+"="+value)
+		}
+	}
+	if f.MinPrice > 0 {
+		parts = append(parts, fmt.Sprintf("min=%g", f.MinPrice))
+	}
+	if f.MaxPrice > 0 {
+		parts = append(parts, fmt.Sprintf("max=%g", f.MaxPrice))
+	}
+	if f.MinRating > 0 {
+		parts = append(parts, fmt.Sprintf("rating=%g", f.MinRating))
+	}
+	if f.BoardNear != nil {
+		parts = append(parts, fmt.Sprintf("near=%g,%g,%g", f.BoardNear.Lat, f.BoardNear.Lng, f.boardRadius()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+// AllowsPlatform reports whether the platform allow-list admits a provider
+func (f SearchFilters) AllowsPlatform(id, name string) bool {
+	if len(f.Platforms) == 0 {
+		return true
+	}
+	for _, allowed := range f.Platforms {
+		if strings.EqualFold(allowed, id) || strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the routes that pass every filter, preserving order. With
+// a board-near filter, the boarding points of the routes returned carry
+// their distance from the chosen point.
+func (f SearchFilters) Apply(routes []Route) []Route {
+	if f.IsZero() {
+		return routes
+	}
+
+	filtered := make([]Route, 0, len(routes))
+	for _, route := range routes {
+		if f.Matches(route) {
+			if f.BoardNear != nil {
+				route.BoardingPoints = withBoardingDistances(route.BoardingPoints, *f.BoardNear)
+			}
+			filtered = append(filtered, route)
+		}
+	}
+	return filtered
+}
+
+// Matches reports whether a single route passes every filter
+func (f SearchFilters) Matches(route Route) bool {
+	if f.AC != nil && isAC(route.BusType) != *f.AC {
+		return false
+	}
+	if f.Sleeper != nil && isSleeper(route.BusType) != *f.Sleeper {
+		return false
+	}
+	for _, amenity := range f.Amenities {
+		if !hasAmenity(route.BusType, amenity) {
+			return false
+		}
+	}
+
+	if !inClockWindow(route.DepartureTime, f.DepartureAfter, f.DepartureBefore) {
+		return false
+	}
+	if !inClockWindow(route.ArrivalTime, f.ArrivalAfter, f.ArrivalBefore) {
+		return false
+	}
+
+	if f.MinPrice > 0 && route.Price.Comparable() < f.MinPrice {
+		return false
+	}
+	if f.MaxPrice > 0 && route.Price.Comparable() > f.MaxPrice {
+		return false
+	}
+	if f.MinRating > 0 && route.Operator.Rating < f.MinRating {
+		return false
+	}
+	if f.BoardNear != nil {
+		if d, ok := nearestBoarding(route, *f.BoardNear); !ok || d > f.boardRadius() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// busTypeWords returns the lowercased words of a bus type's name and
+// amenities
+func busTypeWords(bt BusType) []string {
+	text := strings.ToLower(bt.Name + " " + strings.Join(bt.Amenities, " "))
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9')
+	})
+}
+
+// isAC treats "AC" anywhere in the name or amenities as air conditioned,
+// unless it is spelled "Non-AC"
+func isAC(bt BusType) bool {
+	words := busTypeWords(bt)
+	ac := false
+	for i, word := range words {
+		if word == "ac" {
+			if i > 0 && words[i-1] == "non" {
+				return false
+			}
+			ac = true
+		}
+	}
+	return ac
+}
+
+func isSleeper(bt BusType) bool {
+	for _, word := range busTypeWords(bt) {
+		if word == "sleeper" || word == "berth" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAmenity(bt BusType, amenity string) bool {
+	want := normalizeName(amenity)
+	for _, have := range bt.Amenities {
+		if normalizeName(have) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses "HH:MM" into minutes after midnight; "" is allowed and
+// returns -1
+func parseClock(value string) (int, error) {
+	if value == "" {
+		return -1, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inClockWindow checks the time of day of t against an after/before window
+func inClockWindow(t time.Time, after, before string) bool {
+	from, _ := parseClock(after)
+	to, _ := parseClock(before)
+	if from < 0 && to < 0 {
+		return true
+	}
+
+	minutes := t.Hour()*60 + t.Minute()
+	switch {
+	case from < 0:
+		return minutes <= to
+	case to < 0:
+		return minutes >= from
+	case from <= to:
+		return minutes >= from && minutes <= to
+	default: // wraps midnight
+		return minutes >= from || minutes <= to
+	}
+}
+
+// filterParams are the query parameters ParseFilterParams reads
+var filterParams = []string{
+	"ac", "sleeper", "bus_type", "amenities", "platforms",
+	"departure_after", "departure_before", "arrival_after", "arrival_before",
+	"min_price", "max_price", "min_rating", "board_near", "board_within_km",
+}
+
+// HasFilterParams reports whether any filter parameter is present
+func HasFilterParams(q url.Values) bool {
+	for _, name := range filterParams {
+		if _, ok := q[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFilterParams reads filters from /routes query parameters
+func ParseFilterParams(q url.Values) (SearchFilters, error) {
+	var f SearchFilters
+
+	parseBool := func(name string) (*bool, error) {
+		value := q.Get(name)
+		if value == "" {
+			return nil, nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", name)
+		}
+		return &b, nil
+	}
+	parseFloat := func(name string) (float64, error) {
+		value := q.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a number", name)
+		}
+		return n, nil
+	}
+	parseList := func(name string) []string {
+		var list []string
+		for _, value := range q[name] {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+		}
+		return list
+	}
+
+	var err error
+	if f.AC, err = parseBool("ac"); err != nil {
+		return f, err
+	}
+	if f.Sleeper, err = parseBool("sleeper"); err != nil {
+		return f, err
+	}
+	// bus_type=sleeper|seater is accepted as a friendlier spelling
+	switch strings.ToLower(q.Get("bus_type")) {
+	case "sleeper":
+		sleeper := true
+		f.Sleeper = &sleeper
+	case "seater":
+		sleeper := false
+		f.Sleeper = &sleeper
+	case "":
+	default:
+		return f, fmt.Errorf("bus_type must be sleeper or seater")
+	}
+
+	f.Amenities = parseList("amenities")
+	f.Platforms = parseList("platforms")
+	f.DepartureAfter = q.Get("departure_after")
+	f.DepartureBefore = q.Get("departure_before")
+	f.ArrivalAfter = q.Get("arrival_after")
+	f.ArrivalBefore = q.Get("arrival_before")
+
+	if f.MinPrice, err = parseFloat("min_price"); err != nil {
+		return f, err
+	}
+	if f.MaxPrice, err = parseFloat("max_price"); err != nil {
+		return f, err
+	}
+	if f.MinRating, err = parseFloat("min_rating"); err != nil {
+		return f, err
+	}
+	if value := q.Get("board_near"); value != "" {
+		near, err := parseGeoPoint(value)
+		if err != nil {
+			return f, fmt.Errorf("board_near: %v", err)
+		}
+		f.BoardNear = &near
+	}
+	if f.BoardWithinKM, err = parseFloat("board_within_km"); err != nil {
+		return f, err
+	}
+
+	return f, f.Validate()
+}
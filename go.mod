@@ -2,7 +2,10 @@ module hello
 
 go 1.25.0
 
-require github.com/gin-gonic/gin v1.10.1
+require (
+	github.com/gin-gonic/gin v1.10.1
+	go.etcd.io/bbolt v1.3.10
+)
 
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultHealthWindow is how many recent provider calls health stats cover
+const DefaultHealthWindow = 100
+
+// Provider health states
+const (
+	ProviderHealthy  = "healthy"
+	ProviderDegraded = "degraded"
+	ProviderDown     = "down"
+	ProviderUnknown  = "unknown"
+)
+
+// callSample is the outcome of one upstream provider call
+type callSample struct {
+	latency time.Duration
+	ok      bool
+}
+
+// providerHealth keeps a ring buffer of recent calls to one provider
+type providerHealth struct {
+	samples []callSample
+	next    int
+	full    bool
+
+	lastError     string
+	lastErrorAt   time.Time
+	lastSuccessAt time.Time
+}
+
+// HealthTracker records every upstream provider call made on behalf of
+// searches and summarises them for the status endpoint
+type HealthTracker struct {
+	mu        sync.Mutex
+	window    int
+	providers map[string]*providerHealth
+}
+
+func NewHealthTracker(window int) *HealthTracker {
+	return &HealthTracker{
+		window:    window,
+		providers: map[string]*providerHealth{},
+	}
+}
+
+// Record adds the outcome of one upstream call
+func (t *HealthTracker) Record(platform string, latency time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.providers[platform]
+	if !ok {
+		h = &providerHealth{samples: make([]callSample, t.window)}
+		t.providers[platform] = h
+	}
+
+	h.samples[h.next] = callSample{latency: latency, ok: err == nil}
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+
+	if err != nil {
+		h.lastError = err.Error()
+		h.lastErrorAt = time.Now()
+	} else {
+		h.lastSuccessAt = time.Now()
+	}
+}
+
+// HealthStats summarises a provider's recent calls
+type HealthStats struct {
+	Calls         int        `json:"calls"`
+	SuccessRate   float64    `json:"success_rate"`
+	P50LatencyMS  int64      `json:"p50_latency_ms"`
+	P95LatencyMS  int64      `json:"p95_latency_ms"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+}
+
+// Stats returns the rolling stats for a provider
+func (t *HealthTracker) Stats(platform string) HealthStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.providers[platform]
+	if !ok {
+		return HealthStats{}
+	}
+
+	samples := h.samples[:h.next]
+	if h.full {
+		samples = h.samples
+	}
+
+	stats := HealthStats{Calls: len(samples), LastError: h.lastError}
+	if !h.lastErrorAt.IsZero() {
+		at := h.lastErrorAt
+		stats.LastErrorAt = &at
+	}
+	if !h.lastSuccessAt.IsZero() {
+		at := h.lastSuccessAt
+		stats.LastSuccessAt = &at
+	}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	latencies := make([]time.Duration, 0, len(samples))
+	succeeded := 0
+	for _, sample := range samples {
+		latencies = append(latencies, sample.latency)
+		if sample.ok {
+			succeeded++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats.SuccessRate = float64(succeeded) / float64(len(samples))
+	stats.P50LatencyMS = percentile(latencies, 0.50).Milliseconds()
+	stats.P95LatencyMS = percentile(latencies, 0.95).Milliseconds()
+	return stats
+}
+
+// percentile uses the nearest-rank method on sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// RateLimitReporter is implemented by providers that enforce a request
+// budget, so the status endpoint can show how much of it is left
+type RateLimitReporter interface {
+	RateLimitStatus() RateLimitStatus
+}
+
+// ProviderStatus is the status endpoint's view of one registered provider
+type ProviderStatus struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Weight    float64          `json:"weight"`
+	Status    string           `json:"status"`
+	Health    HealthStats      `json:"health"`
+	Breaker   BreakerStatus    `json:"circuit_breaker"`
+	RateLimit *RateLimitStatus `json:"rate_limit,omitempty"`
+}
+
+// providerState derives an overall state from the breaker and recent calls
+func providerState(stats HealthStats, breaker BreakerStatus) string {
+	switch {
+	case breaker.State == CircuitOpen:
+		return ProviderDown
+	case stats.Calls == 0:
+		return ProviderUnknown
+	case breaker.State == CircuitHalfOpen || stats.SuccessRate < 0.8:
+		return ProviderDegraded
+	default:
+		return ProviderHealthy
+	}
+}
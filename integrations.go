@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,50 +24,88 @@ type APIConfig struct {
 	SecretKey string
 	UserAgent string
 	Timeout   time.Duration
-	RateLimit time.Duration
+
+	// Local request budget; zero values mean unlimited
+	RequestsPerSecond float64
+	Burst             int
+	DailyQuota        int
+
+	// Retry policy for idempotent requests; DefaultRetryPolicy if unset
+	Retry RetryPolicy
 }
 
 // HTTPClient wraps http.Client with additional functionality
 type HTTPClient struct {
-	client   *http.Client
-	config   APIConfig
-	lastCall time.Time
+	client  *http.Client
+	config  APIConfig
+	limiter *RateLimiter
 }
 
 func NewHTTPClient(config APIConfig) *HTTPClient {
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry = DefaultRetryPolicy
+	}
+
 	return &HTTPClient{
 		client: &http.Client{
 			Timeout: config.Timeout,
 		},
-		config: config,
+		config:  config,
+		limiter: NewRateLimiter(config.RequestsPerSecond, config.Burst, config.DailyQuota),
 	}
 }
 
-// RateLimit ensures we don't exceed API rate limits
-func (h *HTTPClient) RateLimit() {
-	if h.config.RateLimit > 0 {
-		elapsed := time.Since(h.lastCall)
-		if elapsed < h.config.RateLimit {
-			time.Sleep(h.config.RateLimit - elapsed)
+// MakeRequest performs HTTP request with proper headers and error handling.
+// The request is aborted as soon as ctx is cancelled or its deadline passes.
+// When the rate limit budget is spent it fails fast with a *RateLimitError
+// rather than waiting for a token.
+//
+// Idempotent requests (GET, HEAD) are retried on transient failures
+// according to the client's retry policy. Failed responses come back as
+// *APIError and network failures as *TransportError.
+func (h *HTTPClient) MakeRequest(ctx context.Context, method, endpoint string, headers map[string]string, body interface{}) ([]byte, error) {
+	var jsonData []byte
+	if body != nil {
+		var err error
+		jsonData, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %v", err)
 		}
 	}
-	h.lastCall = time.Now()
+
+	if method != http.MethodGet && method != http.MethodHead {
+		if err := h.limiter.take(ctx); err != nil {
+			return nil, err
+		}
+		return h.doRequest(ctx, method, endpoint, headers, jsonData)
+	}
+
+	// The first attempt fails fast when the budget is spent, unless ctx
+	// says to wait; retries always wait for a token so a local limit never
+	// hides the upstream failure
+	if err := h.limiter.take(ctx); err != nil {
+		return nil, err
+	}
+
+	var responseBody []byte
+	err := h.config.Retry.Do(ctx, h.limiter.Wait, func() error {
+		var err error
+		responseBody, err = h.doRequest(ctx, method, endpoint, headers, jsonData)
+		return err
+	})
+	return responseBody, err
 }
 
-// MakeRequest performs HTTP request with proper headers and error handling
-func (h *HTTPClient) MakeRequest(method, endpoint string, headers map[string]string, body interface{}) ([]byte, error) {
-	h.RateLimit()
+// doRequest makes a single attempt at a request. The caller has already
+// taken a token from the limiter.
+func (h *HTTPClient) doRequest(ctx context.Context, method, endpoint string, headers map[string]string, jsonData []byte) ([]byte, error) {
 
 	var reqBody io.Reader
-	if body != nil {
-		jsonData, err := json.Marshal(body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request body: %v", err)
-		}
+	if jsonData != nil {
 		reqBody = bytes.NewReader(jsonData)
 	}
 
-	req, err := http.NewRequest(method, h.config.BaseURL+endpoint, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, h.config.BaseURL+endpoint, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -85,17 +128,24 @@ func (h *HTTPClient) MakeRequest(method, endpoint string, headers map[string]str
 
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
+		return nil, &TransportError{Err: err}
 	}
 	defer resp.Body.Close()
 
+	h.limiter.Observe(resp)
+
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return nil, &TransportError{Err: fmt.Errorf("failed to read response: %w", err)}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return responseBody, &RateLimitError{Reason: "upstream returned 429", RetryAfter: retryAfter}
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return responseBody, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(responseBody))
+		return responseBody, &APIError{StatusCode: resp.StatusCode, Body: string(responseBody)}
 	}
 
 	return responseBody, nil
@@ -103,22 +153,51 @@ func (h *HTTPClient) MakeRequest(method, endpoint string, headers map[string]str
 
 // RealRedBusService integrates with actual RedBus API
 type RealRedBusService struct {
-	Name   string
-	client *HTTPClient
+	Name     string
+	Currency string // when a route does not give its own
+	client   *HTTPClient
 }
 
-func NewRealRedBusService(apiKey string) *RealRedBusService {
-	config := APIConfig{
+// redBusProviderID is the registry ID, also used to key RedBus city IDs
+const redBusProviderID = "redbus"
+
+func init() {
+	RegisterProvider(redBusProviderID, func(cfg ProviderConfig) (PlatformService, error) {
+		if cfg.APIKey == "" {
+			return nil, ErrMissingAPIKey
+		}
+		service := newRealRedBusService(cfg.apiConfig(defaultRedBusConfig()))
+		if cfg.Name != "" {
+			service.Name = cfg.Name
+		}
+		service.Currency = currencyOr(cfg.Currency, service.Currency)
+		return service, nil
+	})
+}
+
+func defaultRedBusConfig() APIConfig {
+	return APIConfig{
 		BaseURL:   "https://api.redbus.com/v1", // Note: This is a placeholder - actual endpoint may differ
-		APIKey:    apiKey,
 		UserAgent: "BusAggregator/1.0",
 		Timeout:   30 * time.Second,
-		RateLimit: 1 * time.Second, // 1 request per second
+
+		RequestsPerSecond: 1, // 1 request per second
+		Burst:             2,
+		DailyQuota:        10000,
 	}
+}
+
+func NewRealRedBusService(apiKey string) *RealRedBusService {
+	config := defaultRedBusConfig()
+	config.APIKey = apiKey
+	return newRealRedBusService(config)
+}
 
+func newRealRedBusService(config APIConfig) *RealRedBusService {
 	return &RealRedBusService{
-		Name:   "RedBus",
-		client: NewHTTPClient(config),
+		Name:     "RedBus",
+		Currency: "INR",
+		client:   NewHTTPClient(config),
 	}
 }
 
@@ -126,6 +205,10 @@ func (r *RealRedBusService) GetPlatformName() string {
 	return r.Name
 }
 
+func (r *RealRedBusService) RateLimitStatus() RateLimitStatus {
+	return r.client.limiter.Status()
+}
+
 // RedBusSearchRequest represents the API request format
 type RedBusSearchRequest struct {
 	FromCityID    string `json:"fromCityId"`
@@ -136,32 +219,43 @@ type RedBusSearchRequest struct {
 
 // RedBusRoute represents the API response format
 type RedBusRoute struct {
-	ID             string   `json:"id"`
-	OperatorName   string   `json:"operatorName"`
-	BusType        string   `json:"busType"`
-	DepartureTime  string   `json:"departureTime"`
-	ArrivalTime    string   `json:"arrivalTime"`
-	Duration       string   `json:"duration"`
-	Fare           float64  `json:"fare"`
-	AvailableSeats int      `json:"availableSeats"`
-	Amenities      []string `json:"amenities"`
-	BoardingPoints []string `json:"boardingPoints"`
-	DroppingPoints []string `json:"droppingPoints"`
-}
-
-func (r *RealRedBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
+	ID             string        `json:"id"`
+	OperatorName   string        `json:"operatorName"`
+	BusType        string        `json:"busType"`
+	DepartureTime  string        `json:"departureTime"`
+	ArrivalTime    string        `json:"arrivalTime"`
+	Duration       string        `json:"duration"`
+	Fare           float64       `json:"fare"`
+	Currency       string        `json:"currency"`
+	AvailableSeats int           `json:"availableSeats"`
+	Amenities      []string      `json:"amenities"`
+	BoardingPoints []redBusPoint `json:"boardingPoints"`
+	DroppingPoints []redBusPoint `json:"droppingPoints"`
+}
+
+func (r *RealRedBusService) SearchRoutes(ctx context.Context, req SearchRequest) ([]Route, error) {
+	// RedBus needs its own city IDs; don't waste a call on cities it can't serve
+	fromCityID := r.getCityID(req.FromCity)
+	if fromCityID == "" {
+		return nil, &UnsupportedCityError{Provider: r.Name, City: req.FromCity}
+	}
+	toCityID := r.getCityID(req.ToCity)
+	if toCityID == "" {
+		return nil, &UnsupportedCityError{Provider: r.Name, City: req.ToCity}
+	}
+
 	// Convert our internal request format to RedBus API format
 	redBusReq := RedBusSearchRequest{
-		FromCityID:    r.getCityID(req.FromCity),
-		ToCityID:      r.getCityID(req.ToCity),
+		FromCityID:    fromCityID,
+		ToCityID:      toCityID,
 		DepartureDate: req.Date.Format("2006-01-02"),
 		Passengers:    req.Passengers,
 	}
 
 	endpoint := "/routes/search"
-	responseBody, err := r.client.MakeRequest("POST", endpoint, nil, redBusReq)
+	responseBody, err := r.client.MakeRequest(ctx, "POST", endpoint, nil, redBusReq)
 	if err != nil {
-		return nil, fmt.Errorf("RedBus API error: %v", err)
+		return nil, fmt.Errorf("RedBus API error: %w", err)
 	}
 
 	var apiResponse struct {
@@ -186,53 +280,64 @@ func (r *RealRedBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
 	return routes, nil
 }
 
-func (r *RealRedBusService) getCityID(cityName string) string {
-	// In real implementation, you'd have a mapping or call a cities API
-	cityMap := map[string]string{
-		"Mumbai":    "MUMBAI001",
-		"Pune":      "PUNE001",
-		"Bangalore": "BANGALORE001",
-		"Delhi":     "DELHI001",
+// SeatLayout fetches the seat map of a RedBus route
+func (r *RealRedBusService) SeatLayout(ctx context.Context, routeID string) (*SeatLayout, error) {
+	endpoint := "/routes/" + url.PathEscape(routeID) + "/seats"
+	responseBody, err := r.client.MakeRequest(ctx, "GET", endpoint, nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("RedBus route %s: %w", routeID, ErrRouteNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("RedBus API error: %w", err)
+	}
+
+	var apiResponse struct {
+		Status string       `json:"status"`
+		Data   []redBusSeat `json:"data"`
+	}
+	if err := json.Unmarshal(responseBody, &apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse RedBus seat layout: %v", err)
 	}
-	return cityMap[cityName]
+
+	return convertRedBusSeats(routeID, r.Currency, apiResponse.Data), nil
 }
 
-func (r *RealRedBusService) convertRedBusRoute(rbRoute RedBusRoute, req SearchRequest) (Route, error) {
-	locations := GetSampleLocations()
-	var fromLoc, toLoc Location
+func (r *RealRedBusService) getCityID(cityName string) string {
+	return cityMappings.ExternalID(redBusProviderID, cityName)
+}
 
-	for _, loc := range locations {
-		if loc.City == req.FromCity {
-			fromLoc = loc
-		}
-		if loc.City == req.ToCity {
-			toLoc = loc
-		}
+// ListCities fetches the cities RedBus serves along with their RedBus IDs
+func (r *RealRedBusService) ListCities(ctx context.Context) ([]ProviderCity, error) {
+	responseBody, err := r.client.MakeRequest(ctx, "GET", "/cities", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("RedBus API error: %w", err)
 	}
 
-	// Parse time strings
-	departureTime, err := time.Parse("15:04", rbRoute.DepartureTime)
-	if err != nil {
-		return Route{}, fmt.Errorf("invalid departure time: %v", err)
+	var apiResponse struct {
+		Status string         `json:"status"`
+		Data   []ProviderCity `json:"data"`
 	}
 
-	arrivalTime, err := time.Parse("15:04", rbRoute.ArrivalTime)
-	if err != nil {
-		return Route{}, fmt.Errorf("invalid arrival time: %v", err)
+	if err := json.Unmarshal(responseBody, &apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse RedBus cities response: %v", err)
 	}
 
-	// Combine with search date
-	departureDateTime := time.Date(req.Date.Year(), req.Date.Month(), req.Date.Day(),
-		departureTime.Hour(), departureTime.Minute(), 0, 0, req.Date.Location())
-	arrivalDateTime := time.Date(req.Date.Year(), req.Date.Month(), req.Date.Day(),
-		arrivalTime.Hour(), arrivalTime.Minute(), 0, 0, req.Date.Location())
+	return apiResponse.Data, nil
+}
+
+func (r *RealRedBusService) convertRedBusRoute(rbRoute RedBusRoute, req SearchRequest) (Route, error) {
+	fromLoc := cityCatalogue.Location(req.FromCity)
+	toLoc := cityCatalogue.Location(req.ToCity)
 
-	// Handle next day arrival
-	if arrivalTime.Before(departureTime) {
-		arrivalDateTime = arrivalDateTime.Add(24 * time.Hour)
+	// RedBus sends local clock times; place them in each city's time zone
+	departureTime, arrivalTime, err := scheduleJourney(req.Date, fromLoc, toLoc,
+		rbRoute.DepartureTime, rbRoute.ArrivalTime, rbRoute.Duration)
+	if err != nil {
+		return Route{}, err
 	}
 
-	return Route{
+	route := Route{
 		ID:   rbRoute.ID,
 		From: fromLoc,
 		To:   toLoc,
@@ -248,37 +353,65 @@ func (r *RealRedBusService) convertRedBusRoute(rbRoute RedBusRoute, req SearchRe
 			Amenities:   rbRoute.Amenities,
 			Description: rbRoute.BusType,
 		},
-		DepartureTime: departureDateTime,
-		ArrivalTime:   arrivalDateTime,
-		Duration:      rbRoute.Duration,
 		Price: Price{
 			Amount:   rbRoute.Fare,
-			Currency: "INR",
+			Currency: currencyOr(rbRoute.Currency, r.Currency),
 			Platform: "RedBus",
 		},
 		AvailableSeats: rbRoute.AvailableSeats,
 		BookingURL:     fmt.Sprintf("https://redbus.com/bus-tickets/%s", rbRoute.ID),
-	}, nil
+	}
+	route.SetTimes(departureTime, arrivalTime)
+	route.BoardingPoints = convertRedBusPoints(rbRoute.BoardingPoints, departureTime, fromLoc)
+	route.DroppingPoints = convertRedBusPoints(rbRoute.DroppingPoints, arrivalTime, toLoc)
+
+	return route, nil
 }
 
 // RapidAPIBusService integrates with transportation APIs from RapidAPI
 type RapidAPIBusService struct {
-	Name   string
-	client *HTTPClient
+	Name     string
+	Currency string // when a route does not give its own
+	client   *HTTPClient
 }
 
-func NewRapidAPIBusService(apiKey string) *RapidAPIBusService {
-	config := APIConfig{
+func init() {
+	RegisterProvider("rapidapi", func(cfg ProviderConfig) (PlatformService, error) {
+		if cfg.APIKey == "" {
+			return nil, ErrMissingAPIKey
+		}
+		service := newRapidAPIBusService(cfg.apiConfig(defaultRapidAPIConfig()))
+		if cfg.Name != "" {
+			service.Name = cfg.Name
+		}
+		service.Currency = currencyOr(cfg.Currency, service.Currency)
+		return service, nil
+	})
+}
+
+func defaultRapidAPIConfig() APIConfig {
+	return APIConfig{
 		BaseURL:   "https://transport-api.p.rapidapi.com",
-		APIKey:    apiKey,
 		UserAgent: "BusAggregator/1.0",
 		Timeout:   30 * time.Second,
-		RateLimit: 2 * time.Second, // RapidAPI rate limit
+
+		RequestsPerSecond: 0.5, // RapidAPI rate limit
+		Burst:             1,
+		DailyQuota:        500, // Basic plan
 	}
+}
+
+func NewRapidAPIBusService(apiKey string) *RapidAPIBusService {
+	config := defaultRapidAPIConfig()
+	config.APIKey = apiKey
+	return newRapidAPIBusService(config)
+}
 
+func newRapidAPIBusService(config APIConfig) *RapidAPIBusService {
 	return &RapidAPIBusService{
-		Name:   "Transport API",
-		client: NewHTTPClient(config),
+		Name:     "Transport API",
+		Currency: "INR",
+		client:   NewHTTPClient(config),
 	}
 }
 
@@ -286,13 +419,53 @@ func (r *RapidAPIBusService) GetPlatformName() string {
 	return r.Name
 }
 
-func (r *RapidAPIBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
+func (r *RapidAPIBusService) RateLimitStatus() RateLimitStatus {
+	return r.client.limiter.Status()
+}
+
+// SupportsFilterPushdown reports that the transport API filters by bus
+// class, price and departure time server-side
+func (r *RapidAPIBusService) SupportsFilterPushdown() bool {
+	return true
+}
+
+// addFilterParams adds the filters the transport API understands. Price
+// bounds are in the base currency, so they are converted into the currency
+// the API quotes in and widened to whole units; the aggregated results are
+// filtered again exactly. Bounds that can't be converted are left out.
+func addFilterParams(params url.Values, f SearchFilters, currency string) {
+	if f.AC != nil {
+		params.Set("ac", strconv.FormatBool(*f.AC))
+	}
+	if f.Sleeper != nil {
+		params.Set("sleeper", strconv.FormatBool(*f.Sleeper))
+	}
+	if f.MinPrice > 0 {
+		if price, err := currencies.Convert(f.MinPrice, currencies.Base(), currency); err == nil {
+			params.Set("minPrice", strconv.FormatFloat(math.Floor(price), 'f', -1, 64))
+		}
+	}
+	if f.MaxPrice > 0 {
+		if price, err := currencies.Convert(f.MaxPrice, currencies.Base(), currency); err == nil {
+			params.Set("maxPrice", strconv.FormatFloat(math.Ceil(price), 'f', -1, 64))
+		}
+	}
+	if f.DepartureAfter != "" {
+		params.Set("departureFrom", f.DepartureAfter)
+	}
+	if f.DepartureBefore != "" {
+		params.Set("departureTo", f.DepartureBefore)
+	}
+}
+
+func (r *RapidAPIBusService) SearchRoutes(ctx context.Context, req SearchRequest) ([]Route, error) {
 	// Build query parameters
 	params := url.Values{}
 	params.Set("from", req.FromCity)
 	params.Set("to", req.ToCity)
 	params.Set("date", req.Date.Format("2006-01-02"))
 	params.Set("passengers", strconv.Itoa(req.Passengers))
+	addFilterParams(params, req.SearchFilters, r.Currency)
 
 	endpoint := "/bus/search?" + params.Encode()
 
@@ -301,9 +474,9 @@ func (r *RapidAPIBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
 		"X-RapidAPI-Key":  r.client.config.APIKey,
 	}
 
-	responseBody, err := r.client.MakeRequest("GET", endpoint, headers, nil)
+	responseBody, err := r.client.MakeRequest(ctx, "GET", endpoint, headers, nil)
 	if err != nil {
-		return nil, fmt.Errorf("RapidAPI error: %v", err)
+		return nil, fmt.Errorf("RapidAPI error: %w", err)
 	}
 
 	// Parse response (format depends on the specific API)
@@ -314,10 +487,14 @@ func (r *RapidAPIBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
 			Departure string   `json:"departure"`
 			Arrival   string   `json:"arrival"`
 			Price     float64  `json:"price"`
+			Currency  string   `json:"currency"`
 			Duration  string   `json:"duration"`
 			BusType   string   `json:"busType"`
 			Seats     int      `json:"availableSeats"`
 			Amenities []string `json:"amenities"`
+
+			BoardingPoints []rapidAPIPoint `json:"boardingPoints"`
+			DroppingPoints []rapidAPIPoint `json:"droppingPoints"`
 		} `json:"routes"`
 	}
 
@@ -327,22 +504,21 @@ func (r *RapidAPIBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
 
 	// Convert to our internal format
 	var routes []Route
-	locations := GetSampleLocations()
-	var fromLoc, toLoc Location
+	fromLoc := cityCatalogue.Location(req.FromCity)
+	toLoc := cityCatalogue.Location(req.ToCity)
 
-	for _, loc := range locations {
-		if loc.City == req.FromCity {
-			fromLoc = loc
+	for _, apiRoute := range apiResponse.Routes {
+		// Times carry their own offset; a route without them is unusable
+		departureTime, err := time.Parse(time.RFC3339, apiRoute.Departure)
+		if err != nil {
+			fmt.Printf("Warning: skipping RapidAPI route %s: invalid departure %q\n", apiRoute.ID, apiRoute.Departure)
+			continue
 		}
-		if loc.City == req.ToCity {
-			toLoc = loc
+		arrivalTime, err := time.Parse(time.RFC3339, apiRoute.Arrival)
+		if err != nil {
+			fmt.Printf("Warning: skipping RapidAPI route %s: invalid arrival %q\n", apiRoute.ID, apiRoute.Arrival)
+			continue
 		}
-	}
-
-	for _, apiRoute := range apiResponse.Routes {
-		// Parse times
-		departureTime, _ := time.Parse(time.RFC3339, apiRoute.Departure)
-		arrivalTime, _ := time.Parse(time.RFC3339, apiRoute.Arrival)
 
 		route := Route{
 			ID:   apiRoute.ID,
@@ -359,85 +535,411 @@ func (r *RapidAPIBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
 				Name:      apiRoute.BusType,
 				Amenities: apiRoute.Amenities,
 			},
-			DepartureTime: departureTime,
-			ArrivalTime:   arrivalTime,
-			Duration:      apiRoute.Duration,
 			Price: Price{
 				Amount:   apiRoute.Price,
-				Currency: "INR",
+				Currency: currencyOr(apiRoute.Currency, r.Currency),
 				Platform: "Transport API",
 			},
 			AvailableSeats: apiRoute.Seats,
 			BookingURL:     fmt.Sprintf("https://example-booking.com/book/%s", apiRoute.ID),
 		}
+		route.SetTimes(departureTime, arrivalTime)
+		route.BoardingPoints = convertRapidAPIPoints(apiRoute.BoardingPoints, route.DepartureTime, fromLoc)
+		route.DroppingPoints = convertRapidAPIPoints(apiRoute.DroppingPoints, route.ArrivalTime, toLoc)
 		routes = append(routes, route)
 	}
 
 	return routes, nil
 }
 
+// DefaultProviderTimeout bounds a single provider's search when no
+// provider-specific deadline is configured
+const DefaultProviderTimeout = 10 * time.Second
+
 // Enhanced Platform Manager with real APIs
 type RealPlatformManager struct {
 	platforms []PlatformService
+
+	// Registry ID and weight of each provider, keyed by platform name
+	providerIDs map[string]string
+	weights     map[string]float64
+
+	// Per-provider search deadlines keyed by platform name
+	timeouts       map[string]time.Duration
+	defaultTimeout time.Duration
+
+	// Groups the same bus listed by several platforms into one trip
+	matcher *RouteMatcher
+
+	// Provider results cached per normalised search
+	cache *SearchCache
+
+	// Circuit breakers keyed by platform name
+	breakers map[string]*CircuitBreaker
+
+	// Rolling stats over upstream calls made by searches
+	health *HealthTracker
+
+	// The configuration each provider was built from, keyed by registry ID
+	configs map[string]ProviderConfig
 }
 
-func NewRealPlatformManager(redBusAPIKey, rapidAPIKey string) *RealPlatformManager {
-	platforms := []PlatformService{}
+// NewRealPlatformManager builds every enabled provider through the registry.
+// Providers are ordered by descending weight, so when several platforms list
+// the same bus at the same price the heavier one is preferred. Providers
+// that fail to build are logged and skipped.
+func NewRealPlatformManager(providers []ProviderConfig) *RealPlatformManager {
+	return newRealPlatformManager(providers, nil)
+}
 
-	// Add mock services for testing
-	platforms = append(platforms, &RedBusService{Name: "RedBus Mock"})
+// Reconfigure returns a manager for a new provider configuration. pm is left
+// untouched for searches still using it. Providers whose configuration is
+// unchanged carry over with their rate limiter and circuit breaker; the
+// cache and health stats are shared, minus cached results of providers that
+// were rebuilt.
+func (pm *RealPlatformManager) Reconfigure(providers []ProviderConfig) *RealPlatformManager {
+	return newRealPlatformManager(providers, pm)
+}
 
-	// Add real APIs if keys are provided
-	if redBusAPIKey != "" {
-		platforms = append(platforms, NewRealRedBusService(redBusAPIKey))
+func newRealPlatformManager(providers []ProviderConfig, previous *RealPlatformManager) *RealPlatformManager {
+	pm := &RealPlatformManager{
+		providerIDs:    map[string]string{},
+		weights:        map[string]float64{},
+		timeouts:       map[string]time.Duration{},
+		defaultTimeout: DefaultProviderTimeout,
+		matcher:        NewRouteMatcher(),
+		cache:          NewSearchCache(DefaultCacheTTL, DefaultCacheStale),
+		breakers:       map[string]*CircuitBreaker{},
+		health:         NewHealthTracker(DefaultHealthWindow),
+		configs:        map[string]ProviderConfig{},
+	}
+	if previous != nil {
+		pm.cache = previous.cache
+		pm.health = previous.health
+	}
+
+	enabled := make([]ProviderConfig, 0, len(providers))
+	for _, cfg := range providers {
+		if cfg.Enabled {
+			enabled = append(enabled, cfg)
+		}
 	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		return enabled[i].EffectiveWeight() > enabled[j].EffectiveWeight()
+	})
 
-	if rapidAPIKey != "" {
-		platforms = append(platforms, NewRapidAPIBusService(rapidAPIKey))
+	for _, cfg := range enabled {
+		var service PlatformService
+		var breaker *CircuitBreaker
+		if previous != nil && previous.configs[cfg.ID] == cfg {
+			if p, ok := previous.ProviderByID(cfg.ID); ok {
+				service, breaker = p, previous.breakers[p.GetPlatformName()]
+			}
+		}
+		if service == nil {
+			var err error
+			if service, err = NewProvider(cfg); err != nil {
+				fmt.Printf("Warning: skipping provider %s: %v\n", cfg.ID, err)
+				continue
+			}
+			breaker = NewCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown)
+			if previous != nil {
+				pm.cache.Forget(service.GetPlatformName())
+			}
+		}
+
+		name := service.GetPlatformName()
+		if _, exists := pm.providerIDs[name]; exists {
+			fmt.Printf("Warning: skipping provider %s: platform name %q already in use\n", cfg.ID, name)
+			continue
+		}
+
+		pm.platforms = append(pm.platforms, service)
+		pm.providerIDs[name] = cfg.ID
+		pm.weights[name] = cfg.EffectiveWeight()
+		pm.breakers[name] = breaker
+		pm.configs[cfg.ID] = cfg
+		if cfg.Timeout > 0 {
+			pm.timeouts[name] = time.Duration(cfg.Timeout)
+		}
+		if cfg.CacheTTL > 0 {
+			pm.cache.SetTTL(name, time.Duration(cfg.CacheTTL))
+		}
 	}
 
-	return &RealPlatformManager{
-		platforms: platforms,
+	return pm
+}
+
+// ProviderByID returns the live provider registered under a registry ID
+func (pm *RealPlatformManager) ProviderByID(id string) (PlatformService, bool) {
+	for _, p := range pm.platforms {
+		if pm.providerIDs[p.GetPlatformName()] == id {
+			return p, true
+		}
 	}
+	return nil, false
 }
 
-func (pm *RealPlatformManager) SearchAllPlatforms(req SearchRequest) ([]Route, error) {
-	var allRoutes []Route
+// ProviderStatuses reports live health for every registered provider, in
+// registration order
+func (pm *RealPlatformManager) ProviderStatuses() []ProviderStatus {
+	statuses := make([]ProviderStatus, 0, len(pm.platforms))
+	for _, p := range pm.platforms {
+		name := p.GetPlatformName()
+		status := ProviderStatus{
+			ID:      pm.providerIDs[name],
+			Name:    name,
+			Weight:  pm.weights[name],
+			Health:  pm.health.Stats(name),
+			Breaker: pm.breakers[name].Status(),
+		}
+		status.Status = providerState(status.Health, status.Breaker)
+		if reporter, ok := p.(RateLimitReporter); ok {
+			rateLimit := reporter.RateLimitStatus()
+			status.RateLimit = &rateLimit
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
 
-	// Channel to collect routes from all platforms
-	routesChan := make(chan []Route, len(pm.platforms))
-	errorsChan := make(chan error, len(pm.platforms))
+// timeoutFor returns the search deadline for a single provider
+func (pm *RealPlatformManager) timeoutFor(p PlatformService) time.Duration {
+	if timeout, ok := pm.timeouts[p.GetPlatformName()]; ok && timeout > 0 {
+		return timeout
+	}
+	return pm.defaultTimeout
+}
+
+// ErrAllPlatformsFailed is returned when no provider produced a result
+var ErrAllPlatformsFailed = errors.New("all platforms failed")
+
+// ErrNoMatchingPlatforms is returned when the platform allow-list excludes
+// every enabled provider
+var ErrNoMatchingPlatforms = errors.New("no enabled platform matches the platforms filter")
+
+// AggregatedSearch holds the merged routes along with how each provider fared
+type AggregatedSearch struct {
+	Routes    []Route
+	Trips     []Trip
+	Platforms []PlatformResult
+
+	// Every route the providers returned, and how many of them the
+	// request's filters removed
+	Unfiltered  []Route
+	FilteredOut int
+}
+
+// CacheSummary describes how the search was served: "hit" when every
+// platform answered from cache, "miss" when none did, otherwise "partial"
+func (a *AggregatedSearch) CacheSummary() string {
+	cached := 0
+	for _, p := range a.Platforms {
+		if p.Cache == CacheHit || p.Cache == CacheStale {
+			cached++
+		}
+	}
+	switch {
+	case len(a.Platforms) > 0 && cached == len(a.Platforms):
+		return CacheHit
+	case cached == 0:
+		return CacheMiss
+	default:
+		return "partial"
+	}
+}
+
+// Succeeded returns the number of providers that answered successfully
+func (a *AggregatedSearch) Succeeded() int {
+	count := 0
+	for _, p := range a.Platforms {
+		if p.Status == PlatformStatusSuccess {
+			count++
+		}
+	}
+	return count
+}
+
+// Partial reports whether some, but not all, providers failed
+func (a *AggregatedSearch) Partial() bool {
+	succeeded := a.Succeeded()
+	return succeeded > 0 && succeeded < len(a.Platforms)
+}
+
+// SearchAllPlatforms fans the search out to every provider concurrently.
+// Each provider runs under its own deadline derived from ctx, so a slow
+// provider is cut off without delaying the others, and cancelling ctx
+// (e.g. the client disconnecting) stops all in-flight provider calls.
+//
+// Provider results come from the search cache where possible. An upstream
+// call may be shared with other searches or refresh a stale entry, so it
+// outlives this caller leaving and is only cancelled once nobody is waiting
+// on it; each still runs under the provider deadline.
+//
+// Provider failures do not fail the search; they are reported per platform
+// in the result. ErrAllPlatformsFailed is returned alongside the result
+// when every provider failed.
+func (pm *RealPlatformManager) SearchAllPlatforms(ctx context.Context, req SearchRequest) (*AggregatedSearch, error) {
+	type platformResult struct {
+		index  int
+		routes []Route
+		result PlatformResult
+	}
+
+	// Only fan out to the platforms the request allows
+	var platforms []PlatformService
+	for _, p := range pm.platforms {
+		if req.AllowsPlatform(pm.providerIDs[p.GetPlatformName()], p.GetPlatformName()) {
+			platforms = append(platforms, p)
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, ErrNoMatchingPlatforms
+	}
+
+	// Channel to collect results from all platforms
+	resultsChan := make(chan platformResult, len(platforms))
 
 	// Search all platforms concurrently
-	for _, platform := range pm.platforms {
-		go func(p PlatformService) {
-			routes, err := p.SearchRoutes(req)
+	for i, platform := range platforms {
+		go func(index int, p PlatformService) {
+			// Providers that can't filter server-side get the bare search,
+			// which also lets them share cache entries across filters
+			providerReq := req
+			if pushdown, ok := p.(FilterPushdown); !ok || !pushdown.SupportsFilterPushdown() {
+				providerReq.SearchFilters = SearchFilters{}
+			}
+
+			timeout := pm.timeoutFor(p)
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			breaker := pm.breakers[p.GetPlatformName()]
+			routes, cacheStatus, err := pm.cache.Fetch(pctx, p.GetPlatformName(), providerReq, func(fctx context.Context) ([]Route, error) {
+				if err := breaker.Allow(); err != nil {
+					return nil, err
+				}
+
+				fctx, cancel := context.WithTimeout(fctx, timeout)
+				defer cancel()
+
+				callStart := time.Now()
+				routes, err := p.SearchRoutes(fctx, providerReq)
+				breaker.Record(err)
+				if err == nil || isProviderFault(err) {
+					pm.health.Record(p.GetPlatformName(), time.Since(callStart), err)
+				}
+				for i := range routes {
+					routes[i].Provider = pm.providerIDs[p.GetPlatformName()]
+				}
+				return routes, err
+			})
+
+			result := PlatformResult{
+				ID:         pm.providerIDs[p.GetPlatformName()],
+				Platform:   p.GetPlatformName(),
+				Status:     PlatformStatusSuccess,
+				LatencyMS:  time.Since(start).Milliseconds(),
+				RouteCount: len(routes),
+				Cache:      cacheStatus,
+			}
 			if err != nil {
 				fmt.Printf("Warning: %s error: %v\n", p.GetPlatformName(), err)
-				errorsChan <- err
-				routesChan <- nil
-				return
+				routes = nil
+				result.RouteCount = 0
+				result.Status, result.Error = classifyPlatformError(ctx, pctx, err)
 			}
-			routesChan <- routes
-			errorsChan <- nil
-		}(platform)
+			resultsChan <- platformResult{index: index, routes: routes, result: result}
+		}(i, platform)
 	}
 
-	// Collect results
-	for i := 0; i < len(pm.platforms); i++ {
-		routes := <-routesChan
-		err := <-errorsChan
+	// Collect results, keeping the per-platform block in registration order
+	results := make([]platformResult, len(platforms))
+	for i := 0; i < len(platforms); i++ {
+		result := <-resultsChan
+		results[result.index] = result
+	}
 
-		if err != nil {
-			continue // Already logged above
-		}
+	// The caller has gone away, so partial results are of no use
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-		if routes != nil {
-			allRoutes = append(allRoutes, routes...)
-		}
+	search := &AggregatedSearch{Platforms: make([]PlatformResult, 0, len(results))}
+	for _, result := range results {
+		search.Platforms = append(search.Platforms, result.result)
+		search.Routes = append(search.Routes, result.routes...)
+	}
+
+	// Compare prices in one currency, at today's rates rather than those
+	// of when a cached result was fetched
+	currencies.Normalize(search.Routes)
+
+	// Filter after aggregation; pushed-down filters are re-checked here too,
+	// since not every provider applies them exactly
+	search.Unfiltered = search.Routes
+	search.Routes = req.SearchFilters.Apply(search.Routes)
+	search.FilteredOut = len(search.Unfiltered) - len(search.Routes)
+
+	search.Trips = pm.matcher.Group(search.Routes)
+
+	if len(search.Platforms) > 0 && search.Succeeded() == 0 {
+		return search, ErrAllPlatformsFailed
 	}
 
-	return allRoutes, nil
+	return search, nil
+}
+
+// SearchProvider asks one provider directly, skipping the search cache, for
+// callers that need current figures such as seat counts. Filters are not
+// sent or applied.
+func (pm *RealPlatformManager) SearchProvider(ctx context.Context, providerID string, req SearchRequest) ([]Route, error) {
+	p, ok := pm.ProviderByID(providerID)
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+	req.SearchFilters = SearchFilters{}
+
+	breaker := pm.breakers[p.GetPlatformName()]
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pm.timeoutFor(p))
+	defer cancel()
+
+	start := time.Now()
+	routes, err := p.SearchRoutes(ctx, req)
+	breaker.Record(err)
+	if err == nil || isProviderFault(err) {
+		pm.health.Record(p.GetPlatformName(), time.Since(start), err)
+	}
+	for i := range routes {
+		routes[i].Provider = providerID
+	}
+	currencies.Normalize(routes)
+	return routes, err
+}
+
+// classifyPlatformError maps a provider error onto a result status and a
+// typed error. pctx is the provider's own context and ctx its parent, which
+// lets us tell our per-provider deadline apart from the caller going away.
+func classifyPlatformError(ctx, pctx context.Context, err error) (string, *PlatformError) {
+	switch {
+	case ctx.Err() != nil:
+		return PlatformStatusCancelled, &PlatformError{Code: "cancelled", Message: "search was cancelled"}
+	case errors.Is(err, ErrCircuitOpen):
+		return PlatformStatusCircuitOpen, &PlatformError{Code: "circuit_open", Message: "provider is failing and temporarily disabled", Retryable: true}
+	case errors.Is(err, ErrCityNotSupported):
+		return PlatformStatusUnsupported, &PlatformError{Code: "city_not_supported", Message: err.Error()}
+	case errors.Is(err, ErrRateLimited):
+		return PlatformStatusRateLimited, &PlatformError{Code: "rate_limited", Message: err.Error(), Retryable: true}
+	case errors.Is(pctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return PlatformStatusTimeout, &PlatformError{Code: "timeout", Message: "provider did not respond in time", Retryable: true}
+	default:
+		return PlatformStatusError, &PlatformError{Code: "upstream_error", Message: err.Error(), Retryable: IsTransient(err)}
+	}
 }
 
 // Configuration structure for API keys
@@ -445,14 +947,77 @@ type Config struct {
 	RedBusAPIKey string `json:"redbus_api_key"`
 	RapidAPIKey  string `json:"rapidapi_key"`
 	ServerPort   string `json:"server_port"`
+
+	// Providers to enable; DefaultProviderConfigs when empty
+	Providers []ProviderConfig `json:"providers"`
+
+	// How long stored history is kept; DefaultRetention for unset periods
+	Retention RetentionPolicy `json:"retention,omitempty"`
+
+	// Price and seat alert checks and delivery
+	Alerts AlertConfig `json:"alerts,omitempty"`
+
+	// Base currency and exchange rates source
+	Currency CurrencyConfig `json:"currency,omitempty"`
 }
 
-// LoadConfig loads configuration from environment variables or config file
+// DefaultConfigFile is read by LoadConfig unless BUS_SCANNER_CONFIG is set
+const DefaultConfigFile = "config.json"
+
+// LoadConfig loads configuration from the config file if there is one,
+// falling back to defaults. API keys from the environment are applied
+// separately in main.
 func LoadConfig() Config {
-	// In production, you'd load from environment variables or config file
-	return Config{
-		RedBusAPIKey: "", // Set your RedBus API key here
-		RapidAPIKey:  "", // Set your RapidAPI key here
-		ServerPort:   ":8080",
+	config := Config{
+		ServerPort: ":8080",
+	}
+
+	path := os.Getenv("BUS_SCANNER_CONFIG")
+	if path == "" {
+		path = DefaultConfigFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Warning: could not read %s: %v\n", path, err)
+		}
+	} else if err := json.Unmarshal(data, &config); err != nil {
+		fmt.Printf("Warning: could not parse %s: %v\n", path, err)
+	}
+
+	if len(config.Providers) == 0 {
+		config.Providers = DefaultProviderConfigs()
+	}
+
+	return config
+}
+
+// ProviderConfigs returns the provider list with the top-level RedBus and
+// RapidAPI keys filled in where a provider entry doesn't set its own
+func (c Config) ProviderConfigs() []ProviderConfig {
+	keys := map[string]string{
+		"redbus":   c.RedBusAPIKey,
+		"rapidapi": c.RapidAPIKey,
+	}
+
+	providers := make([]ProviderConfig, len(c.Providers))
+	copy(providers, c.Providers)
+	for i := range providers {
+		if providers[i].APIKey == "" {
+			providers[i].APIKey = keys[providers[i].ID]
+		}
+	}
+	return providers
+}
+
+// ProviderConfig returns the configuration for one provider ID. Providers
+// that are registered but absent from the config get a bare entry.
+func (c Config) ProviderConfig(id string) ProviderConfig {
+	for _, cfg := range c.ProviderConfigs() {
+		if cfg.ID == id {
+			return cfg
+		}
 	}
+	return ProviderConfig{ID: id}
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// MaxJourneyLegs bounds multi-city searches, since every leg fans out to
+// every provider
+const MaxJourneyLegs = 5
+
+// SearchLeg is one hop of a multi-city search
+type SearchLeg struct {
+	FromCity string    `json:"from_city"`
+	ToCity   string    `json:"to_city"`
+	Date     time.Time `json:"date"`
+}
+
+// IsJourney reports whether the request has more than one leg
+func (r SearchRequest) IsJourney() bool {
+	return len(r.Legs) > 0 || r.ReturnDate != nil
+}
+
+// LegRequests splits a request into one search per leg. A return date adds
+// the reverse of the outbound leg. Every leg shares the passengers, filters
+// and ordering of the request.
+func (r SearchRequest) LegRequests() []SearchRequest {
+	legs := r.Legs
+	if len(legs) == 0 {
+		legs = []SearchLeg{{FromCity: r.FromCity, ToCity: r.ToCity, Date: r.Date}}
+		if r.ReturnDate != nil {
+			legs = append(legs, SearchLeg{FromCity: r.ToCity, ToCity: r.FromCity, Date: *r.ReturnDate})
+		}
+	}
+
+	requests := make([]SearchRequest, 0, len(legs))
+	for _, leg := range legs {
+		req := r
+		req.FromCity, req.ToCity, req.Date = leg.FromCity, leg.ToCity, leg.Date
+		req.ReturnDate, req.Legs = nil, nil
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+// ValidateLegs checks a journey's legs are complete and in date order, and
+// that the layover limits for connections make sense
+func (r SearchRequest) ValidateLegs() error {
+	if r.MinLayover < 0 || r.MaxLayover < 0 {
+		return fmt.Errorf("layover limits must not be negative")
+	}
+	minLayover, maxLayover := r.layoverWindow()
+	if minLayover > maxLayover {
+		return fmt.Errorf("min_layover is longer than max_layover")
+	}
+	if len(r.Legs) > 0 && r.ReturnDate != nil {
+		return fmt.Errorf("use either legs or return_date, not both")
+	}
+	if r.ReturnDate != nil && r.ReturnDate.Before(r.Date) {
+		return fmt.Errorf("return_date is before date")
+	}
+	if len(r.Legs) > MaxJourneyLegs {
+		return fmt.Errorf("at most %d legs are allowed", MaxJourneyLegs)
+	}
+	for i, leg := range r.Legs {
+		if leg.FromCity == "" || leg.ToCity == "" || leg.Date.IsZero() {
+			return fmt.Errorf("leg %d needs from_city, to_city and date", i+1)
+		}
+		if i > 0 && leg.Date.Before(r.Legs[i-1].Date) {
+			return fmt.Errorf("leg %d is dated before leg %d", i+1, i)
+		}
+	}
+	return nil
+}
+
+// LegSearch is the outcome of one leg's search
+type LegSearch struct {
+	Request SearchRequest
+	Search  *AggregatedSearch
+	Err     error
+}
+
+// SearchJourney searches every leg concurrently. Each leg fans out to the
+// providers exactly as a single search would; a failed leg does not stop
+// the others.
+func (pm *RealPlatformManager) SearchJourney(ctx context.Context, legs []SearchRequest) []LegSearch {
+	results := make([]LegSearch, len(legs))
+
+	var wg sync.WaitGroup
+	for i, leg := range legs {
+		wg.Add(1)
+		go func(i int, leg SearchRequest) {
+			defer wg.Done()
+			search, err := pm.SearchAllPlatforms(ctx, leg)
+			results[i] = LegSearch{Request: leg, Search: search, Err: err}
+		}(i, leg)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// CombinedFare is the cheapest way to book every leg, one route per leg.
+// Legs may be booked on different platforms.
+type CombinedFare struct {
+	Total        float64  `json:"total"` // in Currency, the base currency
+	Currency     string   `json:"currency"`
+	DisplayTotal *Money   `json:"display_total,omitempty"`
+	Routes       []Route  `json:"routes"` // one per leg, in leg order
+	Platforms    []string `json:"platforms"`
+	Mixed        bool     `json:"mixed_platforms"`
+}
+
+// ErrNoCombination is returned when no set of routes fits together, e.g.
+// every return departs before the outbound arrives
+var ErrNoCombination = errors.New("no combination of routes connects every leg")
+
+// CheapestCombination picks one route per leg, minimising the total price.
+// Each leg's route must depart after the previous one arrives. Prices are
+// compared in the base currency, so legs quoted in different currencies
+// combine as long as each has an exchange rate.
+func CheapestCombination(legs [][]Route) (*CombinedFare, error) {
+	if len(legs) == 0 {
+		return nil, ErrNoCombination
+	}
+
+	type step struct {
+		cost float64
+		prev int // index into the previous leg's routes
+	}
+
+	// best[i][j] is the cheapest way to finish leg i on route j
+	best := make([][]step, len(legs))
+	for i, routes := range legs {
+		best[i] = make([]step, len(routes))
+		for j, route := range routes {
+			best[i][j] = step{cost: math.Inf(1), prev: -1}
+			if i == 0 {
+				best[i][j].cost = route.Price.Comparable()
+				continue
+			}
+			for k, prev := range legs[i-1] {
+				if math.IsInf(best[i-1][k].cost, 1) ||
+					route.DepartureTime.Before(prev.ArrivalTime) ||
+					route.Price.ComparableCurrency() != prev.Price.ComparableCurrency() {
+					continue
+				}
+				if cost := best[i-1][k].cost + route.Price.Comparable(); cost < best[i][j].cost {
+					best[i][j] = step{cost: cost, prev: k}
+				}
+			}
+		}
+	}
+
+	last := len(legs) - 1
+	end := -1
+	for j := range best[last] {
+		if !math.IsInf(best[last][j].cost, 1) && (end < 0 || best[last][j].cost < best[last][end].cost) {
+			end = j
+		}
+	}
+	if end < 0 {
+		return nil, ErrNoCombination
+	}
+
+	fare := &CombinedFare{Total: best[last][end].cost, Routes: make([]Route, len(legs))}
+	for i, j := last, end; i >= 0; i-- {
+		fare.Routes[i] = legs[i][j]
+		j = best[i][j].prev
+	}
+
+	seen := map[string]bool{}
+	for _, route := range fare.Routes {
+		if !seen[route.Price.Platform] {
+			seen[route.Price.Platform] = true
+			fare.Platforms = append(fare.Platforms, route.Price.Platform)
+		}
+	}
+	fare.Mixed = len(fare.Platforms) > 1
+	fare.Currency = fare.Routes[0].Price.ComparableCurrency()
+
+	return fare, nil
+}
+
+// LegResult is one leg of a journey response. SearchID names the leg's
+// result session, so each leg can be paged through /search/{id}.
+type LegResult struct {
+	Leg         int              `json:"leg"`
+	Status      string           `json:"status"`
+	Message     string           `json:"message"`
+	From        string           `json:"from"`
+	To          string           `json:"to"`
+	Date        string           `json:"date"`
+	SearchID    string           `json:"search_id,omitempty"`
+	Routes      []Route          `json:"routes"`
+	Trips       []Trip           `json:"trips"`
+	TotalFound  int              `json:"total_found"`
+	FilteredOut int              `json:"filtered_out"`
+	Platforms   []PlatformResult `json:"platforms"`
+	Partial     bool             `json:"partial"`
+	Cache       string           `json:"cache"`
+}
+
+// JourneyResponse is the response to a round-trip or multi-city search
+type JourneyResponse struct {
+	Status     string        `json:"status"`
+	Message    string        `json:"message"`
+	SearchTime string        `json:"search_time"`
+	Sort       string        `json:"sort"`
+	Legs       []LegResult   `json:"legs"`
+	Cheapest   *CombinedFare `json:"cheapest,omitempty"`
+
+	BaseCurrency    string `json:"base_currency"`
+	DisplayCurrency string `json:"display_currency,omitempty"`
+}
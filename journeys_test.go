@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// legRoute is a route departing dep hours and arriving arr hours after a
+// fixed start, priced in INR on platform
+func legRoute(id string, dep, arr int, amount float64, platform string) Route {
+	start := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	return Route{
+		ID:            id,
+		DepartureTime: start.Add(time.Duration(dep) * time.Hour),
+		ArrivalTime:   start.Add(time.Duration(arr) * time.Hour),
+		Price:         Price{Amount: amount, Currency: "INR", Platform: platform},
+	}
+}
+
+func TestCheapestCombination(t *testing.T) {
+	usd := legRoute("usd", 12, 16, 10, "Abroad")
+	usd.Price.Currency = "USD"
+	converted := usd
+	converted.ID = "converted"
+	converted.Price.BaseAmount = 830
+	converted.Price.BaseCurrency = "INR"
+
+	tests := []struct {
+		name       string
+		legs       [][]Route
+		wantRoutes string
+		wantTotal  float64
+		wantMixed  bool
+		wantErr    error
+	}{
+		{name: "no legs", legs: nil, wantErr: ErrNoCombination},
+		{name: "empty leg", legs: [][]Route{{legRoute("a", 0, 5, 500, "RedBus")}, {}}, wantErr: ErrNoCombination},
+		{
+			name:       "single leg picks cheapest",
+			legs:       [][]Route{{legRoute("a", 0, 5, 700, "RedBus"), legRoute("b", 1, 6, 500, "RedBus")}},
+			wantRoutes: "b",
+			wantTotal:  500,
+		},
+		{
+			name: "cheapest pair",
+			legs: [][]Route{
+				{legRoute("out1", 0, 5, 500, "RedBus"), legRoute("out2", 2, 7, 400, "RedBus")},
+				{legRoute("ret1", 8, 13, 600, "RedBus"), legRoute("ret2", 10, 15, 450, "RedBus")},
+			},
+			wantRoutes: "out2,ret2",
+			wantTotal:  850,
+		},
+		{
+			name: "return before arrival is skipped",
+			legs: [][]Route{
+				{legRoute("out1", 0, 5, 500, "RedBus"), legRoute("cheap-late", 6, 11, 200, "RedBus")},
+				{legRoute("ret1", 8, 13, 600, "RedBus")},
+			},
+			wantRoutes: "out1,ret1",
+			wantTotal:  1100,
+		},
+		{
+			name:       "departing at arrival connects",
+			legs:       [][]Route{{legRoute("a", 0, 5, 300, "RedBus")}, {legRoute("b", 5, 9, 300, "RedBus")}},
+			wantRoutes: "a,b",
+			wantTotal:  600,
+		},
+		{
+			name:    "nothing connects",
+			legs:    [][]Route{{legRoute("a", 10, 15, 300, "RedBus")}, {legRoute("b", 5, 9, 300, "RedBus")}},
+			wantErr: ErrNoCombination,
+		},
+		{
+			name: "three legs mixed platforms",
+			legs: [][]Route{
+				{legRoute("a", 0, 4, 300, "RedBus"), legRoute("a2", 0, 4, 350, "AbhiBus")},
+				{legRoute("b", 5, 9, 250, "AbhiBus")},
+				{legRoute("c", 10, 14, 200, "RedBus"), legRoute("c2", 3, 6, 100, "RedBus")},
+			},
+			wantRoutes: "a,b,c",
+			wantTotal:  750,
+			wantMixed:  true,
+		},
+		{
+			name:    "currencies without rates do not mix",
+			legs:    [][]Route{{legRoute("a", 0, 5, 500, "RedBus")}, {usd}},
+			wantErr: ErrNoCombination,
+		},
+		{
+			name:       "converted prices combine",
+			legs:       [][]Route{{legRoute("a", 0, 5, 500, "RedBus")}, {usd, converted}},
+			wantRoutes: "a,converted",
+			wantTotal:  1330,
+			wantMixed:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fare, err := CheapestCombination(tt.legs)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CheapestCombination() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheapestCombination: %v", err)
+			}
+
+			var ids []string
+			for _, route := range fare.Routes {
+				ids = append(ids, route.ID)
+			}
+			if got := strings.Join(ids, ","); got != tt.wantRoutes {
+				t.Errorf("routes = %s, want %s", got, tt.wantRoutes)
+			}
+			if fare.Total != tt.wantTotal {
+				t.Errorf("total = %.2f, want %.2f", fare.Total, tt.wantTotal)
+			}
+			if fare.Mixed != tt.wantMixed {
+				t.Errorf("mixed = %v, want %v (platforms %v)", fare.Mixed, tt.wantMixed, fare.Platforms)
+			}
+			if fare.Currency != "INR" {
+				t.Errorf("currency = %s, want INR", fare.Currency)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // zone data for hosts without a system tz database
+	"unicode"
+)
+
+//go:embed data/cities.json
+var embeddedCities []byte
+
+// CityRecord is one entry of the city data file
+type CityRecord struct {
+	Location
+	Aliases     []string          `json:"aliases,omitempty"`
+	ProviderIDs map[string]string `json:"provider_ids,omitempty"`
+}
+
+// LocationCatalogue resolves free-text city names to canonical locations.
+// Lookups try an exact name or alias match first, then a unique prefix,
+// then the closest name within a small edit distance.
+type LocationCatalogue struct {
+	mu     sync.RWMutex
+	cities []CityRecord
+	byName map[string]int // normalised city name, ID or alias -> index
+}
+
+// cityCatalogue is the catalogue used by the providers and handlers. It
+// starts with the embedded city data; main may replace it from a file.
+var cityCatalogue = mustLoadEmbeddedCatalogue()
+
+func mustLoadEmbeddedCatalogue() *LocationCatalogue {
+	catalogue, err := ParseLocationCatalogue(embeddedCities)
+	if err != nil {
+		panic(fmt.Sprintf("embedded city data is invalid: %v", err))
+	}
+	return catalogue
+}
+
+// LoadLocationCatalogue reads a city data file
+func LoadLocationCatalogue(path string) (*LocationCatalogue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLocationCatalogue(data)
+}
+
+// ParseLocationCatalogue builds a catalogue from JSON city records
+func ParseLocationCatalogue(data []byte) (*LocationCatalogue, error) {
+	var cities []CityRecord
+	if err := json.Unmarshal(data, &cities); err != nil {
+		return nil, fmt.Errorf("failed to parse city data: %v", err)
+	}
+
+	catalogue := &LocationCatalogue{
+		cities: cities,
+		byName: map[string]int{},
+	}
+	for i, city := range cities {
+		if city.ID == "" || city.City == "" {
+			return nil, fmt.Errorf("city record %d is missing id or city", i)
+		}
+		if city.Timezone == "" {
+			cities[i].Timezone = countryTimezones[city.Country]
+		} else if _, err := time.LoadLocation(city.Timezone); err != nil {
+			return nil, fmt.Errorf("city %s has invalid timezone %q", city.ID, city.Timezone)
+		}
+		for _, name := range city.names() {
+			key := normalizeCity(name)
+			if existing, ok := catalogue.byName[key]; ok && existing != i {
+				return nil, fmt.Errorf("%q is used by both %s and %s", name, cities[existing].ID, city.ID)
+			}
+			catalogue.byName[key] = i
+		}
+	}
+
+	return catalogue, nil
+}
+
+// countryTimezones fills in the time zone of city records that omit it
+var countryTimezones = map[string]string{
+	"India": "Asia/Kolkata",
+}
+
+// zones caches loaded time zones by name
+var zones sync.Map
+
+// TimeZone returns the location's time zone, or UTC when it has none
+func (l Location) TimeZone() *time.Location {
+	if l.Timezone == "" {
+		return time.UTC
+	}
+	if zone, ok := zones.Load(l.Timezone); ok {
+		return zone.(*time.Location)
+	}
+	zone, err := time.LoadLocation(l.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	zones.Store(l.Timezone, zone)
+	return zone
+}
+
+// names returns every name a city can be looked up by
+func (c CityRecord) names() []string {
+	return append([]string{c.City, c.ID}, c.Aliases...)
+}
+
+// Cities returns all catalogue entries in file order
+func (c *LocationCatalogue) Cities() []CityRecord {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cities := make([]CityRecord, len(c.cities))
+	copy(cities, c.cities)
+	return cities
+}
+
+// Lookup finds the city whose name, alias or ID equals name once both are
+// normalised. Unlike Resolve it never guesses, so it is safe for data that
+// must not land on the wrong city, such as provider ID imports.
+func (c *LocationCatalogue) Lookup(name string) (CityRecord, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if i, ok := c.byName[normalizeCity(name)]; ok {
+		return c.cities[i], true
+	}
+	return CityRecord{}, false
+}
+
+// CityMatch says how Match found a city
+type CityMatch int
+
+const (
+	MatchNone CityMatch = iota
+	MatchExact
+	MatchPrefix
+	MatchFuzzy
+)
+
+// Resolve maps a user supplied city name to its catalogue entry
+func (c *LocationCatalogue) Resolve(name string) (CityRecord, bool) {
+	city, how := c.Match(name)
+	return city, how != MatchNone
+}
+
+// Match is Resolve, also saying whether the name matched exactly, as the
+// unique prefix of a name, or only within the edit distance of one
+func (c *LocationCatalogue) Match(name string) (CityRecord, CityMatch) {
+	key := normalizeCity(name)
+	if key == "" {
+		return CityRecord{}, MatchNone
+	}
+	if city, ok := c.Lookup(name); ok {
+		return city, MatchExact
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// A prefix only counts when it points at a single city
+	if len(key) >= 3 {
+		match := -1
+		for name, i := range c.byName {
+			if strings.HasPrefix(name, key) {
+				if match >= 0 && match != i {
+					match = -2
+					break
+				}
+				match = i
+			}
+		}
+		if match >= 0 {
+			return c.cities[match], MatchPrefix
+		}
+	}
+
+	best, bestDistance := -1, maxEditDistance(key)+1
+	for name, i := range c.byName {
+		if d := levenshtein(key, name); d < bestDistance || (d == bestDistance && best >= 0 && i < best) {
+			best, bestDistance = i, d
+		}
+	}
+	if best >= 0 {
+		return c.cities[best], MatchFuzzy
+	}
+
+	return CityRecord{}, MatchNone
+}
+
+// UnknownCityError is returned for a city name that only resembles
+// catalogue cities, rather than searching one of them in its place
+type UnknownCityError struct {
+	City        string           `json:"city"`
+	Suggestions []CitySuggestion `json:"suggestions"`
+}
+
+func (e *UnknownCityError) Error() string {
+	names := make([]string, len(e.Suggestions))
+	for i, s := range e.Suggestions {
+		names[i] = s.City
+	}
+	return fmt.Sprintf("unknown city %q; did you mean %s?", e.City, strings.Join(names, " or "))
+}
+
+// Canonical returns the catalogue name for a city given exactly or by a
+// unique prefix. A name only a misspelling away from known cities fails
+// with an UnknownCityError listing them; other names come back as given.
+func (c *LocationCatalogue) Canonical(name string) (string, error) {
+	city, how := c.Match(name)
+	switch how {
+	case MatchExact, MatchPrefix:
+		return city.City, nil
+	case MatchFuzzy:
+		return "", &UnknownCityError{City: name, Suggestions: c.Suggest(name, 3)}
+	}
+	return name, nil
+}
+
+// Location is a convenience wrapper around Resolve. Unknown names come back
+// as a bare Location carrying the name the caller supplied.
+func (c *LocationCatalogue) Location(name string) Location {
+	if city, ok := c.Resolve(name); ok {
+		return city.Location
+	}
+	return Location{Name: name, City: name}
+}
+
+// CitySuggestion is one autocomplete result
+type CitySuggestion struct {
+	ID      string `json:"id"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Matched string `json:"matched"` // the name or alias that matched
+}
+
+// Suggest returns up to limit cities whose name or alias starts with the
+// query, falling back to substring and then fuzzy matches
+func (c *LocationCatalogue) Suggest(query string, limit int) []CitySuggestion {
+	key := normalizeCity(query)
+	if key == "" || limit <= 0 {
+		return []CitySuggestion{}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	type scored struct {
+		index   int
+		score   int
+		matched string
+	}
+
+	best := map[int]scored{}
+	for i, city := range c.cities {
+		for _, name := range city.names() {
+			normalised := normalizeCity(name)
+			score := -1
+			switch {
+			case normalised == key:
+				score = 0
+			case strings.HasPrefix(normalised, key):
+				score = 1
+			case strings.Contains(normalised, key):
+				score = 2
+			case levenshtein(key, normalised) <= maxEditDistance(key):
+				score = 3
+			}
+			if score < 0 {
+				continue
+			}
+			if existing, ok := best[i]; !ok || score < existing.score {
+				best[i] = scored{index: i, score: score, matched: name}
+			}
+		}
+	}
+
+	ranked := make([]scored, 0, len(best))
+	for _, s := range best {
+		ranked = append(ranked, s)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score < ranked[j].score
+		}
+		return ranked[i].index < ranked[j].index
+	})
+
+	suggestions := []CitySuggestion{}
+	for _, s := range ranked {
+		if len(suggestions) == limit {
+			break
+		}
+		city := c.cities[s.index]
+		suggestions = append(suggestions, CitySuggestion{
+			ID:      city.ID,
+			City:    city.City,
+			State:   city.State,
+			Matched: s.matched,
+		})
+	}
+	return suggestions
+}
+
+// hasCoordinates reports whether a location has a position; the zero
+// value is in the Gulf of Guinea, where we have no bus stations
+func hasCoordinates(l Location) bool {
+	return l.Lat != 0 || l.Lng != 0
+}
+
+// distanceKM is the great-circle distance between two locations
+func distanceKM(a, b Location) float64 {
+	return distanceBetween(a.Lat, a.Lng, b.Lat, b.Lng)
+}
+
+// distanceBetween is the great-circle distance in kilometres between two
+// latitude/longitude pairs
+func distanceBetween(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKM = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKM * math.Asin(math.Sqrt(h))
+}
+
+// normalizeCity lowercases a name and drops everything but letters and
+// digits, so "New Delhi", "new-delhi" and "NewDelhi" compare equal
+func normalizeCity(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// maxEditDistance is how many typos a fuzzy match tolerates
+func maxEditDistance(key string) int {
+	switch {
+	case len(key) < 4:
+		return 0
+	case len(key) < 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
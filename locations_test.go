@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestLocationCatalogueMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantID  string
+		wantHow CityMatch
+	}{
+		{name: "exact name", query: "Mumbai", wantID: "mumbai", wantHow: MatchExact},
+		{name: "case and spacing", query: " new-DELHI ", wantID: "delhi", wantHow: MatchExact},
+		{name: "alias", query: "Bombay", wantID: "mumbai", wantHow: MatchExact},
+		{name: "catalogue id", query: "thiruvananthapuram", wantID: "thiruvananthapuram", wantHow: MatchExact},
+		{name: "unique prefix", query: "Vija", wantID: "vijayawada", wantHow: MatchPrefix},
+		{name: "prefix of a city and its alias", query: "Mangal", wantID: "mangalore", wantHow: MatchPrefix},
+		{name: "ambiguous prefix", query: "Kol", wantHow: MatchNone},
+		{name: "short prefix", query: "Pu", wantHow: MatchNone},
+		{name: "fuzzy near-miss town", query: "Nagaur", wantID: "nagpur", wantHow: MatchFuzzy},
+		{name: "fuzzy short name", query: "Ajra", wantID: "agra", wantHow: MatchFuzzy},
+		{name: "typo", query: "Hyderbad", wantID: "hyderabad", wantHow: MatchFuzzy},
+		{name: "unknown", query: "Timbuktu", wantHow: MatchNone},
+		{name: "empty", query: " - ", wantHow: MatchNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			city, how := cityCatalogue.Match(tt.query)
+			if how != tt.wantHow || city.ID != tt.wantID {
+				t.Errorf("Match(%q) = %q, %d; want %q, %d", tt.query, city.ID, how, tt.wantID, tt.wantHow)
+			}
+			if _, ok := cityCatalogue.Resolve(tt.query); ok != (tt.wantHow != MatchNone) {
+				t.Errorf("Resolve(%q) ok = %v", tt.query, ok)
+			}
+			if _, ok := cityCatalogue.Lookup(tt.query); ok != (tt.wantHow == MatchExact) {
+				t.Errorf("Lookup(%q) ok = %v, want %v", tt.query, ok, tt.wantHow == MatchExact)
+			}
+		})
+	}
+}
+
+func TestLocationCatalogueCanonical(t *testing.T) {
+	tests := []struct {
+		query      string
+		want       string
+		suggestion string // first suggested city when the name is rejected
+	}{
+		{query: "bengaluru", want: "Bangalore"},
+		{query: "Vija", want: "Vijayawada"},
+		{query: "Kol", want: "Kol"},
+		{query: "Timbuktu", want: "Timbuktu"},
+		{query: "Nagaur", suggestion: "Nagpur"},
+		{query: "Ajra", suggestion: "Agra"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := cityCatalogue.Canonical(tt.query)
+			if tt.suggestion == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("Canonical(%q) = %q, %v; want %q", tt.query, got, err, tt.want)
+				}
+				return
+			}
+
+			var unknown *UnknownCityError
+			if !errors.As(err, &unknown) {
+				t.Fatalf("Canonical(%q) = %q, %v; want an UnknownCityError", tt.query, got, err)
+			}
+			if len(unknown.Suggestions) == 0 || unknown.Suggestions[0].City != tt.suggestion {
+				t.Errorf("suggestions = %+v, want %s first", unknown.Suggestions, tt.suggestion)
+			}
+		})
+	}
+}
+
+func TestLocationCatalogueSuggest(t *testing.T) {
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{query: "bom", limit: 5, want: []string{"mumbai"}},
+		{query: "kol", limit: 5, want: []string{"kolkata", "kolhapur"}},
+		{query: "kol", limit: 1, want: []string{"kolkata"}},
+		{query: "nagaur", limit: 5, want: []string{"nagpur"}},
+		{query: "zzzz", limit: 5, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			suggestions := cityCatalogue.Suggest(tt.query, tt.limit)
+			got := make([]string, len(suggestions))
+			for i, s := range suggestions {
+				got[i] = s.ID
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Suggest(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
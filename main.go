@@ -1,697 +1,54 @@
-// package main
-
-// import (
-// 	"encoding/json"
-// 	"fmt"
-// 	"log"
-// 	"math/rand"
-// 	"net/http"
-// 	"sort"
-// 	"strconv"
-// 	"time"
-// )
-
-// // Location represents a city or bus station
-// type Location struct {
-// 	ID      string  `json:"id"`
-// 	Name    string  `json:"name"`
-// 	City    string  `json:"city"`
-// 	State   string  `json:"state"`
-// 	Country string  `json:"country"`
-// 	Lat     float64 `json:"latitude"`
-// 	Lng     float64 `json:"longitude"`
-// }
-
-// // BusOperator represents a bus company
-// type BusOperator struct {
-// 	ID       string  `json:"id"`
-// 	Name     string  `json:"name"`
-// 	Logo     string  `json:"logo"`
-// 	Rating   float64 `json:"rating"`
-// 	Platform string  `json:"platform"` // which booking platform
-// }
-
-// // BusType represents different types of buses
-// type BusType struct {
-// 	ID          string   `json:"id"`
-// 	Name        string   `json:"name"`
-// 	Seats       int      `json:"seats"`
-// 	Amenities   []string `json:"amenities"`
-// 	Description string   `json:"description"`
-// }
-
-// // Route represents a bus route with pricing
-// type Route struct {
-// 	ID             string      `json:"id"`
-// 	From           Location    `json:"from"`
-// 	To             Location    `json:"to"`
-// 	Operator       BusOperator `json:"operator"`
-// 	BusType        BusType     `json:"bus_type"`
-// 	DepartureTime  time.Time   `json:"departure_time"`
-// 	ArrivalTime    time.Time   `json:"arrival_time"`
-// 	Duration       string      `json:"duration"`
-// 	Price          Price       `json:"price"`
-// 	AvailableSeats int         `json:"available_seats"`
-// 	BookingURL     string      `json:"booking_url"`
-// }
-
-// // Price represents pricing information
-// type Price struct {
-// 	Amount   float64 `json:"amount"`
-// 	Currency string  `json:"currency"`
-// 	Platform string  `json:"platform"`
-// }
-
-// // SearchRequest represents a search query
-// type SearchRequest struct {
-// 	FromCity   string    `json:"from_city"`
-// 	ToCity     string    `json:"to_city"`
-// 	Date       time.Time `json:"date"`
-// 	Passengers int       `json:"passengers"`
-// }
-
-// // SearchResponse represents the aggregated search results
-// type SearchResponse struct {
-// 	Status     string  `json:"status"`
-// 	Message    string  `json:"message"`
-// 	SearchID   string  `json:"search_id"`
-// 	Routes     []Route `json:"routes"`
-// 	TotalFound int     `json:"total_found"`
-// 	SearchTime string  `json:"search_time"`
-// }
-
-// // BookingPlatform represents external booking platforms
-// type BookingPlatform struct {
-// 	ID       string `json:"id"`
-// 	Name     string `json:"name"`
-// 	BaseURL  string `json:"base_url"`
-// 	APIKey   string `json:"api_key,omitempty"`
-// 	IsActive bool   `json:"is_active"`
-// }
-
-// // Some sample data for testing
-// func GetSampleLocations() []Location {
-// 	return []Location{
-// 		{
-// 			ID: "mumbai", Name: "Mumbai Central", City: "Mumbai",
-// 			State: "Maharashtra", Country: "India", Lat: 19.0760, Lng: 72.8777,
-// 		},
-// 		{
-// 			ID: "pune", Name: "Pune Station", City: "Pune",
-// 			State: "Maharashtra", Country: "India", Lat: 18.5204, Lng: 73.8567,
-// 		},
-// 		{
-// 			ID: "bangalore", Name: "Bangalore Majestic", City: "Bangalore",
-// 			State: "Karnataka", Country: "India", Lat: 12.9716, Lng: 77.5946,
-// 		},
-// 		{
-// 			ID: "delhi", Name: "Delhi ISBT", City: "Delhi",
-// 			State: "Delhi", Country: "India", Lat: 28.7041, Lng: 77.1025,
-// 		},
-// 	}
-// }
-
-// func GetSampleOperators() []BusOperator {
-// 	return []BusOperator{
-// 		{ID: "redbus", Name: "RedBus", Logo: "redbus.png", Rating: 4.2, Platform: "redbus"},
-// 		{ID: "makemytrip", Name: "MakeMyTrip", Logo: "mmt.png", Rating: 4.0, Platform: "makemytrip"},
-// 		{ID: "goibibo", Name: "Goibibo", Logo: "goibibo.png", Rating: 3.9, Platform: "goibibo"},
-// 		{ID: "abhibus", Name: "AbhiBus", Logo: "abhibus.png", Rating: 4.1, Platform: "abhibus"},
-// 	}
-// }
-
-// func GetSampleBusTypes() []BusType {
-// 	return []BusType{
-// 		{
-// 			ID: "ac_sleeper", Name: "AC Sleeper", Seats: 40,
-// 			Amenities:   []string{"AC", "Sleeper", "Blanket", "Pillow"},
-// 			Description: "Air conditioned sleeper bus with comfortable berths",
-// 		},
-// 		{
-// 			ID: "non_ac_seater", Name: "Non-AC Seater", Seats: 50,
-// 			Amenities:   []string{"Pushback Seats", "Charging Point"},
-// 			Description: "Comfortable seater bus for day travel",
-// 		},
-// 		{
-// 			ID: "volvo_ac", Name: "Volvo AC", Seats: 45,
-// 			Amenities:   []string{"AC", "WiFi", "Entertainment", "USB Charging"},
-// 			Description: "Premium Volvo bus with luxury amenities",
-// 		},
-// 	}
-// }
-
-// // Response represents a standard API response
-// type Response struct {
-// 	Status  string      `json:"status"`
-// 	Message string      `json:"message"`
-// 	Data    interface{} `json:"data,omitempty"`
-// }
-
-// // sendJSON sends a JSON response
-// func sendJSON(w http.ResponseWriter, statusCode int, data interface{}) {
-// 	w.Header().Set("Content-Type", "application/json")
-// 	w.WriteHeader(statusCode)
-// 	json.NewEncoder(w).Encode(data)
-// }
-
-// // homeHandler handles the root endpoint
-// func homeHandler(w http.ResponseWriter, r *http.Request) {
-// 	response := Response{
-// 		Status:  "success",
-// 		Message: "Welcome to Bus Booking Aggregator API",
-// 		Data:    map[string]string{"version": "1.0.0"},
-// 	}
-// 	sendJSON(w, http.StatusOK, response)
-// }
-
-// // healthHandler handles health check
-// func healthHandler(w http.ResponseWriter, r *http.Request) {
-// 	response := Response{
-// 		Status:  "success",
-// 		Message: "Service is healthy",
-// 	}
-// 	sendJSON(w, http.StatusOK, response)
-// }
-
-// // func main() {
-// // 	// Create a new HTTP multiplexer
-// // 	mux := http.NewServeMux()
-
-// // 	// Register routes
-// // 	mux.HandleFunc("/", homeHandler)
-// // 	mux.HandleFunc("/health", healthHandler)
-
-// // 	// Server configuration
-// // 	port := ":8080"
-
-// // 	fmt.Printf("🚌 Bus Booking Aggregator starting on port %s\n", port)
-// // 	fmt.Printf("📍 http://localhost%s\n", port)
-
-// // 	// Start the server
-// // 	log.Fatal(http.ListenAndServe(port, mux))
-// // }
-
-// type PlatformService interface {
-// 	SearchRoutes(req SearchRequest) ([]Route, error)
-// 	GetPlatformName() string
-// }
-
-// // RedBusService simulates RedBus API
-// type RedBusService struct {
-// 	Name string
-// }
-
-// func (r *RedBusService) GetPlatformName() string {
-// 	return "RedBus"
-// }
-
-// func (r *RedBusService) SearchRoutes(req SearchRequest) ([]Route, error) {
-// 	// Simulate API delay
-// 	time.Sleep(time.Duration(rand.Intn(500)+200) * time.Millisecond)
-
-// 	locations := GetSampleLocations()
-// 	operators := GetSampleOperators()
-// 	busTypes := GetSampleBusTypes()
-
-// 	// Find from and to locations
-// 	var fromLoc, toLoc Location
-// 	for _, loc := range locations {
-// 		if loc.City == req.FromCity {
-// 			fromLoc = loc
-// 		}
-// 		if loc.City == req.ToCity {
-// 			toLoc = loc
-// 		}
-// 	}
-
-// 	// Generate mock routes
-// 	routes := []Route{}
-// 	basePrice := 500 + rand.Float64()*1000 // Random base price between 500-1500
-
-// 	for i := 0; i < rand.Intn(3)+2; i++ { // 2-4 routes
-// 		route := Route{
-// 			ID:            fmt.Sprintf("redbus_%d", i+1),
-// 			From:          fromLoc,
-// 			To:            toLoc,
-// 			Operator:      operators[0], // RedBus operator
-// 			BusType:       busTypes[rand.Intn(len(busTypes))],
-// 			DepartureTime: req.Date.Add(time.Hour * time.Duration(6+i*4)),   // 6AM, 10AM, 2PM, 6PM
-// 			ArrivalTime:   req.Date.Add(time.Hour * time.Duration(6+i*4+8)), // +8 hours journey
-// 			Duration:      "8h 0m",
-// 			Price: Price{
-// 				Amount:   basePrice + float64(i*100),
-// 				Currency: "INR",
-// 				Platform: "RedBus",
-// 			},
-// 			AvailableSeats: rand.Intn(20) + 5, // 5-25 seats
-// 			BookingURL:     "https://redbus.in/book/route123",
-// 		}
-// 		routes = append(routes, route)
-// 	}
-
-// 	return routes, nil
-// }
-
-// // MakeMyTripService simulates MakeMyTrip API
-// type MakeMyTripService struct {
-// 	Name string
-// }
-
-// func (m *MakeMyTripService) GetPlatformName() string {
-// 	return "MakeMyTrip"
-// }
-
-// func (m *MakeMyTripService) SearchRoutes(req SearchRequest) ([]Route, error) {
-// 	time.Sleep(time.Duration(rand.Intn(600)+300) * time.Millisecond)
-
-// 	locations := GetSampleLocations()
-// 	operators := GetSampleOperators()
-// 	busTypes := GetSampleBusTypes()
-
-// 	var fromLoc, toLoc Location
-// 	for _, loc := range locations {
-// 		if loc.City == req.FromCity {
-// 			fromLoc = loc
-// 		}
-// 		if loc.City == req.ToCity {
-// 			toLoc = loc
-// 		}
-// 	}
-
-// 	routes := []Route{}
-// 	basePrice := 450 + rand.Float64()*1200
-
-// 	for i := 0; i < rand.Intn(4)+1; i++ { // 1-4 routes
-// 		route := Route{
-// 			ID:            fmt.Sprintf("mmt_%d", i+1),
-// 			From:          fromLoc,
-// 			To:            toLoc,
-// 			Operator:      operators[1], // MakeMyTrip operator
-// 			BusType:       busTypes[rand.Intn(len(busTypes))],
-// 			DepartureTime: req.Date.Add(time.Hour * time.Duration(7+i*3)),
-// 			ArrivalTime:   req.Date.Add(time.Hour * time.Duration(7+i*3+9)),
-// 			Duration:      "9h 0m",
-// 			Price: Price{
-// 				Amount:   basePrice + float64(i*150),
-// 				Currency: "INR",
-// 				Platform: "MakeMyTrip",
-// 			},
-// 			AvailableSeats: rand.Intn(15) + 3,
-// 			BookingURL:     "https://makemytrip.com/bus/book/xyz",
-// 		}
-// 		routes = append(routes, route)
-// 	}
-
-// 	return routes, nil
-// }
-
-// // GoibiboService simulates Goibibo API
-// type GoibiboService struct {
-// 	Name string
-// }
-
-// func (g *GoibiboService) GetPlatformName() string {
-// 	return "Goibibo"
-// }
-
-// func (g *GoibiboService) SearchRoutes(req SearchRequest) ([]Route, error) {
-// 	time.Sleep(time.Duration(rand.Intn(400)+250) * time.Millisecond)
-
-// 	locations := GetSampleLocations()
-// 	operators := GetSampleOperators()
-// 	busTypes := GetSampleBusTypes()
-
-// 	var fromLoc, toLoc Location
-// 	for _, loc := range locations {
-// 		if loc.City == req.FromCity {
-// 			fromLoc = loc
-// 		}
-// 		if loc.City == req.ToCity {
-// 			toLoc = loc
-// 		}
-// 	}
-
-// 	routes := []Route{}
-// 	basePrice := 600 + rand.Float64()*900
-
-// 	for i := 0; i < rand.Intn(3)+2; i++ { // 2-4 routes
-// 		route := Route{
-// 			ID:            fmt.Sprintf("goibibo_%d", i+1),
-// 			From:          fromLoc,
-// 			To:            toLoc,
-// 			Operator:      operators[2], // Goibibo operator
-// 			BusType:       busTypes[rand.Intn(len(busTypes))],
-// 			DepartureTime: req.Date.Add(time.Hour * time.Duration(8+i*4)),
-// 			ArrivalTime:   req.Date.Add(time.Hour * time.Duration(8+i*4+7)),
-// 			Duration:      "7h 30m",
-// 			Price: Price{
-// 				Amount:   basePrice + float64(i*80),
-// 				Currency: "INR",
-// 				Platform: "Goibibo",
-// 			},
-// 			AvailableSeats: rand.Intn(25) + 8,
-// 			BookingURL:     "https://goibibo.com/bus/booking/abc",
-// 		}
-// 		routes = append(routes, route)
-// 	}
-
-// 	return routes, nil
-// }
-
-// // PlatformManager manages all booking platforms
-// type PlatformManager struct {
-// 	platforms []PlatformService
-// }
-
-// func NewPlatformManager() *PlatformManager {
-// 	return &PlatformManager{
-// 		platforms: []PlatformService{
-// 			&RedBusService{Name: "RedBus"},
-// 			&MakeMyTripService{Name: "MakeMyTrip"},
-// 			&GoibiboService{Name: "Goibibo"},
-// 		},
-// 	}
-// }
-
-// func (pm *PlatformManager) SearchAllPlatforms(req SearchRequest) ([]Route, error) {
-// 	var allRoutes []Route
-
-// 	// Channel to collect routes from all platforms
-// 	routesChan := make(chan []Route, len(pm.platforms))
-// 	errorsChan := make(chan error, len(pm.platforms))
-
-// 	// Search all platforms concurrently
-// 	for _, platform := range pm.platforms {
-// 		go func(p PlatformService) {
-// 			routes, err := p.SearchRoutes(req)
-// 			if err != nil {
-// 				errorsChan <- fmt.Errorf("%s error: %v", p.GetPlatformName(), err)
-// 				routesChan <- nil
-// 				return
-// 			}
-// 			routesChan <- routes
-// 			errorsChan <- nil
-// 		}(platform)
-// 	}
-
-// 	// Collect results
-// 	for i := 0; i < len(pm.platforms); i++ {
-// 		routes := <-routesChan
-// 		err := <-errorsChan
-
-// 		if err != nil {
-// 			fmt.Printf("Warning: %v\n", err)
-// 			continue
-// 		}
-
-// 		if routes != nil {
-// 			allRoutes = append(allRoutes, routes...)
-// 		}
-// 	}
-
-// 	return allRoutes, nil
-// }
-
-// var platformManager *PlatformManager
-
-// // CORS middleware to handle cross-origin requests
-// func enableCORS(w http.ResponseWriter) {
-// 	w.Header().Set("Access-Control-Allow-Origin", "*")
-// 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-// 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-// }
-
-// // searchHandler handles bus search requests
-// func searchHandler(w http.ResponseWriter, r *http.Request) {
-// 	enableCORS(w)
-
-// 	if r.Method == "OPTIONS" {
-// 		w.WriteHeader(http.StatusOK)
-// 		return
-// 	}
-
-// 	if r.Method != "POST" {
-// 		sendJSON(w, http.StatusMethodNotAllowed, Response{
-// 			Status:  "error",
-// 			Message: "Only POST method is allowed",
-// 		})
-// 		return
-// 	}
-
-// 	// Parse request body
-// 	var searchReq SearchRequest
-// 	if err := json.NewDecoder(r.Body).Decode(&searchReq); err != nil {
-// 		sendJSON(w, http.StatusBadRequest, Response{
-// 			Status:  "error",
-// 			Message: "Invalid JSON request body",
-// 		})
-// 		return
-// 	}
-
-// 	// Validate required fields
-// 	if searchReq.FromCity == "" || searchReq.ToCity == "" {
-// 		sendJSON(w, http.StatusBadRequest, Response{
-// 			Status:  "error",
-// 			Message: "from_city and to_city are required",
-// 		})
-// 		return
-// 	}
-
-// 	// Set default values
-// 	if searchReq.Passengers == 0 {
-// 		searchReq.Passengers = 1
-// 	}
-// 	if searchReq.Date.IsZero() {
-// 		searchReq.Date = time.Now().AddDate(0, 0, 1) // Tomorrow
-// 	}
-
-// 	start := time.Now()
-
-// 	// Search all platforms
-// 	routes, err := platformManager.SearchAllPlatforms(searchReq)
-// 	if err != nil {
-// 		sendJSON(w, http.StatusInternalServerError, Response{
-// 			Status:  "error",
-// 			Message: fmt.Sprintf("Search failed: %v", err),
-// 		})
-// 		return
-// 	}
-
-// 	// Sort routes by price (ascending)
-// 	sort.Slice(routes, func(i, j int) bool {
-// 		return routes[i].Price.Amount < routes[j].Price.Amount
-// 	})
-
-// 	searchTime := time.Since(start)
-// 	searchID := fmt.Sprintf("search_%d", time.Now().Unix())
-
-// 	response := SearchResponse{
-// 		Status:     "success",
-// 		Message:    "Routes found successfully",
-// 		SearchID:   searchID,
-// 		Routes:     routes,
-// 		TotalFound: len(routes),
-// 		SearchTime: fmt.Sprintf("%.2fs", searchTime.Seconds()),
-// 	}
-
-// 	sendJSON(w, http.StatusOK, response)
-// }
-
-// // routesHandler returns available routes (GET endpoint for testing)
-// func routesHandler(w http.ResponseWriter, r *http.Request) {
-// 	enableCORS(w)
-
-// 	// Get query parameters
-// 	fromCity := r.URL.Query().Get("from")
-// 	toCity := r.URL.Query().Get("to")
-// 	dateStr := r.URL.Query().Get("date")
-// 	passengersStr := r.URL.Query().Get("passengers")
-
-// 	if fromCity == "" || toCity == "" {
-// 		sendJSON(w, http.StatusBadRequest, Response{
-// 			Status:  "error",
-// 			Message: "from and to parameters are required",
-// 		})
-// 		return
-// 	}
-
-// 	// Parse date
-// 	var searchDate time.Time
-// 	if dateStr != "" {
-// 		var err error
-// 		searchDate, err = time.Parse("2006-01-02", dateStr)
-// 		if err != nil {
-// 			sendJSON(w, http.StatusBadRequest, Response{
-// 				Status:  "error",
-// 				Message: "Invalid date format. Use YYYY-MM-DD",
-// 			})
-// 			return
-// 		}
-// 	} else {
-// 		searchDate = time.Now().AddDate(0, 0, 1) // Tomorrow
-// 	}
-
-// 	// Parse passengers
-// 	passengers := 1
-// 	if passengersStr != "" {
-// 		var err error
-// 		passengers, err = strconv.Atoi(passengersStr)
-// 		if err != nil || passengers < 1 {
-// 			passengers = 1
-// 		}
-// 	}
-
-// 	searchReq := SearchRequest{
-// 		FromCity:   fromCity,
-// 		ToCity:     toCity,
-// 		Date:       searchDate,
-// 		Passengers: passengers,
-// 	}
-
-// 	start := time.Now()
-// 	routes, err := platformManager.SearchAllPlatforms(searchReq)
-// 	if err != nil {
-// 		sendJSON(w, http.StatusInternalServerError, Response{
-// 			Status:  "error",
-// 			Message: fmt.Sprintf("Search failed: %v", err),
-// 		})
-// 		return
-// 	}
-
-// 	// Sort by price
-// 	sort.Slice(routes, func(i, j int) bool {
-// 		return routes[i].Price.Amount < routes[j].Price.Amount
-// 	})
-
-// 	searchTime := time.Since(start)
-
-// 	response := SearchResponse{
-// 		Status:     "success",
-// 		Message:    "Routes found successfully",
-// 		SearchID:   fmt.Sprintf("search_%d", time.Now().Unix()),
-// 		Routes:     routes,
-// 		TotalFound: len(routes),
-// 		SearchTime: fmt.Sprintf("%.2fs", searchTime.Seconds()),
-// 	}
-
-// 	sendJSON(w, http.StatusOK, response)
-// }
-
-// // citiesHandler returns available cities
-// func citiesHandler(w http.ResponseWriter, r *http.Request) {
-// 	enableCORS(w)
-
-// 	cities := []map[string]string{}
-// 	for _, loc := range GetSampleLocations() {
-// 		cities = append(cities, map[string]string{
-// 			"id":    loc.ID,
-// 			"name":  loc.City,
-// 			"state": loc.State,
-// 		})
-// 	}
-
-// 	sendJSON(w, http.StatusOK, Response{
-// 		Status:  "success",
-// 		Message: "Cities retrieved successfully",
-// 		Data:    cities,
-// 	})
-// }
-
-// // platformsHandler returns available booking platforms
-// func platformsHandler(w http.ResponseWriter, r *http.Request) {
-// 	enableCORS(w)
-
-// 	platforms := []map[string]interface{}{
-// 		{"name": "RedBus", "active": true, "avg_response_time": "300ms"},
-// 		{"name": "MakeMyTrip", "active": true, "avg_response_time": "450ms"},
-// 		{"name": "Goibibo", "active": true, "avg_response_time": "325ms"},
-// 	}
-
-// 	sendJSON(w, http.StatusOK, Response{
-// 		Status:  "success",
-// 		Message: "Platforms retrieved successfully",
-// 		Data:    platforms,
-// 	})
-// }
-
-// func main() {
-// 	// Initialize random seed
-// 	rand.Seed(time.Now().UnixNano())
-
-// 	// Initialize platform manager
-// 	platformManager = NewPlatformManager()
-
-// 	// Create HTTP multiplexer
-// 	mux := http.NewServeMux()
-
-// 	// Register routes
-// 	mux.HandleFunc("/", homeHandler)
-// 	mux.HandleFunc("/health-check", healthHandler)
-// 	mux.HandleFunc("/search", searchHandler)
-// 	mux.HandleFunc("/routes", routesHandler)
-// 	mux.HandleFunc("/cities", citiesHandler)
-// 	mux.HandleFunc("/platforms", platformsHandler)
-
-// 	// Server configuration
-// 	port := ":8080"
-
-// 	fmt.Printf("🚌 Bus Booking Aggregator API\n")
-// 	fmt.Printf("📍 Server: http://localhost%s\n", port)
-// 	fmt.Printf("📋 Endpoints:\n")
-// 	fmt.Printf("   GET  /              - API info\n")
-// 	fmt.Printf("   GET  /health        - Health check\n")
-// 	fmt.Printf("   GET  /cities        - Available cities\n")
-// 	fmt.Printf("   GET  /platforms     - Booking platforms\n")
-// 	fmt.Printf("   GET  /routes        - Search routes (query params)\n")
-// 	fmt.Printf("   POST /search        - Search routes (JSON body)\n")
-// 	fmt.Printf("\n🔍 Example GET request:\n")
-// 	fmt.Printf("   http://localhost%s/routes?from=Mumbai&to=Pune&date=2025-08-21&passengers=2\n", port)
-// 	fmt.Printf("\n📝 Example POST request body:\n")
-// 	fmt.Printf(`   {
-//      "from_city": "Mumbai",
-//      "to_city": "Pune", 
-//      "date": "2025-08-21T00:00:00Z",
-//      "passengers": 2
-//    }`)
-
-// 	fmt.Printf("\n🚀 Starting server...\n")
-// 	log.Fatal(http.ListenAndServe(port, mux))
-// }
-
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
-	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-	"math/rand"
 )
 
 // Global configuration and platform manager
 var config Config
-var realPlatformManager *RealPlatformManager
+
+// configMu serialises changes to config made through POST /config
+var configMu sync.Mutex
+
+// realPlatformManager is replaced as a whole when the configuration
+// changes; load it once per use
+var realPlatformManager atomic.Pointer[RealPlatformManager]
 
 // CORS middleware
 func enableCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key")
+}
+
+// corsPreflight answers preflight requests for routes registered by method
+func corsPreflight(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	w.WriteHeader(http.StatusOK)
 }
 
 // Enhanced search handler with real API integration
 func enhancedSearchHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	
+
 	if r.Method != "POST" {
 		sendJSON(w, http.StatusMethodNotAllowed, Response{
 			Status:  "error",
@@ -699,7 +56,7 @@ func enhancedSearchHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	
+
 	var searchReq SearchRequest
 	if err := json.NewDecoder(r.Body).Decode(&searchReq); err != nil {
 		sendJSON(w, http.StatusBadRequest, Response{
@@ -708,16 +65,39 @@ func enhancedSearchHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	
+
 	// Validate required fields
-	if searchReq.FromCity == "" || searchReq.ToCity == "" {
+	if len(searchReq.Legs) == 0 && (searchReq.FromCity == "" || searchReq.ToCity == "") {
 		sendJSON(w, http.StatusBadRequest, Response{
 			Status:  "error",
 			Message: "from_city and to_city are required",
 		})
 		return
 	}
-	
+	if err := searchReq.SearchFilters.Validate(); err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid filters: %v", err),
+		})
+		return
+	}
+	if err := searchReq.RankOptions.Validate(); err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid sort: %v", err),
+		})
+		return
+	}
+	display, err := parseDisplayCurrency(searchReq.DisplayCurrency)
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid display_currency: %v", err),
+		})
+		return
+	}
+	searchReq.DisplayCurrency = display
+
 	// Set defaults
 	if searchReq.Passengers == 0 {
 		searchReq.Passengers = 1
@@ -725,49 +105,27 @@ func enhancedSearchHandler(w http.ResponseWriter, r *http.Request) {
 	if searchReq.Date.IsZero() {
 		searchReq.Date = time.Now().AddDate(0, 0, 1)
 	}
-	
-	start := time.Now()
-	
-	// Use real platform manager
-	routes, err := realPlatformManager.SearchAllPlatforms(searchReq)
-	if err != nil {
-		sendJSON(w, http.StatusInternalServerError, Response{
+	if err := searchReq.ValidateLegs(); err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
 			Status:  "error",
-			Message: fmt.Sprintf("Search failed: %v", err),
+			Message: err.Error(),
 		})
 		return
 	}
-	
-	// Sort routes by price
-	sort.Slice(routes, func(i, j int) bool {
-		return routes[i].Price.Amount < routes[j].Price.Amount
-	})
-	
-	searchTime := time.Since(start)
-	searchID := fmt.Sprintf("search_%d", time.Now().Unix())
-	
-	response := SearchResponse{
-		Status:     "success",
-		Message:    fmt.Sprintf("Found %d routes from %d platforms", len(routes), len(realPlatformManager.platforms)),
-		SearchID:   searchID,
-		Routes:     routes,
-		TotalFound: len(routes),
-		SearchTime: fmt.Sprintf("%.2fs", searchTime.Seconds()),
-	}
-	
-	sendJSON(w, http.StatusOK, response)
+
+	respondWithSearch(w, r, searchReq)
 }
 
 // Enhanced routes handler
 func enhancedRoutesHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
+
 	// Get query parameters
 	fromCity := r.URL.Query().Get("from")
 	toCity := r.URL.Query().Get("to")
 	dateStr := r.URL.Query().Get("date")
 	passengersStr := r.URL.Query().Get("passengers")
-	
+
 	if fromCity == "" || toCity == "" {
 		sendJSON(w, http.StatusBadRequest, Response{
 			Status:  "error",
@@ -775,7 +133,7 @@ func enhancedRoutesHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	
+
 	// Parse date
 	var searchDate time.Time
 	if dateStr != "" {
@@ -791,7 +149,7 @@ func enhancedRoutesHandler(w http.ResponseWriter, r *http.Request) {
 	} else {
 		searchDate = time.Now().AddDate(0, 0, 1)
 	}
-	
+
 	// Parse passengers
 	passengers := 1
 	if passengersStr != "" {
@@ -801,244 +159,1464 @@ func enhancedRoutesHandler(w http.ResponseWriter, r *http.Request) {
 			passengers = 1
 		}
 	}
-	
-	searchReq := SearchRequest{
-		FromCity:   fromCity,
-		ToCity:     toCity,
-		Date:       searchDate,
-		Passengers: passengers,
+
+	filters, err := ParseFilterParams(r.URL.Query())
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid filters: %v", err),
+		})
+		return
 	}
-	
-	start := time.Now()
-	routes, err := realPlatformManager.SearchAllPlatforms(searchReq)
+
+	rank, err := ParseRankParams(r.URL.Query())
 	if err != nil {
-		sendJSON(w, http.StatusInternalServerError, Response{
+		sendJSON(w, http.StatusBadRequest, Response{
 			Status:  "error",
-			Message: fmt.Sprintf("Search failed: %v", err),
+			Message: fmt.Sprintf("Invalid sort: %v", err),
 		})
 		return
 	}
-	
-	// Sort by price
-	sort.Slice(routes, func(i, j int) bool {
-		return routes[i].Price.Amount < routes[j].Price.Amount
-	})
-	
-	searchTime := time.Since(start)
-	
-	response := SearchResponse{
-		Status:     "success",
-		Message:    fmt.Sprintf("Found %d routes from %d platforms", len(routes), len(realPlatformManager.platforms)),
-		SearchID:   fmt.Sprintf("search_%d", time.Now().Unix()),
-		Routes:     routes,
-		TotalFound: len(routes),
-		SearchTime: fmt.Sprintf("%.2fs", searchTime.Seconds()),
-	}
-	
-	sendJSON(w, http.StatusOK, response)
-}
 
-// API status handler showing which APIs are configured
-func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
-	
-	status := map[string]interface{}{
-		"configured_apis": []map[string]interface{}{},
-		"total_platforms": len(realPlatformManager.platforms),
-		"server_time":     time.Now().UTC(),
+	display, err := parseDisplayCurrency(r.URL.Query().Get("currency"))
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid currency: %v", err),
+		})
+		return
 	}
-	
-	// Check which APIs are configured
-	apis := []map[string]interface{}{
-		{
-			"name":        "RedBus Mock",
-			"status":      "active",
-			"type":        "mock",
-			"description": "Mock RedBus service for testing",
-		},
+
+	var layovers [2]Duration
+	for i, name := range []string{"min_layover", "max_layover"} {
+		if value := r.URL.Query().Get(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				sendJSON(w, http.StatusBadRequest, Response{
+					Status:  "error",
+					Message: fmt.Sprintf("Invalid %s. Use a duration such as 45m or 2h", name),
+				})
+				return
+			}
+			layovers[i] = Duration(d)
+		}
+	}
+
+	searchReq := SearchRequest{
+		FromCity:      fromCity,
+		ToCity:        toCity,
+		Date:          searchDate,
+		Passengers:    passengers,
+		MinLayover:    layovers[0],
+		MaxLayover:    layovers[1],
+		SearchFilters: filters,
+		RankOptions:   rank,
+
+		DisplayCurrency: display,
+	}
+
+	if returnStr := r.URL.Query().Get("return_date"); returnStr != "" {
+		returnDate, err := time.Parse("2006-01-02", returnStr)
+		if err != nil {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "Invalid return_date format. Use YYYY-MM-DD",
+			})
+			return
+		}
+		searchReq.ReturnDate = &returnDate
 	}
-	
-	if config.RedBusAPIKey != "" {
-		apis = append(apis, map[string]interface{}{
-			"name":        "RedBus Real API",
-			"status":      "active",
-			"type":        "real",
-			"description": "Real RedBus API integration",
+	if err := searchReq.ValidateLegs(); err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
 		})
-	} else {
-		apis = append(apis, map[string]interface{}{
-			"name":        "RedBus Real API",
-			"status":      "not_configured",
-			"type":        "real",
-			"description": "RedBus API key not provided",
+		return
+	}
+
+	respondWithSearch(w, r, searchReq)
+}
+
+// respondWithSearch runs the search across all platforms and writes the
+// aggregated response, including the per-platform breakdown. When every
+// provider failed the response is a 502 so clients can tell "no buses"
+// apart from "no providers".
+func respondWithSearch(w http.ResponseWriter, r *http.Request, searchReq SearchRequest) {
+	if searchReq.IsJourney() {
+		respondWithJourney(w, r, searchReq)
+		return
+	}
+
+	start := time.Now()
+	if err := canonicalizeCities(&searchReq); err != nil {
+		sendCityError(w, err)
+		return
+	}
+
+	search, err := realPlatformManager.Load().SearchAllPlatforms(r.Context(), searchReq)
+	if errors.Is(err, ErrAllPlatformsFailed) {
+		sendJSON(w, http.StatusBadGateway, SearchResponse{
+			Status:     "error",
+			Message:    fmt.Sprintf("All %d platforms failed", len(search.Platforms)),
+			Routes:     []Route{},
+			SearchTime: fmt.Sprintf("%.2fs", time.Since(start).Seconds()),
+			Platforms:  search.Platforms,
+			Trips:      []Trip{},
 		})
+		return
 	}
-	
-	if config.RapidAPIKey != "" {
-		apis = append(apis, map[string]interface{}{
-			"name":        "RapidAPI Transport",
-			"status":      "active",
-			"type":        "real",
-			"description": "RapidAPI transportation services",
+	if errors.Is(err, ErrNoMatchingPlatforms) {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
 		})
-	} else {
-		apis = append(apis, map[string]interface{}{
-			"name":        "RapidAPI Transport",
-			"status":      "not_configured",
-			"type":        "real",
-			"description": "RapidAPI key not provided",
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Search failed: %v", err),
 		})
+		return
 	}
-	
-	status["configured_apis"] = apis
-	
-	sendJSON(w, http.StatusOK, Response{
-		Status:  "success",
-		Message: "API status retrieved",
-		Data:    status,
+
+	// No direct bus at all, not merely none left by the filters: look for
+	// journeys with one change
+	var itineraries []Itinerary
+	if len(search.Unfiltered) == 0 {
+		itineraries = NewConnectionPlanner(cityCatalogue).Plan(r.Context(), realPlatformManager.Load(), searchReq)
+	}
+
+	session := searchSessions.Save(searchReq, search, itineraries)
+	recordSearch(session)
+	view := searchSessions.View(session, searchReq.SearchFilters, searchReq.RankOptions)
+	sendSessionPage(w, r, session, view, searchReq.RankOptions, searchReq.DisplayCurrency, time.Since(start))
+}
+
+// parseDisplayCurrency checks a requested display currency; "" means none
+func parseDisplayCurrency(code string) (string, error) {
+	code = currencyCode(code)
+	if code != "" && !currencies.Supports(code) {
+		return "", fmt.Errorf("no exchange rate for %q", code)
+	}
+	return code, nil
+}
+
+// canonicalizeCities sends providers the canonical city name, so "Bombay"
+// and "mumbai" both search Mumbai. Names we don't know are passed through
+// as typed; misspellings of known cities fail with an UnknownCityError
+// instead of quietly searching another city.
+func canonicalizeCities(req *SearchRequest) error {
+	from, err := cityCatalogue.Canonical(req.FromCity)
+	if err != nil {
+		return err
+	}
+	to, err := cityCatalogue.Canonical(req.ToCity)
+	if err != nil {
+		return err
+	}
+	req.FromCity, req.ToCity = from, to
+	return nil
+}
+
+// sendCityError answers a search whose city canonicalizeCities rejected,
+// with the suggested cities as data
+func sendCityError(w http.ResponseWriter, err error) {
+	sendJSON(w, http.StatusBadRequest, Response{
+		Status:  "error",
+		Message: err.Error(),
+		Data:    err,
 	})
 }
 
-// Configuration endpoint to update API keys (for development)
-func configHandler(w http.ResponseWriter, r *http.Request) {
-	enableCORS(w)
-	
-	if r.Method == "GET" {
-		// Return current config (without sensitive data)
-		safeConfig := map[string]interface{}{
-			"redbus_configured":  config.RedBusAPIKey != "",
-			"rapidapi_configured": config.RapidAPIKey != "",
-			"server_port":        config.ServerPort,
+// respondWithJourney searches every leg of a round-trip or multi-city
+// request concurrently and prices the cheapest combination. Each leg gets
+// its own result session. The response is a 502 only when every leg
+// failed outright.
+func respondWithJourney(w http.ResponseWriter, r *http.Request, searchReq SearchRequest) {
+	start := time.Now()
+
+	legs := searchReq.LegRequests()
+	for i := range legs {
+		if err := canonicalizeCities(&legs[i]); err != nil {
+			sendCityError(w, err)
+			return
 		}
-		
-		sendJSON(w, http.StatusOK, Response{
-			Status:  "success",
-			Message: "Configuration retrieved",
-			Data:    safeConfig,
-		})
-		return
 	}
-	
-	if r.Method == "POST" {
-		var newConfig struct {
-			RedBusAPIKey string `json:"redbus_api_key"`
-			RapidAPIKey  string `json:"rapidapi_key"`
-		}
-		
-		if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
+
+	response := JourneyResponse{
+		Status: "success",
+		Sort:   searchReq.RankOptions.order(),
+		Legs:   make([]LegResult, 0, len(legs)),
+
+		BaseCurrency:    currencies.Base(),
+		DisplayCurrency: searchReq.DisplayCurrency,
+	}
+
+	failed := 0
+	legRoutes := make([][]Route, 0, len(legs))
+	for i, leg := range realPlatformManager.Load().SearchJourney(r.Context(), legs) {
+		if errors.Is(leg.Err, ErrNoMatchingPlatforms) {
 			sendJSON(w, http.StatusBadRequest, Response{
 				Status:  "error",
-				Message: "Invalid configuration JSON",
+				Message: leg.Err.Error(),
 			})
 			return
 		}
-		
-		// Update configuration
-		if newConfig.RedBusAPIKey != "" {
-			config.RedBusAPIKey = newConfig.RedBusAPIKey
+
+		result := LegResult{
+			Leg:    i + 1,
+			Status: "success",
+			From:   leg.Request.FromCity,
+			To:     leg.Request.ToCity,
+			Date:   leg.Request.Date.Format("2006-01-02"),
+			Routes: []Route{},
+			Trips:  []Trip{},
 		}
-		if newConfig.RapidAPIKey != "" {
-			config.RapidAPIKey = newConfig.RapidAPIKey
+		if leg.Search != nil {
+			result.Platforms = leg.Search.Platforms
+			result.Partial = leg.Search.Partial()
+			result.Cache = leg.Search.CacheSummary()
 		}
-		
-		// Reinitialize platform manager with new config
-		realPlatformManager = NewRealPlatformManager(config.RedBusAPIKey, config.RapidAPIKey)
-		
-		sendJSON(w, http.StatusOK, Response{
-			Status:  "success",
-			Message: "Configuration updated successfully",
-		})
-		return
+
+		if leg.Err != nil {
+			failed++
+			result.Status = "error"
+			result.Message = fmt.Sprintf("Search failed: %v", leg.Err)
+			if errors.Is(leg.Err, ErrAllPlatformsFailed) {
+				result.Message = fmt.Sprintf("All %d platforms failed", len(leg.Search.Platforms))
+			}
+			response.Legs = append(response.Legs, result)
+			legRoutes = append(legRoutes, nil)
+			continue
+		}
+
+		session := searchSessions.Save(leg.Request, leg.Search, nil)
+		recordSearch(session)
+		view := searchSessions.View(session, leg.Request.SearchFilters, leg.Request.RankOptions)
+		result.SearchID = session.ID
+		result.Routes = currencies.DisplayRoutes(view.Routes, searchReq.DisplayCurrency)
+		result.Trips = currencies.DisplayTrips(view.Trips, searchReq.DisplayCurrency)
+		result.TotalFound = len(view.Routes)
+		result.FilteredOut = view.FilteredOut
+		result.Message = fmt.Sprintf("Found %d routes (%d trips) from %d of %d platforms", len(view.Routes), len(view.Trips), leg.Search.Succeeded(), len(leg.Search.Platforms))
+
+		response.Legs = append(response.Legs, result)
+		legRoutes = append(legRoutes, view.Routes)
 	}
-	
-	sendJSON(w, http.StatusMethodNotAllowed, Response{
-		Status:  "error",
-		Message: "Only GET and POST methods are allowed",
-	})
+
+	status := http.StatusOK
+	switch {
+	case failed == len(legs):
+		status = http.StatusBadGateway
+		response.Status = "error"
+		response.Message = "Every leg failed"
+	case failed > 0:
+		response.Message = fmt.Sprintf("%d of %d legs failed", failed, len(legs))
+	default:
+		fare, err := CheapestCombination(legRoutes)
+		if err != nil {
+			response.Message = fmt.Sprintf("Searched %d legs; %v", len(legs), err)
+		} else {
+			fare.Routes = currencies.DisplayRoutes(fare.Routes, searchReq.DisplayCurrency)
+			fare.DisplayTotal = currencies.display(fare.Total, fare.Currency, searchReq.DisplayCurrency)
+			response.Cheapest = fare
+			response.Message = fmt.Sprintf("Searched %d legs; cheapest combination %.2f %s", len(legs), fare.Total, fare.Currency)
+		}
+	}
+	response.SearchTime = fmt.Sprintf("%.2fs", time.Since(start).Seconds())
+
+	sendJSON(w, status, response)
 }
 
-// Test API connectivity
-func testAPIHandler(w http.ResponseWriter, r *http.Request) {
+// searchSessionHandler pages through a previous search's results. sort,
+// weight_* and filter parameters re-sort and re-filter the stored results;
+// when absent, the original search's choices apply.
+func searchSessionHandler(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w)
-	
-	apiName := r.URL.Query().Get("api")
-	if apiName == "" {
-		sendJSON(w, http.StatusBadRequest, Response{
+	start := time.Now()
+
+	if r.Method != "GET" {
+		sendJSON(w, http.StatusMethodNotAllowed, Response{
 			Status:  "error",
-			Message: "api parameter is required (redbus, rapidapi)",
+			Message: "Only GET method is allowed",
 		})
 		return
 	}
-	
-	testReq := SearchRequest{
-		FromCity:   "Mumbai",
-		ToCity:     "Pune",
-		Date:       time.Now().AddDate(0, 0, 1),
-		Passengers: 1,
+
+	session, ok := searchSessions.Get(r.PathValue("id"))
+	if !ok {
+		sendJSON(w, http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Search not found or expired",
+		})
+		return
 	}
-	
-	var routes []Route
-	var err error
-	
-	switch apiName {
-	case "redbus":
-		if config.RedBusAPIKey == "" {
+
+	q := r.URL.Query()
+	filters := session.Request.SearchFilters
+	if HasFilterParams(q) {
+		var err error
+		if filters, err = ParseFilterParams(q); err != nil {
 			sendJSON(w, http.StatusBadRequest, Response{
 				Status:  "error",
-				Message: "RedBus API key not configured",
+				Message: fmt.Sprintf("Invalid filters: %v", err),
 			})
 			return
 		}
-		service := NewRealRedBusService(config.RedBusAPIKey)
-		routes, err = service.SearchRoutes(testReq)
-		
-	case "rapidapi":
-		if config.RapidAPIKey == "" {
+	}
+
+	rank, err := ParseRankParams(q)
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid sort: %v", err),
+		})
+		return
+	}
+	if rank.Sort == "" {
+		rank.Sort = session.Request.Sort
+	}
+	if rank.Weights == nil {
+		rank.Weights = session.Request.Weights
+	}
+
+	display := session.Request.DisplayCurrency
+	if q.Has("currency") {
+		if display, err = parseDisplayCurrency(q.Get("currency")); err != nil {
 			sendJSON(w, http.StatusBadRequest, Response{
 				Status:  "error",
-				Message: "RapidAPI key not configured",
+				Message: fmt.Sprintf("Invalid currency: %v", err),
 			})
 			return
 		}
-		service := NewRapidAPIBusService(config.RapidAPIKey)
-		routes, err = service.SearchRoutes(testReq)
-		
-	default:
+	}
+
+	view := searchSessions.View(session, filters, rank)
+	sendSessionPage(w, r, session, view, rank, display, time.Since(start))
+}
+
+// sendSessionPage writes a view of a search session. Results are paged only
+// when the request asks for a page; /search/{id} always pages. Prices are
+// also shown in display when it is set.
+func sendSessionPage(w http.ResponseWriter, r *http.Request, session *SearchSession, view SessionView, rank RankOptions, display string, elapsed time.Duration) {
+	page, size, paged, err := ParsePageParams(r.URL.Query())
+	if err != nil {
 		sendJSON(w, http.StatusBadRequest, Response{
 			Status:  "error",
-			Message: "Invalid API name. Use 'redbus' or 'rapidapi'",
+			Message: err.Error(),
 		})
 		return
 	}
-	
-	if err != nil {
-		sendJSON(w, http.StatusInternalServerError, Response{
+	paged = paged || r.PathValue("id") != ""
+
+	succeeded := 0
+	for _, platform := range session.Platforms {
+		if platform.Status == PlatformStatusSuccess {
+			succeeded++
+		}
+	}
+
+	expiresAt := session.ExpiresAt
+	response := SearchResponse{
+		Status:      "success",
+		Message:     fmt.Sprintf("Found %d routes (%d trips) from %d of %d platforms", len(view.Routes), len(view.Trips), succeeded, len(session.Platforms)),
+		SearchID:    session.ID,
+		Routes:      view.Routes,
+		TotalFound:  len(view.Routes),
+		SearchTime:  fmt.Sprintf("%.2fs", elapsed.Seconds()),
+		Platforms:   session.Platforms,
+		Partial:     session.Partial,
+		Cache:       session.Cache,
+		FilteredOut: view.FilteredOut,
+		Sort:        rank.order(),
+		ExpiresAt:   &expiresAt,
+		Trips:       view.Trips,
+		Itineraries: session.Itineraries,
+
+		BaseCurrency:    currencies.Base(),
+		DisplayCurrency: display,
+	}
+	if paged {
+		var info PageInfo
+		response.Routes, response.Trips, info = paginate(view.Routes, view.Trips, page, size)
+		response.Page = &info
+	}
+	response.Routes = currencies.DisplayRoutes(response.Routes, display)
+	response.Trips = currencies.DisplayTrips(response.Trips, display)
+	response.Itineraries = currencies.DisplayItineraries(response.Itineraries, display)
+
+	sendJSON(w, http.StatusOK, response)
+}
+
+// fareCalendarHandler returns the cheapest and median fare per day over a
+// date range, so users can pick the cheapest day to travel
+func fareCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	q := r.URL.Query()
+	fromCity := q.Get("from")
+	toCity := q.Get("to")
+	if fromCity == "" || toCity == "" {
+		sendJSON(w, http.StatusBadRequest, Response{
 			Status:  "error",
-			Message: fmt.Sprintf("API test failed: %v", err),
+			Message: "from and to parameters are required",
 		})
 		return
 	}
-	
-	sendJSON(w, http.StatusOK, Response{
-		Status:  "success",
-		Message: fmt.Sprintf("%s API test successful", apiName),
-		Data: map[string]interface{}{
-			"routes_found": len(routes),
-			"test_query":   testReq,
-		},
-	})
-}
 
-func main() {
-	// Initialize random seed
-	rand.Seed(time.Now().UnixNano())
-	
-	// Load configuration
-	config = LoadConfig()
-	
-	// Check for environment variables
-	if envRedBusKey := os.Getenv("REDBUS_API_KEY"); envRedBusKey != "" {
-		config.RedBusAPIKey = envRedBusKey
-	}
-	if envRapidAPIKey := os.Getenv("RAPIDAPI_KEY"); envRapidAPIKey != "" {
-		config.RapidAPIKey = envRapidAPIKey
\ No newline at end of file
+	start := time.Now().AddDate(0, 0, 1)
+	if startStr := q.Get("start"); startStr != "" {
+		var err error
+		start, err = time.Parse("2006-01-02", startStr)
+		if err != nil {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "Invalid start format. Use YYYY-MM-DD",
+			})
+			return
+		}
+	}
+
+	days := DefaultCalendarDays
+	if daysStr := q.Get("days"); daysStr != "" {
+		var err error
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || days < 1 || days > MaxCalendarDays {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: fmt.Sprintf("days must be between 1 and %d", MaxCalendarDays),
+			})
+			return
+		}
+	}
+
+	passengers, err := strconv.Atoi(q.Get("passengers"))
+	if err != nil || passengers < 1 {
+		passengers = 1
+	}
+
+	filters, err := ParseFilterParams(q)
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid filters: %v", err),
+		})
+		return
+	}
+
+	searchReq := SearchRequest{
+		FromCity:      fromCity,
+		ToCity:        toCity,
+		Date:          start,
+		Passengers:    passengers,
+		SearchFilters: filters,
+	}
+	if err := canonicalizeCities(&searchReq); err != nil {
+		sendCityError(w, err)
+		return
+	}
+
+	calendar, err := fareCalendar.Days(r.Context(), realPlatformManager.Load(), searchReq, days)
+	if errors.Is(err, ErrNoMatchingPlatforms) {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Fare calendar failed: %v", err),
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Fares for %d days from %s", days, start.Format("2006-01-02")),
+		Data: map[string]interface{}{
+			"from":          searchReq.FromCity,
+			"to":            searchReq.ToCity,
+			"start":         start.Format("2006-01-02"),
+			"days":          days,
+			"cheapest_date": cheapestDay(calendar),
+			"calendar":      calendar,
+		},
+	})
+}
+
+// seatLayoutHandler returns the seat map of one route from the provider
+// that listed it. Providers without seat maps answer 501.
+func seatLayoutHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	providerID := r.PathValue("platform")
+	routeID := r.PathValue("id")
+
+	layout, err := realPlatformManager.Load().SeatLayout(r.Context(), providerID, routeID)
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, ErrProviderNotFound), errors.Is(err, ErrRouteNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrCapabilityNotSupported):
+			status = http.StatusNotImplemented
+		case errors.Is(err, ErrRateLimited):
+			status = http.StatusTooManyRequests
+		case errors.Is(err, ErrCircuitOpen):
+			status = http.StatusServiceUnavailable
+		}
+		sendJSON(w, status, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Seat layout for %s route %s: %v", providerID, routeID, err),
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d of %d seats available", layout.AvailableSeats, layout.TotalSeats),
+		Data:    layout,
+	})
+}
+
+// createBookingHandler holds seats on a route. An Idempotency-Key header
+// makes retries safe: the same request with the same key returns the
+// original booking.
+func createBookingHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var req BookingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON request body",
+		})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid booking: %v", err),
+		})
+		return
+	}
+
+	booking, replayed, err := bookings.Hold(r.Context(), realPlatformManager.Load(), req, r.Header.Get("Idempotency-Key"))
+	if err != nil {
+		sendJSON(w, bookingErrorStatus(err), Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Could not hold seats on %s route %s: %v", req.Provider, req.RouteID, err),
+		})
+		return
+	}
+
+	status := http.StatusCreated
+	if replayed {
+		status = http.StatusOK
+	}
+	sendJSON(w, status, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Seats held until %s", booking.HoldExpiresAt.Format(time.RFC3339)),
+		Data:    booking,
+	})
+}
+
+func getBookingHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	booking, ok := bookings.Get(r.PathValue("id"))
+	if !ok {
+		sendJSON(w, http.StatusNotFound, Response{
+			Status:  "error",
+			Message: "Booking not found",
+		})
+		return
+	}
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Booking is %s", booking.State),
+		Data:    booking,
+	})
+}
+
+// confirmBookingHandler turns a held booking into a ticket
+func confirmBookingHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	booking, err := bookings.Confirm(r.Context(), realPlatformManager.Load(), r.PathValue("id"))
+	if err != nil {
+		sendJSON(w, bookingErrorStatus(err), Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Could not confirm booking: %v", err),
+		})
+		return
+	}
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Booking confirmed, PNR %s", booking.PNR),
+		Data:    booking,
+	})
+}
+
+// cancelBookingHandler releases a hold or cancels a confirmed booking
+func cancelBookingHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	booking, err := bookings.Cancel(r.Context(), realPlatformManager.Load(), r.PathValue("id"))
+	if err != nil {
+		sendJSON(w, bookingErrorStatus(err), Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Could not cancel booking: %v", err),
+		})
+		return
+	}
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Booking cancelled",
+		Data:    booking,
+	})
+}
+
+// bookingErrorStatus maps booking errors to HTTP statuses
+func bookingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrBookingNotFound), errors.Is(err, ErrProviderNotFound), errors.Is(err, ErrRouteNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCapabilityNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrSeatUnavailable), errors.Is(err, ErrBookingState), errors.Is(err, ErrBookingBusy),
+		errors.Is(err, ErrIdempotencyConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrHoldExpired):
+		return http.StatusGone
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// searchHistoryHandler lists past searches, newest first
+// (?limit=&from=&to=&since=)
+func searchHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	q := r.URL.Query()
+	limit := 50
+	if value := q.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "limit must be a positive number",
+			})
+			return
+		}
+		limit = n
+	}
+	var since time.Time
+	if value := q.Get("since"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "since must be an RFC 3339 time",
+			})
+			return
+		}
+		since = t
+	}
+
+	records, err := store.Searches(q.Get("from"), q.Get("to"), since, limit)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Could not read search history: %v", err),
+		})
+		return
+	}
+
+	// Newest first
+	history := make([]SearchRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0; i-- {
+		history = append(history, records[i])
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d searches", len(history)),
+		Data:    history,
+	})
+}
+
+// parseFareQuery reads the parameters shared by the fare history endpoints
+// (?from=&to=&date=&operator=&bus_type=&platform=&lookback_days=)
+func parseFareQuery(q url.Values) (FareQuery, error) {
+	fq := FareQuery{
+		FromCity:      q.Get("from"),
+		ToCity:        q.Get("to"),
+		DepartureDate: q.Get("date"),
+		Operator:      q.Get("operator"),
+		BusType:       q.Get("bus_type"),
+		Platform:      q.Get("platform"),
+		Since:         time.Now().Add(-DefaultFareLookback),
+	}
+	if fq.FromCity == "" || fq.ToCity == "" {
+		return fq, fmt.Errorf("from and to parameters are required")
+	}
+	var err error
+	if fq.FromCity, err = cityCatalogue.Canonical(fq.FromCity); err != nil {
+		return fq, err
+	}
+	if fq.ToCity, err = cityCatalogue.Canonical(fq.ToCity); err != nil {
+		return fq, err
+	}
+	if fq.DepartureDate != "" {
+		if _, err := time.Parse("2006-01-02", fq.DepartureDate); err != nil {
+			return fq, fmt.Errorf("invalid date format. Use YYYY-MM-DD")
+		}
+	}
+	if value := q.Get("lookback_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 {
+			return fq, fmt.Errorf("lookback_days must be a positive number")
+		}
+		fq.Since = time.Now().AddDate(0, 0, -days)
+	}
+	return fq, nil
+}
+
+// priceHistoryHandler shows how each departure's fare moved over the
+// searches that saw it
+func priceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	fq, err := parseFareQuery(r.URL.Query())
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	observations, err := fareHistory(store, fq)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Could not read fare history: %v", err),
+		})
+		return
+	}
+
+	series := FareSeriesOf(observations)
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d fares seen for %d departures from %s to %s", len(observations), len(series), fq.FromCity, fq.ToCity),
+		Data: map[string]interface{}{
+			"from":         fq.FromCity,
+			"to":           fq.ToCity,
+			"observations": len(observations),
+			"series":       series,
+		},
+	})
+}
+
+// priceTrendsHandler shows how fares on a route move as departure
+// approaches. With a date, it also hints whether to buy now or wait.
+func priceTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	fq, err := parseFareQuery(r.URL.Query())
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// The trend is learned from every departure date, not just the one
+	// being planned. Fares with no exchange rate to the base currency are
+	// left out rather than mixed in.
+	date := fq.DepartureDate
+	fq.DepartureDate = ""
+	fq.Currency = currencies.Base()
+	observations, err := fareHistory(store, fq)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Could not read fare history: %v", err),
+		})
+		return
+	}
+
+	trend := FareTrend(observations)
+	data := map[string]interface{}{
+		"from":         fq.FromCity,
+		"to":           fq.ToCity,
+		"currency":     fq.Currency,
+		"observations": len(observations),
+		"trend":        trend,
+	}
+	message := fmt.Sprintf("Fare trend from %d fares", len(observations))
+
+	if date != "" {
+		departure, _ := time.Parse("2006-01-02", date)
+		fromLoc := cityCatalogue.Location(fq.FromCity)
+		daysLeft := daysBefore(time.Now(), localMidnight(departure, fromLoc), fromLoc)
+		if daysLeft < 0 {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "date is in the past",
+			})
+			return
+		}
+		hint := BuyOrWait(trend, daysLeft)
+		data["date"] = date
+		data["hint"] = hint
+		message = fmt.Sprintf("%s: %s", message, hint.Reason)
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: message,
+		Data:    data,
+	})
+}
+
+// alertsHandler creates an alert on POST and lists alerts on GET
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method == "GET" {
+		list := alertWatcher.List()
+		sendJSON(w, http.StatusOK, Response{
+			Status:  "success",
+			Message: fmt.Sprintf("%d alerts", len(list)),
+			Data:    list,
+		})
+		return
+	}
+
+	if r.Method != "POST" {
+		sendJSON(w, http.StatusMethodNotAllowed, Response{
+			Status:  "error",
+			Message: "Only GET and POST methods are allowed",
+		})
+		return
+	}
+
+	var rule AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "Invalid JSON request body",
+		})
+		return
+	}
+
+	if rule.Platform != "" {
+		if _, ok := realPlatformManager.Load().ProviderByID(rule.Platform); !ok {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: fmt.Sprintf("Invalid alert: unknown platform %q", rule.Platform),
+			})
+			return
+		}
+	}
+
+	created, err := alertWatcher.Add(rule)
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid alert: %v", err),
+		})
+		return
+	}
+	message := fmt.Sprintf("Watching %s to %s on %s", created.Search.FromCity, created.Search.ToCity, created.Search.Date.Format("2006-01-02"))
+	if created.watchesRoute() {
+		message = fmt.Sprintf("Watching %s route %s for %d free seats", created.Platform, created.RouteID, created.MinSeats)
+	}
+	sendJSON(w, http.StatusCreated, Response{
+		Status:  "success",
+		Message: message,
+		Data:    created,
+	})
+}
+
+// alertHandler shows an alert on GET and removes it on DELETE
+func alertHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	id := r.PathValue("id")
+	switch r.Method {
+	case "OPTIONS":
+		w.WriteHeader(http.StatusOK)
+	case "GET":
+		rule, ok := alertWatcher.Get(id)
+		if !ok {
+			sendJSON(w, http.StatusNotFound, Response{Status: "error", Message: "Alert not found"})
+			return
+		}
+		sendJSON(w, http.StatusOK, Response{Status: "success", Message: fmt.Sprintf("Alert is %s", rule.Status), Data: rule})
+	case "DELETE":
+		if err := alertWatcher.Delete(id); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrAlertNotFound) {
+				status = http.StatusNotFound
+			}
+			sendJSON(w, status, Response{Status: "error", Message: fmt.Sprintf("Could not delete alert: %v", err)})
+			return
+		}
+		sendJSON(w, http.StatusOK, Response{Status: "success", Message: "Alert deleted"})
+	default:
+		sendJSON(w, http.StatusMethodNotAllowed, Response{
+			Status:  "error",
+			Message: "Only GET and DELETE methods are allowed",
+		})
+	}
+}
+
+// checkAlertHandler runs an alert's search now instead of waiting for the
+// watcher
+func checkAlertHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	rule, err := alertWatcher.Check(r.Context(), realPlatformManager.Load(), r.PathValue("id"))
+	if err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, ErrAlertNotFound) {
+			status = http.StatusNotFound
+		}
+		sendJSON(w, status, Response{Status: "error", Message: fmt.Sprintf("Could not check alert: %v", err)})
+		return
+	}
+
+	message := "No matching fares"
+	switch {
+	case rule.watchesRoute() && rule.LastSeats != nil:
+		message = fmt.Sprintf("%d seats free, alerting at %d", *rule.LastSeats, rule.MinSeats)
+	case rule.Matched:
+		message = fmt.Sprintf("Matched at %s %.2f", currencyOr(rule.NotifiedCurrency, currencies.Base()), rule.NotifiedPrice)
+	}
+	if rule.LastError != "" {
+		message = fmt.Sprintf("%s (%s)", message, rule.LastError)
+	}
+	sendJSON(w, http.StatusOK, Response{Status: "success", Message: message, Data: rule})
+}
+
+// currenciesHandler lists the base currency and exchange rates
+func currenciesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	rates := currencies.Rates()
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d currencies, prices compared in %s", len(rates.Rates), currencies.Base()),
+		Data: map[string]interface{}{
+			"base_currency": currencies.Base(),
+			"rates":         rates,
+			"source":        currencies.Source(),
+		},
+	})
+}
+
+// refreshRatesHandler fetches exchange rates now instead of waiting for the
+// next scheduled refresh
+func refreshRatesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if err := currencies.Refresh(r.Context()); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrNoRatesSource) {
+			status = http.StatusConflict
+		}
+		sendJSON(w, status, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Could not refresh rates: %v", err),
+		})
+		return
+	}
+	rates := currencies.Rates()
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Rates updated at %s", rates.UpdatedAt.Format(time.RFC3339)),
+		Data:    rates,
+	})
+}
+
+// API status handler reporting live health for every registered provider
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	providers := realPlatformManager.Load().ProviderStatuses()
+
+	status := map[string]interface{}{
+		"providers":       providers,
+		"total_platforms": len(providers),
+		"server_time":     time.Now().UTC(),
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "API status retrieved",
+		Data:    status,
+	})
+}
+
+// Configuration endpoint to update API keys (for development)
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if r.Method == "GET" {
+		// Return current config (without sensitive data)
+		providers := []map[string]interface{}{}
+		for _, cfg := range config.ProviderConfigs() {
+			providers = append(providers, map[string]interface{}{
+				"id":             cfg.ID,
+				"enabled":        cfg.Enabled,
+				"weight":         cfg.EffectiveWeight(),
+				"key_configured": cfg.APIKey != "",
+			})
+		}
+
+		safeConfig := map[string]interface{}{
+			"redbus_configured":   config.RedBusAPIKey != "",
+			"rapidapi_configured": config.RapidAPIKey != "",
+			"server_port":         config.ServerPort,
+			"providers":           providers,
+			"available_providers": RegisteredProviderIDs(),
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Status:  "success",
+			Message: "Configuration retrieved",
+			Data:    safeConfig,
+		})
+		return
+	}
+
+	if r.Method == "POST" {
+		var newConfig struct {
+			RedBusAPIKey string `json:"redbus_api_key"`
+			RapidAPIKey  string `json:"rapidapi_key"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "Invalid configuration JSON",
+			})
+			return
+		}
+
+		// Update configuration
+		if newConfig.RedBusAPIKey != "" {
+			config.RedBusAPIKey = newConfig.RedBusAPIKey
+		}
+		if newConfig.RapidAPIKey != "" {
+			config.RapidAPIKey = newConfig.RapidAPIKey
+		}
+
+		// Rebuild the providers whose keys changed
+		realPlatformManager.Store(realPlatformManager.Load().Reconfigure(config.ProviderConfigs()))
+
+		// Keep the new keys across restarts, encrypted with SecretKeyEnv
+		if os.Getenv(SecretKeyEnv) == "" {
+			fmt.Printf("Warning: %s is not set, API keys will not be saved\n", SecretKeyEnv)
+		}
+		if err := store.SaveConfig(config); err != nil {
+			fmt.Printf("Warning: could not save configuration: %v\n", err)
+		}
+
+		sendJSON(w, http.StatusOK, Response{
+			Status:  "success",
+			Message: "Configuration updated successfully",
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusMethodNotAllowed, Response{
+		Status:  "error",
+		Message: "Only GET and POST methods are allowed",
+	})
+}
+
+// Test API connectivity
+func testAPIHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	apiName := r.URL.Query().Get("api")
+	if apiName == "" {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("api parameter is required (%s)", strings.Join(RegisteredProviderIDs(), ", ")),
+		})
+		return
+	}
+
+	testReq := SearchRequest{
+		FromCity:   "Mumbai",
+		ToCity:     "Pune",
+		Date:       time.Now().AddDate(0, 0, 1),
+		Passengers: 1,
+	}
+
+	// Build a fresh instance from the provider's config so the test doesn't
+	// touch the live manager's cache, breaker or health stats
+	configMu.Lock()
+	providerConfig := config.ProviderConfig(apiName)
+	configMu.Unlock()
+	service, err := NewProvider(providerConfig)
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Cannot test %s: %v", apiName, err),
+		})
+		return
+	}
+
+	routes, err := service.SearchRoutes(r.Context(), testReq)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("API test failed: %v", err),
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%s API test successful", apiName),
+		Data: map[string]interface{}{
+			"routes_found": len(routes),
+			"test_query":   testReq,
+		},
+	})
+}
+
+// homeHandler handles the root endpoint
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	response := Response{
+		Status:  "success",
+		Message: "Welcome to Bus Booking Aggregator API",
+		Data:    map[string]string{"version": "1.0.0"},
+	}
+	sendJSON(w, http.StatusOK, response)
+}
+
+// healthHandler handles health check
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	response := Response{
+		Status:  "success",
+		Message: "Service is healthy",
+	}
+	sendJSON(w, http.StatusOK, response)
+}
+
+// citiesHandler returns available cities
+func citiesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	cities := []map[string]interface{}{}
+	for _, city := range cityCatalogue.Cities() {
+		cities = append(cities, map[string]interface{}{
+			"id":       city.ID,
+			"name":     city.City,
+			"state":    city.State,
+			"timezone": city.Timezone,
+			"aliases":  city.Aliases,
+		})
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Cities retrieved successfully",
+		Data:    cities,
+	})
+}
+
+// citySuggestHandler autocompletes city names for the search form
+func citySuggestHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: "q parameter is required",
+		})
+		return
+	}
+
+	limit := 8
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if n, err := strconv.Atoi(limitStr); err == nil && n > 0 && n <= 50 {
+			limit = n
+		}
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "City suggestions retrieved",
+		Data:    cityCatalogue.Suggest(query, limit),
+	})
+}
+
+// cityMappingsHandler lists, imports and refreshes per-provider city IDs.
+//
+//	GET  /city-mappings?provider=redbus
+//	POST /city-mappings            CSV (text/csv) or JSON body
+//	POST /city-mappings?refresh=redbus  pull IDs from the provider
+func cityMappingsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if r.Method == "GET" {
+		sendJSON(w, http.StatusOK, Response{
+			Status:  "success",
+			Message: "City mappings retrieved",
+			Data:    cityMappings.Mappings(r.URL.Query().Get("provider")),
+		})
+		return
+	}
+
+	if r.Method != "POST" {
+		sendJSON(w, http.StatusMethodNotAllowed, Response{
+			Status:  "error",
+			Message: "Only GET and POST methods are allowed",
+		})
+		return
+	}
+
+	var imported int
+	var unknown []string
+	var err error
+
+	if providerID := r.URL.Query().Get("refresh"); providerID != "" {
+		provider, ok := realPlatformManager.Load().ProviderByID(providerID)
+		lister, canList := provider.(CityLister)
+		if !ok || !canList {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: fmt.Sprintf("Provider %s is not active or has no cities endpoint", providerID),
+			})
+			return
+		}
+		imported, unknown, err = cityMappings.Refresh(r.Context(), providerID, lister)
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		imported, unknown, err = cityMappings.ImportCSV(r.Body)
+	} else {
+		imported, unknown, err = cityMappings.ImportJSON(r.Body)
+	}
+
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, Response{
+			Status:  "error",
+			Message: fmt.Sprintf("City mapping import failed: %v", err),
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("Imported %d city mappings", imported),
+		Data: map[string]interface{}{
+			"imported":       imported,
+			"unknown_cities": unknown,
+		},
+	})
+}
+
+func main() {
+	// Initialize random seed
+	rand.Seed(time.Now().UnixNano())
+
+	// Load configuration
+	config = LoadConfig()
+
+	// Open the database and apply API keys saved through POST /config
+	dbPath := os.Getenv("BUS_SCANNER_DB")
+	if dbPath == "" {
+		dbPath = DefaultDatabaseFile
+	}
+	opened, err := OpenStore(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database %s: %v", dbPath, err)
+	}
+	store = opened
+	if saved, err := store.LoadConfig(); err != nil {
+		fmt.Printf("Warning: could not load saved configuration: %v\n", err)
+	} else if saved != nil {
+		if saved.RedBusAPIKey != "" {
+			config.RedBusAPIKey = saved.RedBusAPIKey
+		}
+		if saved.RapidAPIKey != "" {
+			config.RapidAPIKey = saved.RapidAPIKey
+		}
+	}
+
+	// Check for environment variables
+	if envRedBusKey := os.Getenv("REDBUS_API_KEY"); envRedBusKey != "" {
+		config.RedBusAPIKey = envRedBusKey
+	}
+	if envRapidAPIKey := os.Getenv("RAPIDAPI_KEY"); envRapidAPIKey != "" {
+		config.RapidAPIKey = envRapidAPIKey
+	}
+	if envPort := os.Getenv("SERVER_PORT"); envPort != "" {
+		config.ServerPort = envPort
+	}
+
+	// Replace the built-in city list if a data file is given
+	if citiesFile := os.Getenv("BUS_SCANNER_CITIES"); citiesFile != "" {
+		catalogue, err := LoadLocationCatalogue(citiesFile)
+		if err != nil {
+			log.Fatalf("Failed to load cities from %s: %v", citiesFile, err)
+		}
+		cityCatalogue = catalogue
+		cityMappings = NewCityMappingStore(catalogue)
+	}
+
+	// Extra provider city IDs on top of those in the city data
+	if mappingsFile := os.Getenv("BUS_SCANNER_CITY_MAPPINGS"); mappingsFile != "" {
+		imported, unknown, err := cityMappings.ImportFile(mappingsFile)
+		if err != nil {
+			log.Fatalf("Failed to import city mappings from %s: %v", mappingsFile, err)
+		}
+		fmt.Printf("🗺️  Imported %d city mappings (%d unknown cities)\n", imported, len(unknown))
+	}
+
+	// Exchange rates, optionally from a file kept fresh from a rates source
+	if ratesFile := os.Getenv("BUS_SCANNER_RATES"); ratesFile != "" {
+		config.Currency.RatesFile = ratesFile
+	}
+	if os.Getenv("BUS_SCANNER_SINKS") != "" && config.Currency.RatesURL == "" {
+		config.Currency.RatesURL = "http://localhost" + config.ServerPort + "/sink/rates"
+	}
+	if err := currencies.Configure(config.Currency); err != nil {
+		log.Fatalf("Failed to set up currencies: %v", err)
+	}
+
+	// Initialize platform manager
+	realPlatformManager.Store(NewRealPlatformManager(config.ProviderConfigs()))
+
+	// History, bookings and runtime config live in the database
+	go runRetention(context.Background(), store, config.Retention, DefaultRetentionInterval)
+	go sampleHealth(context.Background(), store, DefaultHealthInterval)
+	if err := bookings.Restore(store); err != nil {
+		log.Fatalf("Failed to load bookings: %v", err)
+	}
+
+	// Local stand-ins for a webhook receiver and a mail server
+	if os.Getenv("BUS_SCANNER_SINKS") != "" {
+		if err := notificationSink.ListenSMTP(DefaultSMTPSinkAddr); err != nil {
+			log.Fatalf("Failed to start SMTP sink: %v", err)
+		}
+		if config.Alerts.SMTP.Addr == "" {
+			config.Alerts.SMTP = SMTPConfig{Addr: DefaultSMTPSinkAddr, From: "alerts@bus-scanner.local"}
+		}
+		config.Alerts.SinkURL = "http://localhost" + config.ServerPort + "/sink"
+		fmt.Printf("📥 Local stand-ins: SMTP on %s, webhooks at /sink, exchange rates at /sink/rates\n", DefaultSMTPSinkAddr)
+	}
+
+	if config.Currency.RatesURL != "" {
+		go currencies.Run(context.Background(), time.Duration(config.Currency.Refresh))
+	}
+
+	// Price and seat alerts, checked in the background
+	alertWatcher.Configure(config.Alerts)
+	if err := alertWatcher.Restore(store); err != nil {
+		log.Fatalf("Failed to load alerts: %v", err)
+	}
+	go alertWatcher.Run(context.Background())
+
+	// Create HTTP multiplexer
+	mux := http.NewServeMux()
+
+	// Register routes
+	mux.HandleFunc("/", homeHandler)
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/search", enhancedSearchHandler)
+	mux.HandleFunc("/search/{id}", searchSessionHandler)
+	mux.HandleFunc("/routes", enhancedRoutesHandler)
+	mux.HandleFunc("/routes/{platform}/{id}/seats", seatLayoutHandler)
+	mux.HandleFunc("POST /bookings", createBookingHandler)
+	mux.HandleFunc("OPTIONS /bookings", createBookingHandler)
+	mux.HandleFunc("GET /bookings/{id}", getBookingHandler)
+	mux.HandleFunc("POST /bookings/{id}/confirm", confirmBookingHandler)
+	mux.HandleFunc("OPTIONS /bookings/{id}/confirm", corsPreflight)
+	mux.HandleFunc("POST /bookings/{id}/cancel", cancelBookingHandler)
+	mux.HandleFunc("OPTIONS /bookings/{id}/cancel", corsPreflight)
+	mux.HandleFunc("/search-history", searchHistoryHandler)
+	mux.HandleFunc("/alerts", alertsHandler)
+	mux.HandleFunc("/alerts/{id}", alertHandler)
+	mux.HandleFunc("POST /alerts/{id}/check", checkAlertHandler)
+	mux.HandleFunc("OPTIONS /alerts/{id}/check", corsPreflight)
+	if os.Getenv("BUS_SCANNER_SINKS") != "" {
+		mux.Handle("/sink", notificationSink)
+		mux.HandleFunc("/sink/rates", ratesStandIn)
+	}
+	mux.HandleFunc("GET /currencies", currenciesHandler)
+	mux.HandleFunc("POST /currencies/refresh", refreshRatesHandler)
+	mux.HandleFunc("OPTIONS /currencies/refresh", corsPreflight)
+	mux.HandleFunc("/fare-calendar", fareCalendarHandler)
+	mux.HandleFunc("/price-history", priceHistoryHandler)
+	mux.HandleFunc("/price-trends", priceTrendsHandler)
+	mux.HandleFunc("/cities", citiesHandler)
+	mux.HandleFunc("/cities/suggest", citySuggestHandler)
+	mux.HandleFunc("/city-mappings", cityMappingsHandler)
+	mux.HandleFunc("/api-status", apiStatusHandler)
+	mux.HandleFunc("/config", configHandler)
+	mux.HandleFunc("/test-api", testAPIHandler)
+
+	port := config.ServerPort
+
+	fmt.Printf("🚌 Bus Booking Aggregator API\n")
+	fmt.Printf("📍 Server: http://localhost%s\n", port)
+	fmt.Printf("🔌 Platforms: %d\n", len(realPlatformManager.Load().platforms))
+	fmt.Printf("📋 Endpoints:\n")
+	fmt.Printf("   GET  /              - API info\n")
+	fmt.Printf("   GET  /health        - Health check\n")
+	fmt.Printf("   GET  /cities        - Available cities\n")
+	fmt.Printf("   GET  /cities/suggest - City autocomplete (?q=)\n")
+	fmt.Printf("   GET  /city-mappings - Provider city IDs (POST to import or ?refresh=)\n")
+	fmt.Printf("   GET  /routes        - Search routes (query params)\n")
+	fmt.Printf("   POST /search        - Search routes (JSON body)\n")
+	fmt.Printf("   GET  /routes/{platform}/{id}/seats - Seat layout of a route\n")
+	fmt.Printf("   POST /bookings      - Hold seats (Idempotency-Key header)\n")
+	fmt.Printf("   GET  /bookings/{id} - Booking status\n")
+	fmt.Printf("   POST /bookings/{id}/confirm - Confirm a held booking\n")
+	fmt.Printf("   POST /bookings/{id}/cancel  - Cancel a booking\n")
+	fmt.Printf("   POST /alerts        - Alert on a fare below a price or free seats, or on one bus (route_id, platform)\n")
+	fmt.Printf("   GET  /alerts/{id}   - Alert status (DELETE to remove, POST .../check to run now)\n")
+	fmt.Printf("   GET  /currencies    - Base currency and exchange rates (POST /currencies/refresh to update)\n")
+	fmt.Printf("   GET  /search-history - Past searches (?limit=&from=&to=&since=)\n")
+	fmt.Printf("   GET  /fare-calendar - Fares per day (?from=&to=&start=&days=)\n")
+	fmt.Printf("   GET  /price-history - Fares seen per departure (?from=&to=&date=)\n")
+	fmt.Printf("   GET  /price-trends  - Fares by days before departure, buy/wait hint (?from=&to=&date=)\n")
+	fmt.Printf("   GET  /search/{id}   - Page, re-sort or re-filter a search (?page=&page_size=&sort=)\n")
+	fmt.Printf("   GET  /api-status    - Provider status\n")
+	fmt.Printf("   GET  /config        - Current configuration\n")
+	fmt.Printf("   POST /config        - Update API keys\n")
+	fmt.Printf("   GET  /test-api      - Test a single provider (?api=%s)\n", strings.Join(RegisteredProviderIDs(), "|"))
+
+	fmt.Printf("\n🚀 Starting server...\n")
+	log.Fatal(http.ListenAndServe(port, mux))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultMatchWindow is how far apart two departures may be and still be
+// treated as the same physical bus
+const DefaultMatchWindow = 15 * time.Minute
+
+// Trip is a single physical bus departure, offered by one or more platforms
+type Trip struct {
+	ID            string      `json:"id"`
+	From          Location    `json:"from"`
+	To            Location    `json:"to"`
+	Operator      BusOperator `json:"operator"`
+	BusType       BusType     `json:"bus_type"`
+	DepartureTime time.Time   `json:"departure_time"`
+	ArrivalTime   time.Time   `json:"arrival_time"`
+	Offers        []Price     `json:"offers"` // cheapest first
+	Routes        []Route     `json:"-"`
+
+	Duration        time.Duration `json:"-"`
+	DurationMinutes int           `json:"duration_minutes"`
+	DurationText    string        `json:"duration"`
+}
+
+// CheapestOffer returns the lowest priced offer for the trip
+func (t Trip) CheapestOffer() Price {
+	if len(t.Offers) == 0 {
+		return Price{}
+	}
+	return t.Offers[0]
+}
+
+// RouteMatcher groups routes from different platforms that describe the
+// same bus: same operator, origin, destination and bus type, departing
+// within Window of each other.
+type RouteMatcher struct {
+	Window time.Duration
+}
+
+func NewRouteMatcher() *RouteMatcher {
+	return &RouteMatcher{Window: DefaultMatchWindow}
+}
+
+// tripGroup is a trip under construction
+type tripGroup struct {
+	anchor    time.Time
+	platforms map[string]bool // by provider
+	routes    []Route
+}
+
+// Group clusters routes into trips. A trip never holds two offers from the
+// same provider, since one provider listing two buses close together means
+// there really are two buses. Trips are returned cheapest first.
+func (m *RouteMatcher) Group(routes []Route) []Trip {
+	byKey := map[string][]Route{}
+	var keys []string
+	for _, route := range routes {
+		key := matchKey(route)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], route)
+	}
+
+	var trips []Trip
+	for _, key := range keys {
+		candidates := byKey[key]
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].DepartureTime.Before(candidates[j].DepartureTime)
+		})
+
+		var groups []*tripGroup
+		for _, route := range candidates {
+			platform := offerSource(route)
+			// Join the closest trip that doesn't already have this provider
+			var match *tripGroup
+			best := m.Window
+			for _, g := range groups {
+				gap := absDuration(route.DepartureTime.Sub(g.anchor))
+				if !g.platforms[platform] && gap <= best {
+					match, best = g, gap
+				}
+			}
+			if match == nil {
+				match = &tripGroup{anchor: route.DepartureTime, platforms: map[string]bool{}}
+				groups = append(groups, match)
+			}
+			match.platforms[platform] = true
+			match.routes = append(match.routes, route)
+		}
+
+		for _, g := range groups {
+			trips = append(trips, newTrip(key, g))
+		}
+	}
+
+	sort.SliceStable(trips, func(i, j int) bool {
+		return trips[i].CheapestOffer().Comparable() < trips[j].CheapestOffer().Comparable()
+	})
+
+	return trips
+}
+
+// newTrip builds a trip from a finished group, using the earliest listed
+// route for the shared details
+func newTrip(key string, g *tripGroup) Trip {
+	first := g.routes[0]
+
+	offers := make([]Price, 0, len(g.routes))
+	for _, route := range g.routes {
+		offer := route.Price
+		offer.RouteID = route.ID
+		offer.BookingURL = route.BookingURL
+		offers = append(offers, offer)
+	}
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offers[i].Comparable() < offers[j].Comparable()
+	})
+
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d", key, g.anchor.Unix())))
+
+	return Trip{
+		ID:            "trip_" + hex.EncodeToString(sum[:6]),
+		From:          first.From,
+		To:            first.To,
+		Operator:      first.Operator,
+		BusType:       first.BusType,
+		DepartureTime: first.DepartureTime,
+		ArrivalTime:   first.ArrivalTime,
+		Offers:        offers,
+		Routes:        g.routes,
+
+		Duration:        first.Duration,
+		DurationMinutes: first.DurationMinutes,
+		DurationText:    first.DurationText,
+	}
+}
+
+// offerSource identifies the provider that listed a route. Price.Platform
+// is only a label, shared by e.g. a mock and the real API of one platform.
+func offerSource(route Route) string {
+	if route.Provider != "" {
+		return route.Provider
+	}
+	return route.Price.Platform
+}
+
+// matchKey is the part of a route that must be identical for two listings
+// to be the same bus
+func matchKey(route Route) string {
+	return strings.Join([]string{
+		normalizeName(route.Operator.Name),
+		normalizeName(locationKey(route.From)),
+		normalizeName(locationKey(route.To)),
+		normalizeName(route.BusType.Name),
+	}, "|")
+}
+
+func locationKey(loc Location) string {
+	if loc.City != "" {
+		return loc.City
+	}
+	return loc.ID
+}
+
+// normalizeName lowercases a name and sorts its words, so "AC Sleeper",
+// "Sleeper (AC)" and "ac-sleeper" all compare equal
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listing is a Mumbai to Pune route by operator on busType, departing
+// minutes after a fixed time and listed by provider
+func listing(id, provider, operator, busType string, minutes int, amount float64) Route {
+	departure := time.Date(2026, 11, 20, 21, 0, 0, 0, time.UTC).Add(time.Duration(minutes) * time.Minute)
+	route := Route{
+		ID:       id,
+		From:     Location{ID: "mumbai", City: "Mumbai"},
+		To:       Location{ID: "pune", City: "Pune"},
+		Operator: BusOperator{Name: operator},
+		BusType:  BusType{Name: busType},
+		Price:    Price{Amount: amount, Currency: "INR", Platform: provider},
+		Provider: provider,
+	}
+	route.SetTimes(departure, departure.Add(4*time.Hour))
+	return route
+}
+
+// tripGroups describes trips by their route IDs, e.g. "a+b", sorted so
+// the comparison ignores trip order
+func tripGroups(trips []Trip) []string {
+	groups := make([]string, len(trips))
+	for i, trip := range trips {
+		ids := make([]string, len(trip.Routes))
+		for j, route := range trip.Routes {
+			ids[j] = route.ID
+		}
+		slices.Sort(ids)
+		groups[i] = strings.Join(ids, "+")
+	}
+	slices.Sort(groups)
+	return groups
+}
+
+func TestRouteMatcherGroup(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []Route
+		want   []string
+	}{
+		{
+			name: "same bus on two providers",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "abhibus", "VRL Travels", "AC Sleeper", 5, 850),
+			},
+			want: []string{"a+b"},
+		},
+		{
+			name: "window edge is inclusive",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "abhibus", "VRL Travels", "AC Sleeper", 15, 850),
+			},
+			want: []string{"a+b"},
+		},
+		{
+			name: "just outside the window",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "abhibus", "VRL Travels", "AC Sleeper", 16, 850),
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "window is measured from the first departure",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "abhibus", "VRL Travels", "AC Sleeper", 10, 850),
+				listing("c", "paytm", "VRL Travels", "AC Sleeper", 20, 880),
+			},
+			want: []string{"a+b", "c"},
+		},
+		{
+			name: "one provider listing two buses",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "redbus", "VRL Travels", "AC Sleeper", 5, 900),
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "duplicate provider joins the closest open trip",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "redbus", "VRL Travels", "AC Sleeper", 4, 900),
+				listing("c", "abhibus", "VRL Travels", "AC Sleeper", 5, 850),
+			},
+			want: []string{"a", "b+c"},
+		},
+		{
+			name: "shared label from two providers",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				func() Route {
+					r := listing("b", "redbus_mock", "VRL Travels", "AC Sleeper", 2, 880)
+					r.Price.Platform = "redbus"
+					return r
+				}(),
+			},
+			want: []string{"a+b"},
+		},
+		{
+			name: "platform label stands in for a missing provider",
+			routes: []Route{
+				func() Route {
+					r := listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900)
+					r.Provider = ""
+					return r
+				}(),
+				func() Route {
+					r := listing("b", "redbus", "VRL Travels", "AC Sleeper", 3, 900)
+					r.Provider = ""
+					return r
+				}(),
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "different bus types",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "abhibus", "VRL Travels", "Non-AC Seater", 0, 500),
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "names compare after normalising",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "abhibus", "vrl-travels", "Sleeper (AC)", 3, 850),
+			},
+			want: []string{"a+b"},
+		},
+		{
+			name: "different operators",
+			routes: []Route{
+				listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+				listing("b", "abhibus", "SRS Travels", "AC Sleeper", 0, 850),
+			},
+			want: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trips := NewRouteMatcher().Group(tt.routes)
+			if got := tripGroups(trips); !slices.Equal(got, tt.want) {
+				t.Errorf("trips = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteMatcherOffers(t *testing.T) {
+	trips := NewRouteMatcher().Group([]Route{
+		listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+		listing("b", "abhibus", "VRL Travels", "AC Sleeper", 5, 850),
+		listing("c", "redbus", "Neeta Travels", "AC Seater", 60, 600),
+	})
+	if len(trips) != 2 {
+		t.Fatalf("got %d trips, want 2", len(trips))
+	}
+
+	// Trips come cheapest first, and so do their offers
+	if trips[0].Operator.Name != "Neeta Travels" {
+		t.Errorf("first trip is %s, want the cheaper Neeta Travels", trips[0].Operator.Name)
+	}
+	offers := trips[1].Offers
+	if len(offers) != 2 || offers[0].RouteID != "b" || offers[1].RouteID != "a" {
+		t.Fatalf("offers = %+v, want b then a", offers)
+	}
+	if trips[1].CheapestOffer().Amount != 850 {
+		t.Errorf("cheapest offer = %.2f, want 850", trips[1].CheapestOffer().Amount)
+	}
+
+	// The trip keeps the earliest listing's details, and a stable ID
+	if !trips[1].DepartureTime.Equal(listing("a", "", "", "", 0, 0).DepartureTime) {
+		t.Errorf("departure = %s, want the earliest listing's", trips[1].DepartureTime)
+	}
+	again := NewRouteMatcher().Group([]Route{
+		listing("b", "abhibus", "VRL Travels", "AC Sleeper", 5, 850),
+		listing("a", "redbus", "VRL Travels", "AC Sleeper", 0, 900),
+	})
+	if again[0].ID != trips[1].ID {
+		t.Errorf("trip ID changed with listing order: %s vs %s", again[0].ID, trips[1].ID)
+	}
+}
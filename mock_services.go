@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlatformService is implemented by every booking platform the aggregator
+// can search. Implementations must honour ctx cancellation and deadlines.
+type PlatformService interface {
+	SearchRoutes(ctx context.Context, req SearchRequest) ([]Route, error)
+	GetPlatformName() string
+}
+
+func init() {
+	RegisterProvider("redbus_mock", func(cfg ProviderConfig) (PlatformService, error) {
+		currency, err := mockCurrency(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &RedBusService{Name: nameOr(cfg.Name, "RedBus Mock"), HoldTTL: time.Duration(cfg.HoldTTL), Currency: currency}, nil
+	})
+	RegisterProvider("makemytrip_mock", func(cfg ProviderConfig) (PlatformService, error) {
+		currency, err := mockCurrency(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &MakeMyTripService{Name: nameOr(cfg.Name, "MakeMyTrip Mock"), HoldTTL: time.Duration(cfg.HoldTTL), Currency: currency}, nil
+	})
+	RegisterProvider("goibibo_mock", func(cfg ProviderConfig) (PlatformService, error) {
+		currency, err := mockCurrency(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &GoibiboService{Name: nameOr(cfg.Name, "Goibibo Mock"), HoldTTL: time.Duration(cfg.HoldTTL), Currency: currency}, nil
+	})
+}
+
+// mockCurrency is the currency a mock quotes in, which needs a rate since
+// mock fares start out in INR
+func mockCurrency(cfg ProviderConfig) (string, error) {
+	currency := currencyOr(cfg.Currency, "INR")
+	if !currencies.Supports(currency) {
+		return "", fmt.Errorf("currency %s: %w", currency, ErrUnknownCurrency)
+	}
+	return currency, nil
+}
+
+// mockPrice quotes a fare worked out in INR in the mock's currency
+func mockPrice(inr float64, currency, platform string) Price {
+	currency = currencyOr(currency, "INR")
+	amount, err := currencies.Convert(inr, "INR", currency)
+	if err != nil {
+		return Price{Amount: inr, Currency: "INR", Platform: platform}
+	}
+	return Price{Amount: amount, Currency: currency, Platform: platform}
+}
+
+func nameOr(name, fallback string) string {
+	if name != "" {
+		return name
+	}
+	return fallback
+}
+
+// RedBusService simulates RedBus API
+type RedBusService struct {
+	Name     string
+	HoldTTL  time.Duration
+	Currency string
+}
+
+func (r *RedBusService) GetPlatformName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return "RedBus"
+}
+
+func (r *RedBusService) SearchRoutes(ctx context.Context, req SearchRequest) ([]Route, error) {
+	// Simulate API delay
+	if err := sleepContext(ctx, time.Duration(rand.Intn(500)+200)*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	return mockListings(req, mockListing{
+		name:       r.GetPlatformName(),
+		platform:   "RedBus",
+		prefix:     "redbus_",
+		currency:   r.Currency,
+		markup:     1.0,
+		bookingURL: "https://redbus.in/book/route123",
+	}), nil
+}
+
+// MakeMyTripService simulates MakeMyTrip API
+type MakeMyTripService struct {
+	Name     string
+	HoldTTL  time.Duration
+	Currency string
+}
+
+func (m *MakeMyTripService) GetPlatformName() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return "MakeMyTrip"
+}
+
+func (m *MakeMyTripService) SearchRoutes(ctx context.Context, req SearchRequest) ([]Route, error) {
+	if err := sleepContext(ctx, time.Duration(rand.Intn(600)+300)*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	return mockListings(req, mockListing{
+		name:       m.GetPlatformName(),
+		platform:   "MakeMyTrip",
+		prefix:     "mmt_",
+		currency:   m.Currency,
+		markup:     0.95,
+		bookingURL: "https://makemytrip.com/bus/book/xyz",
+	}), nil
+}
+
+// GoibiboService simulates Goibibo API
+type GoibiboService struct {
+	Name     string
+	HoldTTL  time.Duration
+	Currency string
+}
+
+func (g *GoibiboService) GetPlatformName() string {
+	if g.Name != "" {
+		return g.Name
+	}
+	return "Goibibo"
+}
+
+func (g *GoibiboService) SearchRoutes(ctx context.Context, req SearchRequest) ([]Route, error) {
+	if err := sleepContext(ctx, time.Duration(rand.Intn(400)+250)*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	return mockListings(req, mockListing{
+		name:       g.GetPlatformName(),
+		platform:   "Goibibo",
+		prefix:     "goibibo_",
+		currency:   g.Currency,
+		markup:     1.05,
+		bookingURL: "https://goibibo.com/bus/booking/abc",
+	}), nil
+}
+
+// mockBus is one departure in the timetable the mock platforms share
+type mockBus struct {
+	operator  BusOperator
+	busType   BusType
+	departure time.Time
+	duration  time.Duration
+	fare      float64 // INR
+}
+
+// mockTimetable returns the buses running between two cities on a date.
+// It is derived from the cities and date, so every mock platform sees the
+// same buses and, like real platforms reselling the same operators, lists
+// many of the same departures.
+func mockTimetable(from, to Location, date time.Time) []mockBus {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%s", locationKey(from), locationKey(to), date.Format("2006-01-02"))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	operators := GetSampleOperators()
+	busTypes := GetSampleBusTypes()
+	duration := 7*time.Hour + time.Duration(rng.Intn(120))*time.Minute
+	start := localMidnight(date, from)
+
+	buses := make([]mockBus, 4+rng.Intn(3)) // 4-6 buses
+	for i := range buses {
+		// Roughly every three hours from 6AM
+		departure := start.Add(time.Duration(6*60+i*180+rng.Intn(4)*15) * time.Minute)
+		buses[i] = mockBus{
+			operator:  operators[rng.Intn(len(operators))],
+			busType:   busTypes[rng.Intn(len(busTypes))],
+			departure: departure,
+			duration:  duration + time.Duration(rng.Intn(3)*15)*time.Minute,
+			fare:      500 + float64(rng.Intn(20))*50,
+		}
+	}
+	return buses
+}
+
+// mockListing is how one mock platform sells the shared timetable
+type mockListing struct {
+	name       string // GetPlatformName, which seeds seat maps
+	platform   string // price label
+	prefix     string
+	currency   string
+	markup     float64 // applied to the timetable fare
+	bookingURL string
+}
+
+// mockListings returns the timetable buses a mock platform sells. Each
+// platform carries about two thirds of them, lists departures up to ten
+// minutes off the timetable and prices them with its own markup and a
+// little noise. Free seats are counted from the route's seat map, so a
+// sold-out listing has no seat left to book.
+func mockListings(req SearchRequest, l mockListing) []Route {
+	fromLoc := cityCatalogue.Location(req.FromCity)
+	toLoc := cityCatalogue.Location(req.ToCity)
+
+	routes := []Route{}
+	for i, bus := range mockTimetable(fromLoc, toLoc, req.Date) {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s|%d|%s", l.platform, i, bus.departure)
+		pick := h.Sum64()
+		if pick%3 == 0 && !(i == 0 && len(routes) == 0) {
+			continue
+		}
+
+		id := fmt.Sprintf("%s%08x", l.prefix, uint32(pick))
+		layout := simulatedSeatLayout(l.name+"/"+id, id, l.currency)
+		mockDesk.markTaken(l.name, id, layout)
+
+		fare := math.Round(bus.fare*l.markup*(0.95+rand.Float64()*0.1)*100) / 100
+		route := Route{
+			ID:             id,
+			From:           fromLoc,
+			To:             toLoc,
+			Operator:       bus.operator,
+			BusType:        bus.busType,
+			Price:          mockPrice(fare, l.currency, l.platform),
+			AvailableSeats: layout.AvailableSeats,
+			BookingURL:     l.bookingURL,
+		}
+		departure := bus.departure.Add(time.Duration(pick/3%11) * time.Minute)
+		route.SetTimes(departure, departure.Add(bus.duration))
+		route.BoardingPoints = mockStopPoints(fromLoc, route.DepartureTime, true)
+		route.DroppingPoints = mockStopPoints(toLoc, route.ArrivalTime, false)
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// localMidnight is the start of the search date in a location's time zone,
+// so mock departures land at sensible local hours
+func localMidnight(date time.Time, loc Location) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc.TimeZone())
+}
+
+// mockSeatLayout returns the simulated seat map for a route the mock
+// platform issued, i.e. one whose ID carries its prefix
+func mockSeatLayout(ctx context.Context, platform, prefix, currency, routeID string) (*SeatLayout, error) {
+	if !strings.HasPrefix(routeID, prefix) {
+		return nil, fmt.Errorf("%s route %s: %w", platform, routeID, ErrRouteNotFound)
+	}
+	if err := sleepContext(ctx, time.Duration(rand.Intn(200)+100)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	layout := simulatedSeatLayout(platform+"/"+routeID, routeID, currency)
+	mockDesk.markTaken(platform, routeID, layout)
+	return layout, nil
+}
+
+func (r *RedBusService) SeatLayout(ctx context.Context, routeID string) (*SeatLayout, error) {
+	return mockSeatLayout(ctx, r.GetPlatformName(), "redbus_", r.Currency, routeID)
+}
+
+func (m *MakeMyTripService) SeatLayout(ctx context.Context, routeID string) (*SeatLayout, error) {
+	return mockSeatLayout(ctx, m.GetPlatformName(), "mmt_", m.Currency, routeID)
+}
+
+func (g *GoibiboService) SeatLayout(ctx context.Context, routeID string) (*SeatLayout, error) {
+	return mockSeatLayout(ctx, g.GetPlatformName(), "goibibo_", g.Currency, routeID)
+}
+
+// mockBookingDesk plays a provider's booking system for the mock platforms.
+// Seats come from the simulated seat map; held and sold seats are tracked
+// so two holds cannot take the same seat.
+type mockBookingDesk struct {
+	mu    sync.Mutex
+	holds map[string]*mockHold
+	taken map[string]string // platform/route/seat -> hold ID
+}
+
+type mockHold struct {
+	seats     []string
+	fare      float64
+	expiresAt time.Time
+	confirmed bool
+}
+
+var mockDesk = &mockBookingDesk{holds: map[string]*mockHold{}, taken: map[string]string{}}
+
+func (d *mockBookingDesk) block(ctx context.Context, platform, prefix, currency string, ttl time.Duration, req BookingRequest) (*SeatHold, error) {
+	if !strings.HasPrefix(req.RouteID, prefix) {
+		return nil, fmt.Errorf("%s route %s: %w", platform, req.RouteID, ErrRouteNotFound)
+	}
+	if err := sleepContext(ctx, time.Duration(rand.Intn(200)+100)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultHoldTTL
+	}
+
+	layout := simulatedSeatLayout(platform+"/"+req.RouteID, req.RouteID, currency)
+	seats := map[string]Seat{}
+	for _, deck := range layout.Decks {
+		for _, seat := range deck.Seats {
+			seats[seat.Number] = seat
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pruneLocked()
+
+	hold := &mockHold{expiresAt: time.Now().Add(ttl)}
+	for _, p := range req.Passengers {
+		seat, ok := seats[p.Seat]
+		key := platform + "/" + req.RouteID + "/" + p.Seat
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("seat %s does not exist: %w", p.Seat, ErrSeatUnavailable)
+		case seat.Booked || d.taken[key] != "":
+			return nil, fmt.Errorf("seat %s is taken: %w", p.Seat, ErrSeatUnavailable)
+		case seat.LadiesOnly && p.Gender != "female":
+			return nil, fmt.Errorf("seat %s is reserved for women: %w", p.Seat, ErrSeatUnavailable)
+		}
+		hold.seats = append(hold.seats, key)
+		hold.fare += seat.Fare
+	}
+
+	holdID := fmt.Sprintf("HOLD%d", rand.Int63())
+	d.holds[holdID] = hold
+	for _, key := range hold.seats {
+		d.taken[key] = holdID
+	}
+	return &SeatHold{
+		HoldID:    holdID,
+		Fare:      Price{Amount: math.Round(hold.fare*100) / 100, Currency: layout.Currency, Platform: platform},
+		ExpiresAt: hold.expiresAt,
+	}, nil
+}
+
+func (d *mockBookingDesk) confirm(ctx context.Context, holdID string) (*BookingConfirmation, error) {
+	if err := sleepContext(ctx, time.Duration(rand.Intn(300)+200)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pruneLocked()
+
+	hold, ok := d.holds[holdID]
+	if !ok {
+		return nil, ErrHoldExpired
+	}
+	hold.confirmed = true
+	return &BookingConfirmation{
+		PNR:          fmt.Sprintf("PNR%08d", rand.Intn(100000000)),
+		TicketNumber: fmt.Sprintf("TKT%010d", rand.Int63n(10000000000)),
+	}, nil
+}
+
+// cancel releases a hold, or cancels a ticket with a 90% refund
+func (d *mockBookingDesk) cancel(ctx context.Context, holdID string) (*BookingCancellation, error) {
+	if err := sleepContext(ctx, time.Duration(rand.Intn(200)+100)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hold, ok := d.holds[holdID]
+	if !ok {
+		// An expired hold has nothing left to release
+		return &BookingCancellation{}, nil
+	}
+	d.releaseLocked(holdID, hold)
+	if !hold.confirmed {
+		return &BookingCancellation{}, nil
+	}
+	return &BookingCancellation{Refund: math.Round(hold.fare*0.9*100) / 100}, nil
+}
+
+// markTaken shows seats held or sold through the desk as booked
+func (d *mockBookingDesk) markTaken(platform, routeID string, layout *SeatLayout) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pruneLocked()
+
+	for i := range layout.Decks {
+		seats := layout.Decks[i].Seats
+		for j := range seats {
+			if d.taken[platform+"/"+routeID+"/"+seats[j].Number] != "" {
+				seats[j].Booked = true
+			}
+		}
+	}
+	layout.count()
+}
+
+// pruneLocked frees the seats of holds that lapsed unconfirmed
+func (d *mockBookingDesk) pruneLocked() {
+	for id, hold := range d.holds {
+		if !hold.confirmed && time.Now().After(hold.expiresAt) {
+			d.releaseLocked(id, hold)
+		}
+	}
+}
+
+func (d *mockBookingDesk) releaseLocked(holdID string, hold *mockHold) {
+	for _, key := range hold.seats {
+		delete(d.taken, key)
+	}
+	delete(d.holds, holdID)
+}
+
+func (r *RedBusService) BlockSeats(ctx context.Context, req BookingRequest) (*SeatHold, error) {
+	return mockDesk.block(ctx, r.GetPlatformName(), "redbus_", r.Currency, r.HoldTTL, req)
+}
+
+func (r *RedBusService) ConfirmBooking(ctx context.Context, holdID string) (*BookingConfirmation, error) {
+	return mockDesk.confirm(ctx, holdID)
+}
+
+func (r *RedBusService) CancelBooking(ctx context.Context, holdID string) (*BookingCancellation, error) {
+	return mockDesk.cancel(ctx, holdID)
+}
+
+func (m *MakeMyTripService) BlockSeats(ctx context.Context, req BookingRequest) (*SeatHold, error) {
+	return mockDesk.block(ctx, m.GetPlatformName(), "mmt_", m.Currency, m.HoldTTL, req)
+}
+
+func (m *MakeMyTripService) ConfirmBooking(ctx context.Context, holdID string) (*BookingConfirmation, error) {
+	return mockDesk.confirm(ctx, holdID)
+}
+
+func (m *MakeMyTripService) CancelBooking(ctx context.Context, holdID string) (*BookingCancellation, error) {
+	return mockDesk.cancel(ctx, holdID)
+}
+
+func (g *GoibiboService) BlockSeats(ctx context.Context, req BookingRequest) (*SeatHold, error) {
+	return mockDesk.block(ctx, g.GetPlatformName(), "goibibo_", g.Currency, g.HoldTTL, req)
+}
+
+func (g *GoibiboService) ConfirmBooking(ctx context.Context, holdID string) (*BookingConfirmation, error) {
+	return mockDesk.confirm(ctx, holdID)
+}
+
+func (g *GoibiboService) CancelBooking(ctx context.Context, holdID string) (*BookingCancellation, error) {
+	return mockDesk.cancel(ctx, holdID)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Location represents a city or bus station
+type Location struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	City    string  `json:"city"`
+	State   string  `json:"state"`
+	Country string  `json:"country"`
+	Lat     float64 `json:"latitude"`
+	Lng     float64 `json:"longitude"`
+
+	// IANA time zone, e.g. "Asia/Kolkata"; times at this location are
+	// reported in it
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// BusOperator represents a bus company
+type BusOperator struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Logo     string  `json:"logo"`
+	Rating   float64 `json:"rating"`
+	Platform string  `json:"platform"` // which booking platform
+}
+
+// BusType represents different types of buses
+type BusType struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Seats       int      `json:"seats"`
+	Amenities   []string `json:"amenities"`
+	Description string   `json:"description"`
+}
+
+// Route represents a bus route with pricing
+type Route struct {
+	ID             string      `json:"id"`
+	From           Location    `json:"from"`
+	To             Location    `json:"to"`
+	Operator       BusOperator `json:"operator"`
+	BusType        BusType     `json:"bus_type"`
+	DepartureTime  time.Time   `json:"departure_time"` // in From's time zone
+	ArrivalTime    time.Time   `json:"arrival_time"`   // in To's time zone
+	Price          Price       `json:"price"`
+	AvailableSeats int         `json:"available_seats"`
+	BookingURL     string      `json:"booking_url"`
+
+	// Where passengers can get on and off, in time order
+	BoardingPoints []StopPoint `json:"boarding_points,omitempty"`
+	DroppingPoints []StopPoint `json:"dropping_points,omitempty"`
+
+	// Registry ID of the provider that returned the route. Price.Platform
+	// is the booking brand, which several providers may share.
+	Provider string `json:"provider"`
+
+	// Journey time, always ArrivalTime minus DepartureTime. Set all three
+	// with SetTimes.
+	Duration        time.Duration `json:"-"`
+	DurationMinutes int           `json:"duration_minutes"`
+	DurationText    string        `json:"duration"` // e.g. "8h 30m"
+}
+
+// SetTimes sets the departure and arrival, converting each to the local
+// time of its end of the journey, and derives the duration from them.
+// From and To must already be set.
+func (r *Route) SetTimes(departure, arrival time.Time) {
+	r.DepartureTime = departure.In(r.From.TimeZone())
+	r.ArrivalTime = arrival.In(r.To.TimeZone())
+	r.Duration = arrival.Sub(departure)
+	r.DurationMinutes = int(r.Duration.Minutes())
+	r.DurationText = formatDuration(r.Duration)
+}
+
+// UnmarshalJSON restores Duration, which is not serialised itself
+func (r *Route) UnmarshalJSON(data []byte) error {
+	type plain Route
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	r.Duration = r.ArrivalTime.Sub(r.DepartureTime)
+	return nil
+}
+
+// Price represents pricing information. Amount and Currency are as the
+// provider quoted them.
+type Price struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Platform string  `json:"platform"`
+
+	// Set when the price is one offer for a grouped trip
+	RouteID    string `json:"route_id,omitempty"`
+	BookingURL string `json:"booking_url,omitempty"`
+
+	// The price in the base currency, set by CurrencyService.Normalize
+	BaseAmount   float64 `json:"base_amount,omitempty"`
+	BaseCurrency string  `json:"base_currency,omitempty"`
+
+	// The price in the currency the client asked to see, if any
+	Display *Money `json:"display,omitempty"`
+}
+
+// Comparable is the amount prices are sorted and compared by: the base
+// currency amount once normalised, otherwise the quoted amount
+func (p Price) Comparable() float64 {
+	if p.BaseCurrency != "" {
+		return p.BaseAmount
+	}
+	return p.Amount
+}
+
+// ComparableCurrency is the currency of Comparable
+func (p Price) ComparableCurrency() string {
+	if p.BaseCurrency != "" {
+		return p.BaseCurrency
+	}
+	return p.Currency
+}
+
+// SearchRequest represents a search query
+type SearchRequest struct {
+	FromCity   string    `json:"from_city"`
+	ToCity     string    `json:"to_city"`
+	Date       time.Time `json:"date"`
+	Passengers int       `json:"passengers"`
+
+	// Optional return date or multi-city legs. With legs, FromCity, ToCity
+	// and Date are ignored.
+	ReturnDate *time.Time  `json:"return_date,omitempty"`
+	Legs       []SearchLeg `json:"legs,omitempty"`
+
+	// Layover limits for connecting itineraries; zero means the default
+	MinLayover Duration `json:"min_layover,omitempty"`
+	MaxLayover Duration `json:"max_layover,omitempty"`
+
+	// Currency to show prices in as well as the quoted one, e.g. "USD"
+	DisplayCurrency string `json:"display_currency,omitempty"`
+
+	// Optional filters and ordering, inlined into the JSON body
+	SearchFilters
+	RankOptions
+}
+
+// SearchResponse represents the aggregated search results
+type SearchResponse struct {
+	Status     string  `json:"status"`
+	Message    string  `json:"message"`
+	SearchID   string  `json:"search_id"`
+	Routes     []Route `json:"routes"`
+	TotalFound int     `json:"total_found"`
+	SearchTime string  `json:"search_time"`
+
+	// Per-provider breakdown; Partial is set when at least one provider
+	// failed but others still returned results
+	Platforms   []PlatformResult `json:"platforms"`
+	Partial     bool             `json:"partial"`
+	Cache       string           `json:"cache"`
+	FilteredOut int              `json:"filtered_out"`
+	Sort        string           `json:"sort"`
+	Page        *PageInfo        `json:"page,omitempty"`
+	ExpiresAt   *time.Time       `json:"expires_at,omitempty"`
+
+	// Prices are compared in BaseCurrency; DisplayCurrency is echoed when
+	// the client asked for display prices
+	BaseCurrency    string `json:"base_currency"`
+	DisplayCurrency string `json:"display_currency,omitempty"`
+
+	// Routes grouped into physical buses with a price per platform
+	Trips []Trip `json:"trips"`
+
+	// One-stop journeys, planned when there is no direct route
+	Itineraries []Itinerary `json:"itineraries,omitempty"`
+}
+
+// Platform result statuses
+const (
+	PlatformStatusSuccess     = "success"
+	PlatformStatusError       = "error"
+	PlatformStatusTimeout     = "timeout"
+	PlatformStatusCancelled   = "cancelled"
+	PlatformStatusRateLimited = "rate_limited"
+	PlatformStatusCircuitOpen = "circuit_open"
+	PlatformStatusUnsupported = "unsupported"
+)
+
+// PlatformResult reports how a single provider fared in a search
+type PlatformResult struct {
+	ID         string         `json:"id"`
+	Platform   string         `json:"platform"`
+	Status     string         `json:"status"`
+	LatencyMS  int64          `json:"latency_ms"`
+	RouteCount int            `json:"route_count"`
+	Cache      string         `json:"cache,omitempty"`
+	Error      *PlatformError `json:"error,omitempty"`
+}
+
+// PlatformError is a provider failure in a form the frontend can act on
+type PlatformError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+// BookingPlatform represents external booking platforms
+type BookingPlatform struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	BaseURL  string `json:"base_url"`
+	APIKey   string `json:"api_key,omitempty"`
+	IsActive bool   `json:"is_active"`
+}
+
+// Some sample data for testing
+func GetSampleOperators() []BusOperator {
+	return []BusOperator{
+		{ID: "redbus", Name: "RedBus", Logo: "redbus.png", Rating: 4.2, Platform: "redbus"},
+		{ID: "makemytrip", Name: "MakeMyTrip", Logo: "mmt.png", Rating: 4.0, Platform: "makemytrip"},
+		{ID: "goibibo", Name: "Goibibo", Logo: "goibibo.png", Rating: 3.9, Platform: "goibibo"},
+		{ID: "abhibus", Name: "AbhiBus", Logo: "abhibus.png", Rating: 4.1, Platform: "abhibus"},
+	}
+}
+
+func GetSampleBusTypes() []BusType {
+	return []BusType{
+		{
+			ID: "ac_sleeper", Name: "AC Sleeper", Seats: 40,
+			Amenities:   []string{"AC", "Sleeper", "Blanket", "Pillow"},
+			Description: "Air conditioned sleeper bus with comfortable berths",
+		},
+		{
+			ID: "non_ac_seater", Name: "Non-AC Seater", Seats: 50,
+			Amenities:   []string{"Pushback Seats", "Charging Point"},
+			Description: "Comfortable seater bus for day travel",
+		},
+		{
+			ID: "volvo_ac", Name: "Volvo AC", Seats: 45,
+			Amenities:   []string{"AC", "WiFi", "Entertainment", "USB Charging"},
+			Description: "Premium Volvo bus with luxury amenities",
+		},
+	}
+}
+
+// Response represents a standard API response
+type Response struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// sendJSON sends a JSON response
+func sendJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
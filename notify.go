@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Notification channels
+const (
+	ChannelLog     = "log"
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+)
+
+// ErrChannelUnavailable is returned for a notification channel the server
+// is not set up for, such as email without an SMTP server
+var ErrChannelUnavailable = errors.New("notification channel not available")
+
+// Notification is what an alert sends when it matches
+type Notification struct {
+	AlertID   string    `json:"alert_id"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Date      string    `json:"date"`
+	Matches   int       `json:"matches"`
+	Route     Route     `json:"route"` // the cheapest match
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// AlertTarget says where an alert's notifications go
+type AlertTarget struct {
+	Channel string `json:"channel"`
+	URL     string `json:"url,omitempty"`   // webhook
+	Email   string `json:"email,omitempty"` // email
+}
+
+// Notifier delivers notifications over one channel
+type Notifier interface {
+	Validate(target AlertTarget) error
+	Notify(ctx context.Context, target AlertTarget, n Notification) error
+}
+
+// SMTPConfig is the mail server alert emails are sent through
+type SMTPConfig struct {
+	Addr     string `json:"addr"` // host:port
+	From     string `json:"from"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// newNotifiers returns the notifiers the configuration allows. Log and
+// webhook are always available; email needs an SMTP server.
+func newNotifiers(smtpConfig SMTPConfig, webhookHosts []string, sinkURL string) map[string]Notifier {
+	notifiers := map[string]Notifier{
+		ChannelLog:     LogNotifier{},
+		ChannelWebhook: NewWebhookNotifier(webhookHosts, sinkURL),
+	}
+	if smtpConfig.Addr != "" {
+		notifiers[ChannelEmail] = &SMTPNotifier{config: smtpConfig}
+	}
+	return notifiers
+}
+
+// LogNotifier prints notifications to the server log
+type LogNotifier struct{}
+
+func (LogNotifier) Validate(AlertTarget) error { return nil }
+
+func (LogNotifier) Notify(_ context.Context, _ AlertTarget, n Notification) error {
+	fmt.Printf("🔔 Alert %s: %s\n", n.AlertID, n.Message)
+	return nil
+}
+
+// WebhookNotifier posts notifications as JSON. Without an allow-list it
+// only connects to public addresses, checked when dialling so a hostname
+// can't be pointed at an internal service after validation. The local
+// sink, when there is one, is allowed either way.
+type WebhookNotifier struct {
+	client *http.Client
+	hosts  []string
+	sink   *url.URL
+}
+
+func NewWebhookNotifier(hosts []string, sinkURL string) *WebhookNotifier {
+	w := &WebhookNotifier{hosts: hosts}
+	if sinkURL != "" {
+		w.sink, _ = url.Parse(sinkURL)
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if len(hosts) == 0 {
+		dialer.Control = w.dialPublicOnly
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	w.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			return w.checkURL(req.URL)
+		},
+	}
+	return w
+}
+
+func (w *WebhookNotifier) Validate(target AlertTarget) error {
+	u, err := url.Parse(target.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("webhook alerts need an http or https url")
+	}
+	return w.checkURL(u)
+}
+
+// isSink reports whether u is the local sink's webhook URL
+func (w *WebhookNotifier) isSink(u *url.URL) bool {
+	return w.sink != nil && u.Scheme == w.sink.Scheme &&
+		strings.EqualFold(u.Host, w.sink.Host) && u.Path == w.sink.Path
+}
+
+// checkURL allows the sink, then applies the allow-list, or without one
+// rejects hosts that are obviously internal. Names that resolve to
+// internal addresses are caught by dialPublicOnly.
+func (w *WebhookNotifier) checkURL(u *url.URL) error {
+	if w.isSink(u) {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if len(w.hosts) > 0 {
+		for _, allowed := range w.hosts {
+			allowed = strings.ToLower(allowed)
+			if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+				return nil
+			}
+		}
+		return fmt.Errorf("webhook host %q is not allowed", host)
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("webhook host %q is not public", host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return fmt.Errorf("webhook host %q is not public", host)
+	}
+	return nil
+}
+
+// dialPublicOnly refuses connections to loopback, private, link-local and
+// other non-public addresses, except the sink's port on loopback
+func (w *WebhookNotifier) dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err == nil && w.sink != nil && addr.Unmap().IsLoopback() && port == w.sink.Port() {
+		return nil
+	}
+	if err != nil || !isPublicAddr(addr) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), which
+// IsPrivate doesn't cover
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func (w *WebhookNotifier) Notify(ctx context.Context, target AlertTarget, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %v", target.URL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %d", target.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// SMTPNotifier emails notifications
+type SMTPNotifier struct {
+	config SMTPConfig
+}
+
+func (s *SMTPNotifier) Validate(target AlertTarget) error {
+	if !strings.Contains(target.Email, "@") || strings.ContainsAny(target.Email, "\r\n") {
+		return fmt.Errorf("email alerts need a valid email address")
+	}
+	return nil
+}
+
+// Notify sends a plain-text email. net/smtp has no context support, so a
+// slow server is only bounded by the dial timeout of the OS.
+func (s *SMTPNotifier) Notify(_ context.Context, target AlertTarget, n Notification) error {
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		host, _, _ := strings.Cut(s.config.Addr, ":")
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", target.Email)
+	// The subject carries user-supplied cities; encoding it also
+	// neutralises any CR or LF
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n", n.Message)
+	if n.Route.BookingURL != "" {
+		fmt.Fprintf(&msg, "Book: %s\r\n", n.Route.BookingURL)
+	}
+
+	if err := smtp.SendMail(s.config.Addr, auth, s.config.From, []string{target.Email}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("email to %s: %v", target.Email, err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestWebhookNotifierValidate(t *testing.T) {
+	const sink = "http://localhost:8080/sink"
+
+	tests := []struct {
+		name    string
+		hosts   []string
+		sinkURL string
+		url     string
+		wantErr bool
+	}{
+		{name: "public host", url: "https://hooks.example.com/bus"},
+		{name: "public host with sink on", sinkURL: sink, url: "https://hooks.example.com/bus"},
+		{name: "sink", sinkURL: sink, url: sink},
+		{name: "sink beside an allow-list", hosts: []string{"hooks.example.com"}, sinkURL: sink, url: sink},
+		{name: "allow-listed host", hosts: []string{".example.com"}, sinkURL: sink, url: "https://hooks.example.com/bus"},
+		{name: "host outside allow-list", hosts: []string{"hooks.example.com"}, sinkURL: sink, url: "https://other.example.org/bus", wantErr: true},
+		{name: "localhost without sink", url: sink, wantErr: true},
+		{name: "other path on the sink's port", sinkURL: sink, url: "http://localhost:8080/bookings", wantErr: true},
+		{name: "other local port", sinkURL: sink, url: "http://localhost:9000/sink", wantErr: true},
+		{name: "metadata address", sinkURL: sink, url: "http://169.254.169.254/latest", wantErr: true},
+		{name: "not http", url: "ftp://hooks.example.com/bus", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewWebhookNotifier(tt.hosts, tt.sinkURL).Validate(AlertTarget{URL: tt.url})
+			if tt.wantErr != (err != nil) {
+				t.Errorf("Validate(%s) = %v, want error=%v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifierDial(t *testing.T) {
+	tests := []struct {
+		name    string
+		sinkURL string
+		address string
+		wantErr bool
+	}{
+		{name: "public address", address: "93.184.216.34:443"},
+		{name: "loopback", address: "127.0.0.1:8080", wantErr: true},
+		{name: "sink port on loopback", sinkURL: "http://localhost:8080/sink", address: "127.0.0.1:8080"},
+		{name: "sink port on IPv6 loopback", sinkURL: "http://localhost:8080/sink", address: "[::1]:8080"},
+		{name: "other loopback port", sinkURL: "http://localhost:8080/sink", address: "127.0.0.1:6379", wantErr: true},
+		{name: "sink port on a private address", sinkURL: "http://localhost:8080/sink", address: "10.0.0.5:8080", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewWebhookNotifier(nil, tt.sinkURL).dialPublicOnly("tcp", tt.address, nil)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("dialPublicOnly(%s) = %v, want error=%v", tt.address, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connection planner defaults
+const (
+	DefaultMinLayover = 30 * time.Minute
+	DefaultMaxLayover = 6 * time.Hour
+
+	// DefaultMaxViaCities is how many intermediate cities are tried
+	DefaultMaxViaCities = 4
+
+	// DefaultMaxDetour is how much longer, as a ratio of the direct
+	// distance, a journey through an intermediate city may be
+	DefaultMaxDetour = 1.3
+
+	// MaxItineraries caps the itineraries returned for one search
+	MaxItineraries = 20
+
+	// DefaultPlanTimeout bounds the whole search for connections, which
+	// runs while the user waits for an answer that had no direct bus
+	DefaultPlanTimeout = 8 * time.Second
+
+	// plannerConcurrency is how many leg searches run at once
+	plannerConcurrency = 3
+
+	// plannerReserveTokens are rate limit tokens a leg search leaves for
+	// direct searches; providers without them sit the leg out
+	plannerReserveTokens = 1
+)
+
+// connectionRank orders itineraries by total duration and price equally,
+// unless the request asks for another order
+var connectionRank = RankOptions{Sort: SortBest, Weights: &RankWeights{Price: 0.5, Duration: 0.5}}
+
+// Itinerary is a one-stop journey made of two routes, changing buses at Via
+type Itinerary struct {
+	ID            string    `json:"id"`
+	From          Location  `json:"from"`
+	Via           Location  `json:"via"`
+	To            Location  `json:"to"`
+	Legs          []Route   `json:"legs"`
+	DepartureTime time.Time `json:"departure_time"`
+	ArrivalTime   time.Time `json:"arrival_time"`
+	TotalPrice    float64   `json:"total_price"` // in Currency, the base currency
+	Currency      string    `json:"currency"`
+	DisplayTotal  *Money    `json:"display_total,omitempty"`
+
+	Duration        time.Duration `json:"-"`
+	DurationMinutes int           `json:"duration_minutes"`
+	DurationText    string        `json:"duration"`
+	Layover         time.Duration `json:"-"`
+	LayoverMinutes  int           `json:"layover_minutes"`
+}
+
+// ConnectionPlanner finds one-stop itineraries through catalogue cities
+// that lie roughly on the way between two cities
+type ConnectionPlanner struct {
+	Catalogue    *LocationCatalogue
+	MaxViaCities int
+	MaxDetour    float64
+	Timeout      time.Duration // no limit beyond ctx when zero
+}
+
+func NewConnectionPlanner(catalogue *LocationCatalogue) *ConnectionPlanner {
+	return &ConnectionPlanner{
+		Catalogue:    catalogue,
+		MaxViaCities: DefaultMaxViaCities,
+		MaxDetour:    DefaultMaxDetour,
+		Timeout:      DefaultPlanTimeout,
+	}
+}
+
+// providersWithHeadroom returns the IDs of providers the filters allow that
+// can spend a request and keep reserve tokens
+func (pm *RealPlatformManager) providersWithHeadroom(filters SearchFilters, reserve float64) []string {
+	var ids []string
+	for _, p := range pm.platforms {
+		name := p.GetPlatformName()
+		if !filters.AllowsPlatform(pm.providerIDs[name], name) {
+			continue
+		}
+		if reporter, ok := p.(RateLimitReporter); ok && !reporter.RateLimitStatus().HasHeadroom(reserve) {
+			continue
+		}
+		ids = append(ids, pm.providerIDs[name])
+	}
+	return ids
+}
+
+// ViaCities returns the intermediate cities worth trying, shortest total
+// distance first. Cities without coordinates are never candidates.
+func (p *ConnectionPlanner) ViaCities(from, to Location) []Location {
+	if !hasCoordinates(from) || !hasCoordinates(to) {
+		return nil
+	}
+	direct := distanceKM(from, to)
+
+	type candidate struct {
+		loc   Location
+		total float64
+	}
+	var candidates []candidate
+	for _, city := range p.Catalogue.Cities() {
+		if city.ID == from.ID || city.ID == to.ID || !hasCoordinates(city.Location) {
+			continue
+		}
+		total := distanceKM(from, city.Location) + distanceKM(city.Location, to)
+		if total <= direct*p.MaxDetour {
+			candidates = append(candidates, candidate{city.Location, total})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].total < candidates[j].total
+	})
+
+	var vias []Location
+	for _, c := range candidates {
+		if len(vias) == p.MaxViaCities {
+			break
+		}
+		vias = append(vias, c.loc)
+	}
+	return vias
+}
+
+// Plan searches both legs through every candidate city and pairs routes
+// whose layover falls within the request's window. The second leg is also
+// searched on the following day, for layovers that cross midnight.
+// Itineraries come back ranked, at most MaxItineraries of them.
+//
+// Leg searches only go to providers with rate limit budget to spare, and
+// those still running when Timeout is up are given up on.
+func (p *ConnectionPlanner) Plan(ctx context.Context, pm *RealPlatformManager, req SearchRequest) []Itinerary {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	from := p.Catalogue.Location(req.FromCity)
+	to := p.Catalogue.Location(req.ToCity)
+	minLayover, maxLayover := req.layoverWindow()
+
+	type legSearch struct {
+		via    int
+		first  bool
+		req    SearchRequest
+		routes []Route
+	}
+
+	vias := p.ViaCities(from, to)
+	var searches []*legSearch
+	for i, via := range vias {
+		first, second := connectionLegs(req)
+		first.ToCity = via.City
+		second.FromCity = via.City
+		nextDay := second
+		nextDay.Date = second.Date.AddDate(0, 0, 1)
+
+		searches = append(searches,
+			&legSearch{via: i, first: true, req: first},
+			&legSearch{via: i, req: second},
+			&legSearch{via: i, req: nextDay},
+		)
+	}
+
+	// Failed leg searches just mean fewer itineraries
+	sem := make(chan struct{}, plannerConcurrency)
+	var wg sync.WaitGroup
+	for _, s := range searches {
+		wg.Add(1)
+		go func(s *legSearch) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			s.req.Platforms = pm.providersWithHeadroom(s.req.SearchFilters, plannerReserveTokens)
+			if len(s.req.Platforms) == 0 {
+				return
+			}
+			if search, err := pm.SearchAllPlatforms(ctx, s.req); err == nil {
+				s.routes = search.Routes
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	firstLegs := make([][]Route, len(vias))
+	secondLegs := make([][]Route, len(vias))
+	for _, s := range searches {
+		if s.first {
+			firstLegs[s.via] = append(firstLegs[s.via], s.routes...)
+		} else {
+			secondLegs[s.via] = append(secondLegs[s.via], s.routes...)
+		}
+	}
+
+	var itineraries []Itinerary
+	for i, via := range vias {
+		for _, first := range firstLegs[i] {
+			for _, second := range secondLegs[i] {
+				layover := second.DepartureTime.Sub(first.ArrivalTime)
+				if layover < minLayover || layover > maxLayover || first.Price.ComparableCurrency() != second.Price.ComparableCurrency() {
+					continue
+				}
+				itinerary := newItinerary(from, via, to, first, second)
+				if req.MaxPrice > 0 && itinerary.TotalPrice > req.MaxPrice {
+					continue
+				}
+				if req.MinPrice > 0 && itinerary.TotalPrice < req.MinPrice {
+					continue
+				}
+				itineraries = append(itineraries, itinerary)
+			}
+		}
+	}
+
+	rank := connectionRank
+	if req.Sort != "" {
+		rank = req.RankOptions
+	}
+	SortItineraries(itineraries, rank)
+
+	if len(itineraries) > MaxItineraries {
+		itineraries = itineraries[:MaxItineraries]
+	}
+	return itineraries
+}
+
+// connectionLegs splits a request's filters between the two legs. The
+// departure window belongs to the first leg and the arrival window to the
+// second; price limits apply to the total, so neither leg gets them.
+func connectionLegs(req SearchRequest) (SearchRequest, SearchRequest) {
+	req.MinPrice, req.MaxPrice = 0, 0
+	first, second := req, req
+	first.ArrivalAfter, first.ArrivalBefore = "", ""
+	second.DepartureAfter, second.DepartureBefore = "", ""
+	return first, second
+}
+
+// layoverWindow returns the request's layover limits or the defaults
+func (r SearchRequest) layoverWindow() (time.Duration, time.Duration) {
+	minLayover, maxLayover := DefaultMinLayover, DefaultMaxLayover
+	if r.MinLayover > 0 {
+		minLayover = time.Duration(r.MinLayover)
+	}
+	if r.MaxLayover > 0 {
+		maxLayover = time.Duration(r.MaxLayover)
+	}
+	return minLayover, maxLayover
+}
+
+func newItinerary(from, via, to Location, first, second Route) Itinerary {
+	sum := sha1.Sum([]byte(strings.Join([]string{first.Provider, first.ID, second.Provider, second.ID}, "|")))
+
+	itinerary := Itinerary{
+		ID:            "itin_" + hex.EncodeToString(sum[:6]),
+		From:          from,
+		Via:           via,
+		To:            to,
+		Legs:          []Route{first, second},
+		DepartureTime: first.DepartureTime,
+		ArrivalTime:   second.ArrivalTime,
+		TotalPrice:    first.Price.Comparable() + second.Price.Comparable(),
+		Currency:      first.Price.ComparableCurrency(),
+		Duration:      second.ArrivalTime.Sub(first.DepartureTime),
+		Layover:       second.DepartureTime.Sub(first.ArrivalTime),
+	}
+	itinerary.DurationMinutes = int(itinerary.Duration.Minutes())
+	itinerary.DurationText = formatDuration(itinerary.Duration)
+	itinerary.LayoverMinutes = int(itinerary.Layover.Minutes())
+	return itinerary
+}
+
+// SortItineraries orders itineraries in place. Rating is the lower of the
+// two operators' ratings and seats the fewer left on either leg.
+func SortItineraries(itineraries []Itinerary, opts RankOptions) {
+	keys := make([]rankKey, len(itineraries))
+	for i, it := range itineraries {
+		first, second := it.Legs[0], it.Legs[1]
+		keys[i] = rankKey{
+			price:     it.TotalPrice,
+			departure: it.DepartureTime,
+			arrival:   it.ArrivalTime,
+			duration:  it.Duration,
+			rating:    min(first.Operator.Rating, second.Operator.Rating),
+			seats:     min(first.AvailableSeats, second.AvailableSeats),
+			amenities: min(len(first.BusType.Amenities), len(second.BusType.Amenities)),
+			id:        it.ID,
+		}
+	}
+
+	sorted := make([]Itinerary, len(itineraries))
+	for i, index := range rankOrder(keys, opts) {
+		sorted[i] = itineraries[index]
+	}
+	copy(itineraries, sorted)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort orders accepted by the sort parameter. Each uses its natural
+// direction: cheapest, earliest, shortest, highest rated, most seats, or
+// highest "best" score first.
+const (
+	SortPrice     = "price"
+	SortDeparture = "departure"
+	SortArrival   = "arrival"
+	SortDuration  = "duration"
+	SortRating    = "rating"
+	SortSeats     = "seats"
+	SortBest      = "best"
+)
+
+var sortOrders = []string{SortPrice, SortDeparture, SortArrival, SortDuration, SortRating, SortSeats, SortBest}
+
+// RankWeights weights the criteria of the "best" score. They are relative;
+// only their proportions matter.
+type RankWeights struct {
+	Price     float64 `json:"price"`
+	Duration  float64 `json:"duration"`
+	Rating    float64 `json:"rating"`
+	Amenities float64 `json:"amenities"`
+}
+
+// DefaultRankWeights favours price, then journey time
+var DefaultRankWeights = RankWeights{Price: 0.4, Duration: 0.3, Rating: 0.2, Amenities: 0.1}
+
+// RankOptions chooses how results are ordered
+type RankOptions struct {
+	Sort    string       `json:"sort,omitempty"`    // defaults to price
+	Weights *RankWeights `json:"weights,omitempty"` // only used by "best"
+}
+
+// Validate checks the sort order and weights
+func (o RankOptions) Validate() error {
+	if o.Sort != "" && !slices.Contains(sortOrders, o.Sort) {
+		return fmt.Errorf("sort must be one of %s", strings.Join(sortOrders, ", "))
+	}
+	if w := o.Weights; w != nil {
+		if w.Price < 0 || w.Duration < 0 || w.Rating < 0 || w.Amenities < 0 {
+			return fmt.Errorf("weights must not be negative")
+		}
+		if w.Price+w.Duration+w.Rating+w.Amenities == 0 {
+			return fmt.Errorf("at least one weight must be positive")
+		}
+	}
+	return nil
+}
+
+// order returns the effective sort order
+func (o RankOptions) order() string {
+	if o.Sort == "" {
+		return SortPrice
+	}
+	return o.Sort
+}
+
+func (o RankOptions) weights() RankWeights {
+	if o.Weights == nil {
+		return DefaultRankWeights
+	}
+	return *o.Weights
+}
+
+// rankKey holds the values a route or trip is ranked by
+type rankKey struct {
+	price     float64
+	departure time.Time
+	arrival   time.Time
+	duration  time.Duration
+	rating    float64
+	seats     int
+	amenities int
+	id        string // platform and route ID, the final tie-breaker
+	score     float64
+}
+
+func routeRankKey(route Route) rankKey {
+	return rankKey{
+		price:     route.Price.Comparable(),
+		departure: route.DepartureTime,
+		arrival:   route.ArrivalTime,
+		duration:  route.Duration,
+		rating:    route.Operator.Rating,
+		seats:     route.AvailableSeats,
+		amenities: len(route.BusType.Amenities),
+		id:        route.Price.Platform + "/" + route.ID,
+	}
+}
+
+// SortRoutes orders routes in place
+func SortRoutes(routes []Route, opts RankOptions) {
+	keys := make([]rankKey, len(routes))
+	for i, route := range routes {
+		keys[i] = routeRankKey(route)
+	}
+
+	sorted := make([]Route, len(routes))
+	for i, index := range rankOrder(keys, opts) {
+		sorted[i] = routes[index]
+	}
+	copy(routes, sorted)
+}
+
+// SortTrips orders trips in place, ranking each by its cheapest offer. A
+// trip's seats are the most any single platform has left.
+func SortTrips(trips []Trip, opts RankOptions) {
+	keys := make([]rankKey, len(trips))
+	for i, trip := range trips {
+		key := rankKey{
+			price:     trip.CheapestOffer().Comparable(),
+			departure: trip.DepartureTime,
+			arrival:   trip.ArrivalTime,
+			duration:  trip.Duration,
+			rating:    trip.Operator.Rating,
+			amenities: len(trip.BusType.Amenities),
+			id:        trip.ID,
+		}
+		for _, route := range trip.Routes {
+			key.seats = max(key.seats, route.AvailableSeats)
+		}
+		keys[i] = key
+	}
+
+	sorted := make([]Trip, len(trips))
+	for i, index := range rankOrder(keys, opts) {
+		sorted[i] = trips[index]
+	}
+	copy(trips, sorted)
+}
+
+// rankOrder returns the indexes of keys in ranked order. Ties fall back to
+// price, departure and then ID, so the same results always come back in
+// the same order and pages never overlap.
+func rankOrder(keys []rankKey, opts RankOptions) []int {
+	order := opts.order()
+	if order == SortBest {
+		scoreKeys(keys, opts.weights())
+	}
+
+	primary := func(a, b rankKey) int {
+		switch order {
+		case SortDeparture:
+			return a.departure.Compare(b.departure)
+		case SortArrival:
+			return a.arrival.Compare(b.arrival)
+		case SortDuration:
+			return cmp.Compare(a.duration, b.duration)
+		case SortRating:
+			return cmp.Compare(b.rating, a.rating)
+		case SortSeats:
+			return cmp.Compare(b.seats, a.seats)
+		case SortBest:
+			return cmp.Compare(b.score, a.score)
+		default:
+			return cmp.Compare(a.price, b.price)
+		}
+	}
+
+	indexes := make([]int, len(keys))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		a, b := keys[indexes[i]], keys[indexes[j]]
+		if c := primary(a, b); c != 0 {
+			return c < 0
+		}
+		if c := cmp.Compare(a.price, b.price); c != 0 {
+			return c < 0
+		}
+		if c := a.departure.Compare(b.departure); c != 0 {
+			return c < 0
+		}
+		return a.id < b.id
+	})
+	return indexes
+}
+
+// scoreKeys sets each key's "best" score between 0 and 1. Every criterion
+// is scaled to 0..1 across the result set, so the weights compare like
+// with like whatever the currency or journey length.
+func scoreKeys(keys []rankKey, w RankWeights) {
+	if len(keys) == 0 {
+		return
+	}
+
+	minPrice, maxPrice := keys[0].price, keys[0].price
+	minDuration, maxDuration := keys[0].duration, keys[0].duration
+	maxAmenities := 0
+	for _, k := range keys {
+		minPrice, maxPrice = min(minPrice, k.price), max(maxPrice, k.price)
+		minDuration, maxDuration = min(minDuration, k.duration), max(maxDuration, k.duration)
+		maxAmenities = max(maxAmenities, k.amenities)
+	}
+
+	total := w.Price + w.Duration + w.Rating + w.Amenities
+	for i := range keys {
+		k := &keys[i]
+		score := w.Price*(1-scale(k.price, minPrice, maxPrice)) +
+			w.Duration*(1-scale(float64(k.duration), float64(minDuration), float64(maxDuration))) +
+			w.Rating*(k.rating/5) +
+			w.Amenities*scale(float64(k.amenities), 0, float64(maxAmenities))
+		k.score = score / total
+	}
+}
+
+// scale maps v from [lo, hi] to [0, 1]; a flat range scales to 0
+func scale(v, lo, hi float64) float64 {
+	if hi <= lo {
+		return 0
+	}
+	return (v - lo) / (hi - lo)
+}
+
+// ParseRankParams reads sort and weight_* query parameters. Weights that
+// are not given keep their default.
+func ParseRankParams(q url.Values) (RankOptions, error) {
+	opts := RankOptions{Sort: strings.ToLower(q.Get("sort"))}
+
+	weights := DefaultRankWeights
+	overridden := false
+	for name, field := range map[string]*float64{
+		"weight_price":     &weights.Price,
+		"weight_duration":  &weights.Duration,
+		"weight_rating":    &weights.Rating,
+		"weight_amenities": &weights.Amenities,
+	} {
+		value := q.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be a number", name)
+		}
+		*field = n
+		overridden = true
+	}
+	if overridden {
+		opts.Weights = &weights
+	}
+
+	return opts, opts.Validate()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited matches any RateLimitError via errors.Is
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError is returned instead of blocking when a provider's request
+// budget is spent, either locally or as reported by the upstream API
+type RateLimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited: %s (retry after %s)", e.Reason, e.RetryAfter.Round(time.Second))
+	}
+	return "rate limited: " + e.Reason
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimiter is a thread-safe token bucket with an optional daily quota.
+// It also tracks the budget the upstream API reports through Retry-After
+// and X-RateLimit-* headers, and refuses calls the upstream would reject.
+type RateLimiter struct {
+	mu sync.Mutex
+
+	rate   float64 // tokens added per second, 0 means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	dailyQuota int // 0 means no daily quota
+	dailyUsed  int
+	day        string
+
+	// Upstream state from response headers
+	blockedUntil      time.Time
+	upstreamRemaining int // -1 when unknown
+	upstreamReset     time.Time
+}
+
+func NewRateLimiter(requestsPerSecond float64, burst, dailyQuota int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:              requestsPerSecond,
+		burst:             float64(burst),
+		tokens:            float64(burst),
+		last:              time.Now(),
+		dailyQuota:        dailyQuota,
+		upstreamRemaining: -1,
+	}
+}
+
+// reasonLocalRate marks the only RateLimitError that Wait sleeps through
+const reasonLocalRate = "local request rate exceeded"
+
+// Allow takes one token, or returns a *RateLimitError without blocking
+func (l *RateLimiter) Allow() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Before(l.blockedUntil) {
+		return &RateLimitError{Reason: "upstream asked us to back off", RetryAfter: l.blockedUntil.Sub(now)}
+	}
+	if l.upstreamRemaining == 0 && now.Before(l.upstreamReset) {
+		return &RateLimitError{Reason: "upstream quota exhausted", RetryAfter: l.upstreamReset.Sub(now)}
+	}
+
+	if l.dailyQuota > 0 {
+		today := now.UTC().Format("2006-01-02")
+		if today != l.day {
+			l.day, l.dailyUsed = today, 0
+		}
+		if l.dailyUsed >= l.dailyQuota {
+			midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			return &RateLimitError{Reason: "daily quota exhausted", RetryAfter: midnight.Sub(now)}
+		}
+	}
+
+	if l.rate > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens < 1 {
+			wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+			return &RateLimitError{Reason: reasonLocalRate, RetryAfter: wait}
+		}
+		l.tokens--
+	}
+
+	if l.dailyQuota > 0 {
+		l.dailyUsed++
+	}
+	if l.upstreamRemaining > 0 {
+		l.upstreamRemaining--
+	}
+
+	return nil
+}
+
+// Wait takes one token, sleeping under ctx while the token bucket refills.
+// A spent quota, an upstream back-off or a refill that would land after
+// ctx's deadline is still returned as an error.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		err := l.Allow()
+		var limited *RateLimitError
+		if !errors.As(err, &limited) || limited.Reason != reasonLocalRate {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < limited.RetryAfter {
+			return err
+		}
+		if err := sleepContext(ctx, limited.RetryAfter); err != nil {
+			return err
+		}
+	}
+}
+
+type waitForBudgetKey struct{}
+
+// WithBudgetWait marks requests made under ctx as willing to wait for a
+// provider's token bucket to refill, within ctx's deadline, rather than be
+// refused straight away. Work that spreads many requests over a provider,
+// such as the fare calendar, uses it to pace itself.
+func WithBudgetWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, waitForBudgetKey{}, true)
+}
+
+// take takes a token for a request made under ctx
+func (l *RateLimiter) take(ctx context.Context) error {
+	if wait, _ := ctx.Value(waitForBudgetKey{}).(bool); wait {
+		return l.Wait(ctx)
+	}
+	return l.Allow()
+}
+
+// Observe records the rate limit state reported by an upstream response
+func (l *RateLimiter) Observe(resp *http.Response) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+		l.blockedUntil = now.Add(retryAfter)
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		l.blockedUntil = now.Add(time.Second)
+	}
+
+	if remaining, ok := headerInt(resp.Header, "X-RateLimit-Remaining", "X-RateLimit-Requests-Remaining"); ok {
+		l.upstreamRemaining = remaining
+	}
+	if reset, ok := headerInt(resp.Header, "X-RateLimit-Reset", "X-RateLimit-Requests-Reset"); ok {
+		l.upstreamReset = parseRateLimitReset(reset, now)
+	}
+}
+
+// RateLimitStatus is the remaining request budget of a limiter
+type RateLimitStatus struct {
+	RequestsPerSecond float64    `json:"requests_per_second"`
+	TokensAvailable   float64    `json:"tokens_available"`
+	Burst             int        `json:"burst"`
+	DailyQuota        int        `json:"daily_quota,omitempty"`
+	DailyRemaining    *int       `json:"daily_remaining,omitempty"`
+	UpstreamRemaining *int       `json:"upstream_remaining,omitempty"`
+	BlockedUntil      *time.Time `json:"blocked_until,omitempty"`
+}
+
+// Status reports the limiter's current headroom without consuming a token
+func (l *RateLimiter) Status() RateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	status := RateLimitStatus{
+		RequestsPerSecond: l.rate,
+		TokensAvailable:   l.burst,
+		Burst:             int(l.burst),
+		DailyQuota:        l.dailyQuota,
+	}
+	if l.rate > 0 {
+		status.TokensAvailable = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	if l.dailyQuota > 0 {
+		remaining := l.dailyQuota
+		if l.day == now.UTC().Format("2006-01-02") {
+			remaining -= l.dailyUsed
+		}
+		status.DailyRemaining = &remaining
+	}
+	if l.upstreamRemaining >= 0 {
+		remaining := l.upstreamRemaining
+		status.UpstreamRemaining = &remaining
+	}
+	if now.Before(l.blockedUntil) {
+		until := l.blockedUntil
+		status.BlockedUntil = &until
+	}
+	return status
+}
+
+// HasHeadroom reports whether background work may spend a request and
+// still leave reserve tokens, and a tenth of the daily quota, for searches
+// users are waiting on
+func (s RateLimitStatus) HasHeadroom(reserve float64) bool {
+	if s.BlockedUntil != nil {
+		return false
+	}
+	if s.RequestsPerSecond > 0 && s.TokensAvailable < max(1, min(float64(s.Burst), 1+reserve)) {
+		return false
+	}
+	if s.DailyRemaining != nil && *s.DailyRemaining <= s.DailyQuota/10 {
+		return false
+	}
+	if s.UpstreamRemaining != nil && *s.UpstreamRemaining <= 1 {
+		return false
+	}
+	return true
+}
+
+// parseRetryAfter accepts both forms of Retry-After: delay seconds or an
+// HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if at.Before(now) {
+			return 0, true
+		}
+		return at.Sub(now), true
+	}
+	return 0, false
+}
+
+// parseRateLimitReset interprets X-RateLimit-Reset, which providers send
+// either as a Unix timestamp or as seconds until the window resets
+func parseRateLimitReset(value int, now time.Time) time.Time {
+	if value > 1_000_000_000 {
+		return time.Unix(int64(value), 0)
+	}
+	return now.Add(time.Duration(value) * time.Second)
+}
+
+// headerInt returns the first of names present in h as an integer
+func headerInt(h http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if value := h.Get(name); value != "" {
+			if n, err := strconv.Atoi(value); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
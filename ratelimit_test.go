@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name       string
+		rate       float64
+		burst      int
+		dailyQuota int
+		calls      int
+		allowed    int
+		reason     string
+	}{
+		{name: "burst then refused", rate: 1, burst: 3, calls: 5, allowed: 3, reason: reasonLocalRate},
+		{name: "burst below one is one", rate: 1, burst: 0, calls: 2, allowed: 1, reason: reasonLocalRate},
+		{name: "unlimited rate", rate: 0, burst: 1, calls: 50, allowed: 50},
+		{name: "daily quota", rate: 0, burst: 1, dailyQuota: 4, calls: 6, allowed: 4, reason: "daily quota exhausted"},
+		{name: "quota tighter than burst", rate: 100, burst: 10, dailyQuota: 2, calls: 5, allowed: 2, reason: "daily quota exhausted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.rate, tt.burst, tt.dailyQuota)
+			allowed := 0
+			var lastErr error
+			for i := 0; i < tt.calls; i++ {
+				if err := l.Allow(); err != nil {
+					lastErr = err
+				} else {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Fatalf("allowed %d of %d calls, want %d", allowed, tt.calls, tt.allowed)
+			}
+			if tt.reason == "" {
+				if lastErr != nil {
+					t.Fatalf("unexpected error: %v", lastErr)
+				}
+				return
+			}
+			var limited *RateLimitError
+			if !errors.As(lastErr, &limited) || !errors.Is(lastErr, ErrRateLimited) {
+				t.Fatalf("error = %v, want a RateLimitError", lastErr)
+			}
+			if limited.Reason != tt.reason {
+				t.Errorf("reason = %q, want %q", limited.Reason, tt.reason)
+			}
+			if limited.RetryAfter <= 0 {
+				t.Errorf("RetryAfter = %s, want a positive delay", limited.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		headers map[string]string
+		blocked bool
+	}{
+		{name: "plain success", status: http.StatusOK},
+		{name: "retry-after seconds", status: http.StatusServiceUnavailable, headers: map[string]string{"Retry-After": "30"}, blocked: true},
+		{name: "429 without retry-after", status: http.StatusTooManyRequests, blocked: true},
+		{name: "upstream quota spent", status: http.StatusOK, headers: map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "60"}, blocked: true},
+		{name: "upstream quota left", status: http.StatusOK, headers: map[string]string{"X-RateLimit-Requests-Remaining": "5", "X-RateLimit-Requests-Reset": "60"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(0, 1, 0)
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			for k, v := range tt.headers {
+				resp.Header.Set(k, v)
+			}
+			l.Observe(resp)
+
+			err := l.Allow()
+			if tt.blocked != (err != nil) {
+				t.Fatalf("Allow() = %v, want blocked=%v", err, tt.blocked)
+			}
+			if tt.blocked && !errors.Is(err, ErrRateLimited) {
+				t.Errorf("error = %v, want ErrRateLimited", err)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		quota    int
+		timeout  time.Duration
+		wantErr  bool
+		maxDelay time.Duration
+	}{
+		{name: "waits for refill", rate: 20, timeout: time.Second, maxDelay: 500 * time.Millisecond},
+		{name: "refill after deadline", rate: 0.1, timeout: 50 * time.Millisecond, wantErr: true, maxDelay: 20 * time.Millisecond},
+		{name: "quota is not waited out", rate: 0, quota: 1, timeout: time.Second, wantErr: true, maxDelay: 20 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.rate, 1, tt.quota)
+			if err := l.Allow(); err != nil {
+				t.Fatalf("first Allow: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			err := l.Wait(ctx)
+			if elapsed := time.Since(start); elapsed > tt.maxDelay {
+				t.Errorf("Wait took %s, want at most %s", elapsed, tt.maxDelay)
+			}
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Wait() = %v, want error=%v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTakeHonoursBudgetWait(t *testing.T) {
+	l := NewRateLimiter(20, 1, 0)
+	if err := l.take(context.Background()); err != nil {
+		t.Fatalf("first take: %v", err)
+	}
+	if err := l.take(context.Background()); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("take without budget wait = %v, want ErrRateLimited", err)
+	}
+
+	ctx, cancel := context.WithTimeout(WithBudgetWait(context.Background()), time.Second)
+	defer cancel()
+	if err := l.take(ctx); err != nil {
+		t.Fatalf("take with budget wait = %v, want a token", err)
+	}
+}
+
+func TestHasHeadroom(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	blocked := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name    string
+		status  RateLimitStatus
+		reserve float64
+		want    bool
+	}{
+		{name: "unlimited", status: RateLimitStatus{Burst: 1, TokensAvailable: 1}, reserve: 1, want: true},
+		{name: "spare tokens", status: RateLimitStatus{RequestsPerSecond: 1, Burst: 5, TokensAvailable: 3}, reserve: 1, want: true},
+		{name: "reserve only", status: RateLimitStatus{RequestsPerSecond: 1, Burst: 5, TokensAvailable: 1.5}, reserve: 1, want: false},
+		{name: "reserve capped at burst", status: RateLimitStatus{RequestsPerSecond: 0.5, Burst: 1, TokensAvailable: 1}, reserve: 1, want: true},
+		{name: "blocked upstream", status: RateLimitStatus{Burst: 1, TokensAvailable: 1, BlockedUntil: &blocked}, want: false},
+		{name: "daily quota nearly spent", status: RateLimitStatus{Burst: 1, TokensAvailable: 1, DailyQuota: 100, DailyRemaining: intPtr(10)}, want: false},
+		{name: "daily quota fine", status: RateLimitStatus{Burst: 1, TokensAvailable: 1, DailyQuota: 100, DailyRemaining: intPtr(50)}, want: true},
+		{name: "upstream nearly spent", status: RateLimitStatus{Burst: 1, TokensAvailable: 1, UpstreamRemaining: intPtr(1)}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.HasHeadroom(tt.reserve); got != tt.want {
+				t.Errorf("HasHeadroom(%v) = %v, want %v", tt.reserve, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "120", want: 2 * time.Minute, wantOK: true},
+		{value: "-5", wantOK: false},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, wantOK: true},
+		{value: now.Add(-time.Hour).Format(http.TimeFormat), want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Quote(tt.value), func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrMissingAPIKey is returned by factories for providers that need a key
+// when none is configured
+var ErrMissingAPIKey = errors.New("api_key is not set")
+
+// Duration is a time.Duration that reads from JSON as either a Go duration
+// string ("8s", "5m") or a number of seconds
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %v", v, err)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ProviderConfig enables and configures one provider. ID selects the
+// registered factory; everything else is optional and falls back to the
+// provider's own defaults.
+type ProviderConfig struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name,omitempty"`
+	Enabled bool    `json:"enabled"`
+	Weight  float64 `json:"weight,omitempty"`
+
+	BaseURL     string   `json:"base_url,omitempty"`
+	APIKey      string   `json:"api_key,omitempty"`
+	Timeout     Duration `json:"timeout,omitempty"`      // search deadline
+	HTTPTimeout Duration `json:"http_timeout,omitempty"` // per HTTP request
+	CacheTTL    Duration `json:"cache_ttl,omitempty"`
+	HoldTTL     Duration `json:"hold_ttl,omitempty"` // seat holds, mock providers only
+
+	// Currency fares are quoted in when the provider does not say; INR
+	// when unset. Mock providers quote every fare in it.
+	Currency string `json:"currency,omitempty"`
+
+	RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+	Burst             int     `json:"burst,omitempty"`
+	DailyQuota        int     `json:"daily_quota,omitempty"`
+}
+
+// EffectiveWeight returns the provider's weight, defaulting to 1
+func (c ProviderConfig) EffectiveWeight() float64 {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
+
+// apiConfig overlays the configured values onto a provider's defaults
+func (c ProviderConfig) apiConfig(defaults APIConfig) APIConfig {
+	if c.BaseURL != "" {
+		defaults.BaseURL = c.BaseURL
+	}
+	if c.APIKey != "" {
+		defaults.APIKey = c.APIKey
+	}
+	if c.HTTPTimeout > 0 {
+		defaults.Timeout = time.Duration(c.HTTPTimeout)
+	}
+	if c.RequestsPerSecond > 0 {
+		defaults.RequestsPerSecond = c.RequestsPerSecond
+	}
+	if c.Burst > 0 {
+		defaults.Burst = c.Burst
+	}
+	if c.DailyQuota > 0 {
+		defaults.DailyQuota = c.DailyQuota
+	}
+	return defaults
+}
+
+// ProviderFactory builds a provider from its configuration
+type ProviderFactory func(cfg ProviderConfig) (PlatformService, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]ProviderFactory{}
+)
+
+// RegisterProvider makes a provider implementation available under a stable
+// ID. Implementations call it from init.
+func RegisterProvider(id string, factory ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[id]; exists {
+		panic(fmt.Sprintf("provider %q registered twice", id))
+	}
+	registry[id] = factory
+}
+
+// RegisteredProviderIDs lists every registered provider ID in sorted order
+func RegisteredProviderIDs() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	ids := make([]string, 0, len(registry))
+	for id := range registry {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// NewProvider builds the provider described by cfg
+func NewProvider(cfg ProviderConfig) (PlatformService, error) {
+	registryMu.RLock()
+	factory, ok := registry[cfg.ID]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", cfg.ID)
+	}
+	return factory(cfg)
+}
+
+// DefaultProviderConfigs is used when no config file lists providers: the
+// RedBus mock plus the real APIs, which only start once a key is set
+func DefaultProviderConfigs() []ProviderConfig {
+	return []ProviderConfig{
+		{ID: "redbus_mock", Enabled: true},
+		{ID: "redbus", Enabled: true, Timeout: Duration(8 * time.Second), CacheTTL: Duration(5 * time.Minute)},
+		{ID: "rapidapi", Enabled: true, Timeout: Duration(8 * time.Second), CacheTTL: Duration(10 * time.Minute)},
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// APIError is a non-2xx response from a provider
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Transient reports whether the same request may succeed if retried
+func (e *APIError) Transient() bool {
+	return e.StatusCode >= 500 || e.StatusCode == 408
+}
+
+// TransportError is a failure to get any response from a provider
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("request failed: %v", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Transient is true unless the request was cancelled. Timeouts are worth
+// retrying; when the deadline was the caller's own, the backoff wait in
+// RetryPolicy.Do notices and stops.
+func (e *TransportError) Transient() bool {
+	return !errors.Is(e.Err, context.Canceled)
+}
+
+// IsTransient reports whether err is worth retrying. Errors that don't say
+// otherwise, such as malformed responses, are treated as permanent.
+func IsTransient(err error) bool {
+	var t interface{ Transient() bool }
+	return errors.As(err, &t) && t.Transient()
+}
+
+// RetryPolicy retries transient failures with exponential backoff and full
+// jitter
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used for idempotent provider requests
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// Do calls fn until it succeeds, fails permanently, runs out of attempts or
+// ctx is done. Before each retry it backs off and then calls pace, if set,
+// to wait for any other budget the attempt needs. If either wait fails the
+// previous attempt's error is returned, so callers always see the failure
+// that came from upstream.
+func (p RetryPolicy) Do(ctx context.Context, pace func(context.Context) error, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < max(p.MaxAttempts, 1); attempt++ {
+		if attempt > 0 {
+			if waitErr := sleepContext(ctx, p.backoff(attempt)); waitErr != nil {
+				return err
+			}
+			if pace != nil {
+				if waitErr := pace(ctx); waitErr != nil {
+					return err
+				}
+			}
+		}
+		if err = fn(); err == nil || !IsTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff returns a random delay up to BaseDelay*2^(attempt-1), capped at
+// MaxDelay
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ErrCircuitOpen is returned instead of calling a provider whose breaker is
+// open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// Default circuit breaker settings
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// CircuitBreaker stops calls to a provider after Threshold consecutive
+// failures. Once Cooldown has passed it lets a single probe through
+// (half-open); the probe's outcome closes or re-opens the circuit.
+type CircuitBreaker struct {
+	mu sync.Mutex
+
+	Threshold int
+	Cooldown  time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		state:     CircuitClosed,
+	}
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by Record.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = CircuitHalfOpen
+		b.probing = true
+		return nil
+	case CircuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record feeds the outcome of an allowed call back into the breaker.
+// Errors that say nothing about provider health are ignored.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.probing = false
+	}
+
+	if err != nil && !isProviderFault(err) {
+		return
+	}
+
+	if err == nil {
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.Threshold {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// isProviderFault reports whether err reflects on the provider's health, as
+// opposed to our own rate limits, unsupported input or the caller leaving
+func isProviderFault(err error) bool {
+	return !errors.Is(err, ErrRateLimited) &&
+		!errors.Is(err, ErrCityNotSupported) &&
+		!errors.Is(err, ErrRouteNotFound) &&
+		!errors.Is(err, ErrSeatUnavailable) &&
+		!errors.Is(err, ErrHoldExpired) &&
+		!errors.Is(err, ErrBookingState) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// BreakerStatus is a snapshot of a circuit breaker for status endpoints
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		retryAt := b.openedAt.Add(b.Cooldown)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
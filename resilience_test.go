@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: &APIError{StatusCode: 503}, want: true},
+		{name: "request timeout", err: &APIError{StatusCode: 408}, want: true},
+		{name: "client error", err: &APIError{StatusCode: 404}, want: false},
+		{name: "connection refused", err: &TransportError{Err: errors.New("connection refused")}, want: true},
+		{name: "client timeout", err: &TransportError{Err: context.DeadlineExceeded}, want: true},
+		{name: "cancelled", err: &TransportError{Err: context.Canceled}, want: false},
+		{name: "wrapped server error", err: fmt.Errorf("RedBus API error: %w", &APIError{StatusCode: 502}), want: true},
+		{name: "rate limited", err: &RateLimitError{Reason: reasonLocalRate}, want: false},
+		{name: "plain error", err: errors.New("bad json"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := &APIError{StatusCode: 503}
+	permanent := &APIError{StatusCode: 400}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		results  []error // outcome of each attempt; nil is success
+		pace     func(context.Context) error
+		wantErr  error
+		wantRuns int
+	}{
+		{name: "first try", results: []error{nil}, wantRuns: 1},
+		{name: "recovers", results: []error{transient, transient, nil}, wantRuns: 3},
+		{name: "gives up", results: []error{transient, transient, transient}, wantErr: transient, wantRuns: 3},
+		{name: "permanent stops", results: []error{permanent}, wantErr: permanent, wantRuns: 1},
+		{
+			name:    "pace failure keeps upstream error",
+			results: []error{transient},
+			pace: func(context.Context) error {
+				return &RateLimitError{Reason: reasonLocalRate}
+			},
+			wantErr:  transient,
+			wantRuns: 1,
+		},
+		{
+			name:     "pace success retries",
+			results:  []error{transient, nil},
+			pace:     func(context.Context) error { return nil },
+			wantRuns: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			err := policy.Do(context.Background(), tt.pace, func() error {
+				err := tt.results[runs]
+				runs++
+				return err
+			})
+			if err != tt.wantErr {
+				t.Errorf("Do() = %v, want %v", err, tt.wantErr)
+			}
+			if runs != tt.wantRuns {
+				t.Errorf("ran %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoStopsWithContext(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	upstream := &APIError{StatusCode: 500}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	runs := 0
+	err := policy.Do(ctx, nil, func() error {
+		runs++
+		return upstream
+	})
+	if err != upstream {
+		t.Errorf("Do() = %v, want the upstream error", err)
+	}
+	if runs != 1 {
+		t.Errorf("ran %d times, want 1", runs)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{attempt: 1, ceiling: 100 * time.Millisecond},
+		{attempt: 2, ceiling: 200 * time.Millisecond},
+		{attempt: 3, ceiling: 300 * time.Millisecond},
+		{attempt: 40, ceiling: 300 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			if d := policy.backoff(tt.attempt); d < 0 || d >= tt.ceiling {
+				t.Fatalf("backoff(%d) = %s, want [0, %s)", tt.attempt, d, tt.ceiling)
+			}
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	fault := &APIError{StatusCode: 500}
+
+	tests := []struct {
+		name      string
+		outcomes  []error
+		cooldown  time.Duration
+		wait      time.Duration
+		wantState string
+		wantAllow bool
+	}{
+		{name: "stays closed below threshold", outcomes: []error{fault, fault}, cooldown: time.Hour, wantState: CircuitClosed, wantAllow: true},
+		{name: "opens at threshold", outcomes: []error{fault, fault, fault}, cooldown: time.Hour, wantState: CircuitOpen, wantAllow: false},
+		{name: "success resets the count", outcomes: []error{fault, fault, nil, fault, fault}, cooldown: time.Hour, wantState: CircuitClosed, wantAllow: true},
+		{name: "business errors are ignored", outcomes: []error{ErrCityNotSupported, ErrRouteNotFound, &RateLimitError{}, context.Canceled}, cooldown: time.Hour, wantState: CircuitClosed, wantAllow: true},
+		{name: "half open after cooldown", outcomes: []error{fault, fault, fault}, cooldown: 10 * time.Millisecond, wait: 20 * time.Millisecond, wantState: CircuitHalfOpen, wantAllow: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(3, tt.cooldown)
+			for _, err := range tt.outcomes {
+				if allowErr := b.Allow(); allowErr != nil {
+					t.Fatalf("Allow() = %v before the breaker should trip", allowErr)
+				}
+				b.Record(err)
+			}
+			time.Sleep(tt.wait)
+
+			err := b.Allow()
+			if tt.wantAllow != (err == nil) {
+				t.Fatalf("Allow() = %v, want allowed=%v", err, tt.wantAllow)
+			}
+			if !tt.wantAllow && !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("Allow() = %v, want ErrCircuitOpen", err)
+			}
+			if state := b.Status().State; state != tt.wantState {
+				t.Errorf("state = %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	fault := &APIError{StatusCode: 500}
+
+	tests := []struct {
+		name      string
+		probe     error
+		wantState string
+	}{
+		{name: "probe success closes", probe: nil, wantState: CircuitClosed},
+		{name: "probe failure reopens", probe: fault, wantState: CircuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(1, 10*time.Millisecond)
+			b.Allow()
+			b.Record(fault)
+			time.Sleep(20 * time.Millisecond)
+
+			if err := b.Allow(); err != nil {
+				t.Fatalf("probe not allowed: %v", err)
+			}
+			if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("second call during probe = %v, want ErrCircuitOpen", err)
+			}
+			b.Record(tt.probe)
+			if state := b.Status().State; state != tt.wantState {
+				t.Errorf("state = %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
+
+// A local rate limit hit while retrying must not hide the upstream failure,
+// which is what the breaker and health stats need to see
+func TestMakeRequestRetriesKeepUpstreamError(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		wantCalls int32
+	}{
+		{name: "retries wait for tokens", rate: 50, wantCalls: 3},
+		{name: "token too far off", rate: 0.01, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				http.Error(w, "upstream down", http.StatusBadGateway)
+			}))
+			defer server.Close()
+
+			client := NewHTTPClient(APIConfig{
+				BaseURL:           server.URL,
+				Timeout:           time.Second,
+				RequestsPerSecond: tt.rate,
+				Burst:             1,
+				Retry:             RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			_, err := client.MakeRequest(ctx, http.MethodGet, "/search", nil, nil)
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+				t.Fatalf("MakeRequest() = %v, want the upstream 502", err)
+			}
+			if !isProviderFault(err) {
+				t.Errorf("isProviderFault(%v) = false, want true", err)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("upstream called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
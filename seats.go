@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// ErrCapabilityNotSupported is returned when a provider lacks an optional
+// capability, such as seat layouts
+var ErrCapabilityNotSupported = errors.New("not supported by this provider")
+
+// ErrProviderNotFound is returned for an unknown or disabled provider ID
+var ErrProviderNotFound = errors.New("provider not found")
+
+// ErrRouteNotFound is returned when a provider does not know a route ID
+var ErrRouteNotFound = errors.New("route not found")
+
+// Seat kinds and positions
+const (
+	SeatSeater  = "seater"
+	SeatSleeper = "sleeper"
+
+	SeatWindow = "window"
+	SeatAisle  = "aisle"
+	SeatMiddle = "middle"
+
+	DeckLower = "lower"
+	DeckUpper = "upper"
+)
+
+// Seat is one seat or berth on a deck. Row and Column are zero-based grid
+// positions for drawing the layout.
+type Seat struct {
+	Number     string  `json:"number"`
+	Deck       string  `json:"deck"`
+	Row        int     `json:"row"`
+	Column     int     `json:"column"`
+	Type       string  `json:"type"`
+	Position   string  `json:"position"`
+	Fare       float64 `json:"fare"`
+	LadiesOnly bool    `json:"ladies_only"`
+	Booked     bool    `json:"booked"`
+}
+
+// Deck is one level of the bus
+type Deck struct {
+	Name    string `json:"name"`
+	Rows    int    `json:"rows"`
+	Columns int    `json:"columns"`
+	Seats   []Seat `json:"seats"`
+}
+
+// SeatLayout is the full seat map of one route
+type SeatLayout struct {
+	Provider       string `json:"provider"`
+	RouteID        string `json:"route_id"`
+	Currency       string `json:"currency"`
+	TotalSeats     int    `json:"total_seats"`
+	AvailableSeats int    `json:"available_seats"`
+	Decks          []Deck `json:"decks"`
+}
+
+// count fills in the seat totals from the decks
+func (l *SeatLayout) count() {
+	l.TotalSeats, l.AvailableSeats = 0, 0
+	for _, deck := range l.Decks {
+		for _, seat := range deck.Seats {
+			l.TotalSeats++
+			if !seat.Booked {
+				l.AvailableSeats++
+			}
+		}
+	}
+}
+
+// SeatLayoutProvider is implemented by providers that can return the seat
+// map of one of their routes
+type SeatLayoutProvider interface {
+	SeatLayout(ctx context.Context, routeID string) (*SeatLayout, error)
+}
+
+// SeatLayout fetches a route's seat map from the provider with the given
+// registry ID, under the same timeout and circuit breaker as searches
+func (pm *RealPlatformManager) SeatLayout(ctx context.Context, providerID, routeID string) (*SeatLayout, error) {
+	p, ok := pm.ProviderByID(providerID)
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+	seats, ok := p.(SeatLayoutProvider)
+	if !ok {
+		return nil, ErrCapabilityNotSupported
+	}
+
+	breaker := pm.breakers[p.GetPlatformName()]
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pm.timeoutFor(p))
+	defer cancel()
+
+	layout, err := seats.SeatLayout(ctx, routeID)
+	breaker.Record(err)
+	if err != nil {
+		return nil, err
+	}
+	layout.Provider = providerID
+	return layout, nil
+}
+
+// simulatedSeatLayout builds a plausible seat map for the mock providers.
+// The layout is derived from seed, so the same route always has the same
+// bus, fares and booked seats, and the mocks' listings count their free
+// seats from it. Fares are worked out in INR and quoted in currency, like
+// the mocks' route prices.
+func simulatedSeatLayout(seed, routeID, currency string) *SeatLayout {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	baseFare := float64(500 + rng.Intn(10)*100)
+	layout := &SeatLayout{RouteID: routeID, Currency: "INR"}
+
+	if rng.Intn(2) == 0 {
+		// 2+2 seater, 10 rows plus a back row of five
+		deck := Deck{Name: DeckLower, Rows: 11, Columns: 5}
+		for row := 0; row < 11; row++ {
+			for col := 0; col < 5; col++ {
+				if col == 2 && row < 10 {
+					continue // aisle
+				}
+				position := SeatAisle
+				switch {
+				case col == 0 || col == 4:
+					position = SeatWindow
+				case row == 10 && col == 2:
+					position = SeatMiddle
+				}
+				fare := baseFare
+				if position == SeatWindow {
+					fare += 50
+				}
+				deck.Seats = append(deck.Seats, Seat{
+					Number:   fmt.Sprintf("%d%c", row+1, 'A'+col),
+					Deck:     DeckLower,
+					Row:      row,
+					Column:   col,
+					Type:     SeatSeater,
+					Position: position,
+					Fare:     fare,
+				})
+			}
+		}
+		layout.Decks = []Deck{deck}
+	} else {
+		// 2+1 sleeper on two decks; upper berths sell for a little less
+		for _, name := range []string{DeckLower, DeckUpper} {
+			deck := Deck{Name: name, Rows: 6, Columns: 4}
+			prefix, fare := "L", baseFare+300
+			if name == DeckUpper {
+				prefix, fare = "U", baseFare+200
+			}
+			for row := 0; row < 6; row++ {
+				for _, col := range []int{0, 2, 3} {
+					position := SeatAisle
+					if col == 0 || col == 3 {
+						position = SeatWindow
+					}
+					deck.Seats = append(deck.Seats, Seat{
+						Number:   fmt.Sprintf("%s%d", prefix, len(deck.Seats)+1),
+						Deck:     name,
+						Row:      row,
+						Column:   col,
+						Type:     SeatSleeper,
+						Position: position,
+						Fare:     fare,
+					})
+				}
+			}
+			layout.Decks = append(layout.Decks, deck)
+		}
+	}
+
+	// Book about a third of the seats, and reserve a few for women the way
+	// operators do, next to seats already booked by women. Now and then a
+	// bus is sold out, so seat alerts have openings to wait for.
+	soldOut := rng.Intn(6) == 0
+	for d := range layout.Decks {
+		seats := layout.Decks[d].Seats
+		for i := range seats {
+			seats[i].Booked = soldOut || rng.Intn(3) == 0
+		}
+		for i := 0; i+1 < len(seats); i++ {
+			if seats[i].Booked && !seats[i+1].Booked && seats[i].Row == seats[i+1].Row &&
+				seats[i+1].Column == seats[i].Column+1 && rng.Intn(4) == 0 {
+				seats[i+1].LadiesOnly = true
+			}
+		}
+	}
+
+	for d := range layout.Decks {
+		for i := range layout.Decks[d].Seats {
+			price := mockPrice(layout.Decks[d].Seats[i].Fare, currency, "")
+			layout.Decks[d].Seats[i].Fare, layout.Currency = price.Amount, price.Currency
+		}
+	}
+
+	layout.count()
+	return layout
+}
+
+// redBusSeat is one seat in a RedBus seat layout response
+type redBusSeat struct {
+	SeatNumber  string  `json:"seatNumber"`
+	Deck        string  `json:"deck"` // "lower" or "upper"
+	Row         int     `json:"row"`
+	Column      int     `json:"column"`
+	IsSleeper   bool    `json:"isSleeper"`
+	IsWindow    bool    `json:"isWindow"`
+	Fare        float64 `json:"fare"`
+	IsLadies    bool    `json:"isLadiesSeat"`
+	IsAvailable bool    `json:"isAvailable"`
+}
+
+// convertRedBusSeats groups RedBus seats into decks. Seat fares are quoted
+// in currency, the provider's.
+func convertRedBusSeats(routeID, currency string, rbSeats []redBusSeat) *SeatLayout {
+	layout := &SeatLayout{RouteID: routeID, Currency: currency}
+
+	decks := map[string]*Deck{}
+	var order []string
+	for _, s := range rbSeats {
+		name := strings.ToLower(s.Deck)
+		if name != DeckUpper {
+			name = DeckLower
+		}
+		deck, ok := decks[name]
+		if !ok {
+			deck = &Deck{Name: name}
+			decks[name] = deck
+			order = append(order, name)
+		}
+
+		seat := Seat{
+			Number:     s.SeatNumber,
+			Deck:       name,
+			Row:        s.Row,
+			Column:     s.Column,
+			Type:       SeatSeater,
+			Position:   SeatAisle,
+			Fare:       s.Fare,
+			LadiesOnly: s.IsLadies,
+			Booked:     !s.IsAvailable,
+		}
+		if s.IsSleeper {
+			seat.Type = SeatSleeper
+		}
+		if s.IsWindow {
+			seat.Position = SeatWindow
+		}
+		deck.Seats = append(deck.Seats, seat)
+		deck.Rows = max(deck.Rows, s.Row+1)
+		deck.Columns = max(deck.Columns, s.Column+1)
+	}
+
+	for _, name := range order {
+		layout.Decks = append(layout.Decks, *decks[name])
+	}
+	layout.count()
+	return layout
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretKeyEnv holds the passphrase API keys and passwords are encrypted
+// with when the configuration is saved. Without it they are not saved.
+const SecretKeyEnv = "BUS_SCANNER_SECRET_KEY"
+
+// sealedPrefix marks a value encrypted by sealSecret
+const sealedPrefix = "sealed:"
+
+// secretKeyFromEnv derives an AES-256 key from SecretKeyEnv, or returns nil
+func secretKeyFromEnv() []byte {
+	passphrase := os.Getenv(SecretKeyEnv)
+	if passphrase == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(passphrase))
+	return sum[:]
+}
+
+func sealSecret(key []byte, plain string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts a sealed value. Values saved before secrets were
+// sealed are returned as they are.
+func openSecret(key []byte, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if key == nil {
+		return "", fmt.Errorf("%s is not set", SecretKeyEnv)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("sealed value too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("wrong %s or corrupt value", SecretKeyEnv)
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secrets returns pointers to every secret in c. Providers are copied first
+// so the caller's slice is never changed.
+func (c *Config) secrets() []*string {
+	c.Providers = append([]ProviderConfig(nil), c.Providers...)
+	fields := []*string{&c.RedBusAPIKey, &c.RapidAPIKey, &c.Alerts.SMTP.Password}
+	for i := range c.Providers {
+		fields = append(fields, &c.Providers[i].APIKey)
+	}
+	return fields
+}
+
+// Sealed returns a copy of c that is safe to store: secrets are encrypted
+// with key, or left out when key is nil
+func (c Config) Sealed(key []byte) (Config, error) {
+	for _, field := range c.secrets() {
+		if *field == "" {
+			continue
+		}
+		if key == nil {
+			*field = ""
+			continue
+		}
+		sealed, err := sealSecret(key, *field)
+		if err != nil {
+			return Config{}, err
+		}
+		*field = sealed
+	}
+	return c, nil
+}
+
+// Opened reverses Sealed
+func (c Config) Opened(key []byte) (Config, error) {
+	for _, field := range c.secrets() {
+		plain, err := openSecret(key, *field)
+		if err != nil {
+			return Config{}, err
+		}
+		*field = plain
+	}
+	return c, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultSessionTTL is how long a search's results can be paged after it ran
+const DefaultSessionTTL = 15 * time.Minute
+
+// Page size limits for result sessions
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// SearchSession is the result set of one search, kept server-side so it can
+// be paged, re-sorted and re-filtered without asking the providers again
+type SearchSession struct {
+	ID        string
+	Request   SearchRequest
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// Every route the providers returned, before the request's filters.
+	// Filters pushed down to a provider have already narrowed its share.
+	Routes    []Route
+	Platforms []PlatformResult
+	Cache     string
+	Partial   bool
+
+	// Connecting journeys planned because there was no direct route
+	Itineraries []Itinerary
+}
+
+// SessionStore holds search sessions until they expire
+type SessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*SearchSession
+	ttl      time.Duration
+	matcher  *RouteMatcher
+}
+
+// searchSessions is the store used by the search handlers
+var searchSessions = NewSessionStore(DefaultSessionTTL)
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		sessions: map[string]*SearchSession{},
+		ttl:      ttl,
+		matcher:  NewRouteMatcher(),
+	}
+}
+
+// newSearchID returns a random, URL-safe search ID
+func newSearchID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(fmt.Sprintf("failed to generate search ID: %v", err))
+	}
+	return "search_" + hex.EncodeToString(b)
+}
+
+// Save stores the results of a search, and any itineraries planned for
+// it, under a new ID
+func (s *SessionStore) Save(req SearchRequest, search *AggregatedSearch, itineraries []Itinerary) *SearchSession {
+	now := time.Now()
+	session := &SearchSession{
+		ID:        newSearchID(),
+		Request:   req,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+		Routes:    search.Unfiltered,
+		Platforms: search.Platforms,
+		Cache:     search.CacheSummary(),
+		Partial:   search.Partial(),
+
+		Itineraries: itineraries,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(now)
+	s.sessions[session.ID] = session
+	return session
+}
+
+// Get returns a session that has not expired
+func (s *SessionStore) Get(id string) (*SearchSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(s.sessions, id)
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *SessionStore) pruneLocked(now time.Time) {
+	for id, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// SessionView is a filtered, sorted view of a session's results
+type SessionView struct {
+	Routes      []Route
+	Trips       []Trip
+	FilteredOut int
+}
+
+// View applies filters and ordering to the session's routes. A platform
+// allow-list can only narrow the platforms the search went to. Sessions are
+// shared between requests, so the stored routes are never reordered.
+func (s *SessionStore) View(session *SearchSession, filters SearchFilters, rank RankOptions) SessionView {
+	names := map[string]string{}
+	for _, platform := range session.Platforms {
+		names[platform.ID] = platform.Platform
+	}
+
+	routes := make([]Route, 0, len(session.Routes))
+	for _, route := range filters.Apply(session.Routes) {
+		if filters.AllowsPlatform(route.Provider, names[route.Provider]) {
+			routes = append(routes, route)
+		}
+	}
+
+	trips := s.matcher.Group(routes)
+	SortRoutes(routes, rank)
+	SortTrips(trips, rank)
+
+	return SessionView{
+		Routes:      routes,
+		Trips:       trips,
+		FilteredOut: len(session.Routes) - len(routes),
+	}
+}
+
+// PageInfo describes one page of a search's results
+type PageInfo struct {
+	Page       int `json:"page"`
+	PageSize   int `json:"page_size"`
+	TotalPages int `json:"total_pages"`
+}
+
+// ParsePageParams reads page and page_size; ok is false when neither is set
+func ParsePageParams(q url.Values) (page, size int, ok bool, err error) {
+	page, size = 1, DefaultPageSize
+	if value := q.Get("page"); value != "" {
+		ok = true
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			return 0, 0, ok, fmt.Errorf("page must be a positive integer")
+		}
+	}
+	if value := q.Get("page_size"); value != "" {
+		ok = true
+		if size, err = strconv.Atoi(value); err != nil || size < 1 || size > MaxPageSize {
+			return 0, 0, ok, fmt.Errorf("page_size must be between 1 and %d", MaxPageSize)
+		}
+	}
+	return page, size, ok, nil
+}
+
+// paginate returns one page of routes and trips. Trips never outnumber
+// routes, so the page count follows the routes.
+func paginate(routes []Route, trips []Trip, page, size int) ([]Route, []Trip, PageInfo) {
+	info := PageInfo{
+		Page:       page,
+		PageSize:   size,
+		TotalPages: (len(routes) + size - 1) / size,
+	}
+	return pageOf(routes, page, size), pageOf(trips, page, size), info
+}
+
+func pageOf[T any](items []T, page, size int) []T {
+	start := (page - 1) * size
+	if start >= len(items) {
+		return []T{}
+	}
+	return items[start:min(start+size, len(items))]
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSMTPSinkAddr is where the local SMTP sink listens
+const DefaultSMTPSinkAddr = "localhost:2525"
+
+// maxSinkMessages is how many delivered messages the sink keeps
+const maxSinkMessages = 100
+
+// SinkMessage is a notification caught by the local sink
+type SinkMessage struct {
+	Channel    string    `json:"channel"`
+	ReceivedAt time.Time `json:"received_at"`
+	From       string    `json:"from,omitempty"`
+	To         []string  `json:"to,omitempty"`
+	Body       string    `json:"body"`
+}
+
+// NotificationSink stands in for a webhook receiver and a mail server, so
+// alerts can be tried without either. It keeps the latest messages in
+// memory.
+type NotificationSink struct {
+	mu       sync.Mutex
+	messages []SinkMessage
+}
+
+// notificationSink is started by main when BUS_SCANNER_SINKS is set
+var notificationSink = &NotificationSink{}
+
+func (s *NotificationSink) add(msg SinkMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	if len(s.messages) > maxSinkMessages {
+		s.messages = s.messages[len(s.messages)-maxSinkMessages:]
+	}
+}
+
+// Messages returns the caught messages, oldest first
+func (s *NotificationSink) Messages() []SinkMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SinkMessage{}, s.messages...)
+}
+
+// ServeHTTP accepts webhook deliveries on POST and lists every caught
+// message on GET
+func (s *NotificationSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+
+	if r.Method == "POST" {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil || !json.Valid(body) {
+			sendJSON(w, http.StatusBadRequest, Response{
+				Status:  "error",
+				Message: "Webhook body must be JSON",
+			})
+			return
+		}
+		s.add(SinkMessage{Channel: ChannelWebhook, ReceivedAt: time.Now(), Body: string(body)})
+		sendJSON(w, http.StatusOK, Response{Status: "success", Message: "Received"})
+		return
+	}
+
+	messages := s.Messages()
+	sendJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d messages", len(messages)),
+		Data:    messages,
+	})
+}
+
+// ListenSMTP runs a minimal SMTP server that catches every mail sent to it.
+// It speaks just enough of the protocol for net/smtp and offers no TLS or
+// authentication, so it must only listen locally.
+func (s *NotificationSink) ListenSMTP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveSMTP(conn)
+		}
+	}()
+	return nil
+}
+
+func (s *NotificationSink) serveSMTP(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+	reply("220 bus-scanner sink ready")
+
+	var msg SinkMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 bus-scanner")
+		case "MAIL":
+			msg = SinkMessage{Channel: ChannelEmail, From: smtpAddress(line)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, smtpAddress(line))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var body strings.Builder
+			for {
+				data, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				data = strings.TrimRight(data, "\r\n")
+				if data == "." {
+					break
+				}
+				body.WriteString(strings.TrimPrefix(data, "."))
+				body.WriteString("\n")
+			}
+			msg.Body = body.String()
+			msg.ReceivedAt = time.Now()
+			s.add(msg)
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// smtpAddress pulls the address out of "MAIL FROM:<a@b>" or "RCPT TO:<a@b>"
+func smtpAddress(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
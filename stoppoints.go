@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBoardRadiusKM is how far a boarding point may be from the chosen
+// pickup location when the search does not say
+const DefaultBoardRadiusKM = 5.0
+
+// StopPoint is a place where passengers board or leave a bus
+type StopPoint struct {
+	ID       string    `json:"id,omitempty"`
+	Name     string    `json:"name"`
+	Address  string    `json:"address,omitempty"`
+	Landmark string    `json:"landmark,omitempty"`
+	Time     time.Time `json:"time"`
+	Lat      float64   `json:"latitude,omitempty"`
+	Lng      float64   `json:"longitude,omitempty"`
+
+	// Set on boarding points when the search asked to board near a place
+	DistanceKM *float64 `json:"distance_km,omitempty"`
+}
+
+// GeoPoint is a position chosen by the user, such as a pickup location
+type GeoPoint struct {
+	Lat float64 `json:"latitude"`
+	Lng float64 `json:"longitude"`
+}
+
+// Validate checks the point is a real position
+func (p GeoPoint) Validate() error {
+	if p.Lat < -90 || p.Lat > 90 || p.Lng < -180 || p.Lng > 180 {
+		return fmt.Errorf("latitude must be within ±90 and longitude within ±180")
+	}
+	return nil
+}
+
+// parseGeoPoint reads "lat,lng"
+func parseGeoPoint(value string) (GeoPoint, error) {
+	lat, lng, ok := strings.Cut(value, ",")
+	if !ok {
+		return GeoPoint{}, fmt.Errorf("use latitude,longitude")
+	}
+	var p GeoPoint
+	var err1, err2 error
+	p.Lat, err1 = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	p.Lng, err2 = strconv.ParseFloat(strings.TrimSpace(lng), 64)
+	if err1 != nil || err2 != nil {
+		return GeoPoint{}, fmt.Errorf("use latitude,longitude")
+	}
+	return p, p.Validate()
+}
+
+// nearestBoarding returns the distance from p to the route's closest
+// boarding point. A route without located boarding points is measured
+// from its origin instead; ok is false when that has no position either.
+func nearestBoarding(route Route, p GeoPoint) (float64, bool) {
+	best, found := math.Inf(1), false
+	for _, point := range route.BoardingPoints {
+		if point.Lat == 0 && point.Lng == 0 {
+			continue
+		}
+		best, found = min(best, distanceBetween(p.Lat, p.Lng, point.Lat, point.Lng)), true
+	}
+	if !found && hasCoordinates(route.From) {
+		best, found = distanceBetween(p.Lat, p.Lng, route.From.Lat, route.From.Lng), true
+	}
+	return best, found
+}
+
+// withBoardingDistances returns a copy of points with DistanceKM set
+func withBoardingDistances(points []StopPoint, p GeoPoint) []StopPoint {
+	annotated := make([]StopPoint, len(points))
+	for i, point := range points {
+		annotated[i] = point
+		if point.Lat != 0 || point.Lng != 0 {
+			d := math.Round(distanceBetween(p.Lat, p.Lng, point.Lat, point.Lng)*10) / 10
+			annotated[i].DistanceKM = &d
+		}
+	}
+	return annotated
+}
+
+// stopTime places a provider's local "15:04" stop time on the day that
+// puts it closest to anchor, the route's departure or arrival, so a
+// 23:45 pickup for a 00:15 departure lands on the previous evening
+func stopTime(clock string, anchor time.Time, loc Location) (time.Time, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid stop time %q", clock)
+	}
+	local := anchor.In(loc.TimeZone())
+	year, month, day := local.Date()
+	at := time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, loc.TimeZone())
+	switch {
+	case at.Sub(anchor) > 12*time.Hour:
+		at = at.AddDate(0, 0, -1)
+	case anchor.Sub(at) > 12*time.Hour:
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// redBusPoint is a boarding or dropping point in a RedBus response. Older
+// responses list bare names, so a plain string is accepted too.
+type redBusPoint struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	Landmark  string  `json:"landmark"`
+	Time      string  `json:"time"` // local "15:04"
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (p *redBusPoint) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = redBusPoint{Name: name}
+		return nil
+	}
+	type plain redBusPoint
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+// convertRedBusPoints turns RedBus points into stop points. Points without
+// a usable time get the anchor, the route's departure or arrival.
+func convertRedBusPoints(points []redBusPoint, anchor time.Time, loc Location) []StopPoint {
+	if len(points) == 0 {
+		return nil
+	}
+	stops := make([]StopPoint, 0, len(points))
+	for _, p := range points {
+		at, err := stopTime(p.Time, anchor, loc)
+		if err != nil {
+			at = anchor
+		}
+		stops = append(stops, StopPoint{
+			ID:       p.ID,
+			Name:     p.Name,
+			Address:  p.Address,
+			Landmark: p.Landmark,
+			Time:     at.In(loc.TimeZone()),
+			Lat:      p.Latitude,
+			Lng:      p.Longitude,
+		})
+	}
+	return stops
+}
+
+// rapidAPIPoint is a boarding or dropping point in a RapidAPI response
+type rapidAPIPoint struct {
+	Name      string  `json:"name"`
+	Address   string  `json:"address"`
+	Landmark  string  `json:"landmark"`
+	Time      string  `json:"time"` // RFC3339
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// convertRapidAPIPoints turns RapidAPI points into stop points. Points
+// without a usable time get the anchor, the route's departure or arrival.
+func convertRapidAPIPoints(points []rapidAPIPoint, anchor time.Time, loc Location) []StopPoint {
+	if len(points) == 0 {
+		return nil
+	}
+	stops := make([]StopPoint, 0, len(points))
+	for _, p := range points {
+		at, err := time.Parse(time.RFC3339, p.Time)
+		if err != nil {
+			at = anchor
+		}
+		stops = append(stops, StopPoint{
+			Name:     p.Name,
+			Address:  p.Address,
+			Landmark: p.Landmark,
+			Time:     at.In(loc.TimeZone()),
+			Lat:      p.Latitude,
+			Lng:      p.Longitude,
+		})
+	}
+	return stops
+}
+
+// mockStopPoints invents pickup or drop-off points around a city for the
+// mock providers: the main bus stand, then points along the way out of
+// (or into) town, each a quarter of an hour apart
+func mockStopPoints(loc Location, at time.Time, boarding bool) []StopPoint {
+	names := []struct{ name, landmark string }{
+		{loc.Name, "Main bus stand"},
+		{loc.City + " Railway Station", "Opposite station gate"},
+		{loc.City + " Highway Bypass", "Near petrol pump"},
+	}
+
+	stops := make([]StopPoint, 0, len(names))
+	for i, n := range names {
+		offset := time.Duration(i*15) * time.Minute
+		if !boarding {
+			offset = -offset
+		}
+		stop := StopPoint{
+			ID:       fmt.Sprintf("%s_%d", loc.ID, i+1),
+			Name:     n.name,
+			Address:  fmt.Sprintf("%s, %s", n.name, loc.State),
+			Landmark: n.landmark,
+			Time:     at.Add(offset),
+		}
+		if hasCoordinates(loc) {
+			// Spread the points a few kilometres out from the centre
+			stop.Lat = math.Round((loc.Lat+float64(i)*0.02)*1e4) / 1e4
+			stop.Lng = math.Round((loc.Lng+float64(i)*0.015)*1e4) / 1e4
+		}
+		stops = append(stops, stop)
+	}
+
+	// Drop-offs are listed in the order the bus reaches them
+	if !boarding {
+		slices.Reverse(stops)
+	}
+	return stops
+}
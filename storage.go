@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDatabaseFile is opened by OpenStore unless BUS_SCANNER_DB is set.
+// The special path ":memory:" keeps everything in process memory.
+const DefaultDatabaseFile = "bus-scanner.db"
+
+// Storage intervals
+const (
+	DefaultRetentionInterval = time.Hour
+	DefaultHealthInterval    = time.Minute
+)
+
+// SearchRecord is one entry in the search history
+type SearchRecord struct {
+	ID         string           `json:"id"`
+	At         time.Time        `json:"at"`
+	FromCity   string           `json:"from_city"`
+	ToCity     string           `json:"to_city"`
+	Date       string           `json:"date"`
+	Request    SearchRequest    `json:"request"`
+	RouteCount int              `json:"route_count"`
+	Platforms  []PlatformResult `json:"platforms"`
+	Partial    bool             `json:"partial"`
+}
+
+// RouteSnapshot is every route a search found, before filtering
+type RouteSnapshot struct {
+	SearchID string    `json:"search_id"`
+	At       time.Time `json:"at"`
+	FromCity string    `json:"from_city"`
+	ToCity   string    `json:"to_city"`
+	Date     string    `json:"date"`
+	Routes   []Route   `json:"routes"`
+}
+
+// HealthSample is a provider's status at one moment
+type HealthSample struct {
+	Provider     string    `json:"provider"`
+	At           time.Time `json:"at"`
+	Status       string    `json:"status"`
+	Calls        int       `json:"calls"`
+	SuccessRate  float64   `json:"success_rate"`
+	P95LatencyMS int64     `json:"p95_latency_ms"`
+	Breaker      string    `json:"circuit_breaker"`
+}
+
+// RetentionPolicy says how long each kind of record is kept. Unset periods
+// use DefaultRetention and a negative period keeps records forever.
+// Bookings are only removed once they are confirmed, cancelled or expired
+// and have not changed for the retention period.
+type RetentionPolicy struct {
+	Searches      Duration `json:"searches,omitempty"`
+	Snapshots     Duration `json:"snapshots,omitempty"`
+	HealthSamples Duration `json:"health_samples,omitempty"`
+	Bookings      Duration `json:"bookings,omitempty"`
+	Fares         Duration `json:"fares,omitempty"`
+}
+
+// DefaultRetention keeps route snapshots for a week, since they are large,
+// and everything else for longer. Fares are kept for half a year so trends
+// cover the whole booking window.
+var DefaultRetention = RetentionPolicy{
+	Searches:      Duration(30 * 24 * time.Hour),
+	Snapshots:     Duration(7 * 24 * time.Hour),
+	HealthSamples: Duration(7 * 24 * time.Hour),
+	Bookings:      Duration(365 * 24 * time.Hour),
+	Fares:         Duration(180 * 24 * time.Hour),
+}
+
+// withDefaults fills unset periods from DefaultRetention
+func (p RetentionPolicy) withDefaults() RetentionPolicy {
+	if p.Searches == 0 {
+		p.Searches = DefaultRetention.Searches
+	}
+	if p.Snapshots == 0 {
+		p.Snapshots = DefaultRetention.Snapshots
+	}
+	if p.HealthSamples == 0 {
+		p.HealthSamples = DefaultRetention.HealthSamples
+	}
+	if p.Bookings == 0 {
+		p.Bookings = DefaultRetention.Bookings
+	}
+	if p.Fares == 0 {
+		p.Fares = DefaultRetention.Fares
+	}
+	return p
+}
+
+// cutoff returns the time before which records are removed, or the zero
+// time when the period is negative, meaning keep forever
+func cutoff(now time.Time, keep Duration) time.Time {
+	if keep < 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(keep))
+}
+
+// Store persists what the server would otherwise lose on restart. Lists
+// come back oldest first.
+type Store interface {
+	SaveSearch(rec SearchRecord) error
+	// Searches returns the latest limit searches between two cities since
+	// a time; empty cities match any and a limit of 0 means all
+	Searches(fromCity, toCity string, since time.Time, limit int) ([]SearchRecord, error)
+
+	SaveSnapshot(snap RouteSnapshot) error
+	Snapshots(fromCity, toCity string, since time.Time) ([]RouteSnapshot, error)
+
+	SaveBooking(b Booking) error
+	Bookings() ([]Booking, error)
+
+	SaveHealthSamples(samples []HealthSample) error
+	HealthSamples(providerID string, since time.Time) ([]HealthSample, error)
+
+	SaveFareObservations(observations []FareObservation) error
+	FareObservations(fromCity, toCity string, since time.Time) ([]FareObservation, error)
+
+	SaveAlert(rule AlertRule) error
+	DeleteAlert(id string) error
+	Alerts() ([]AlertRule, error)
+
+	// SaveConfig keeps settings changed at runtime; LoadConfig returns nil
+	// when nothing was saved
+	SaveConfig(cfg Config) error
+	LoadConfig() (*Config, error)
+
+	// Prune removes records older than the policy allows and returns how
+	// many were removed
+	Prune(policy RetentionPolicy, now time.Time) (int, error)
+
+	Close() error
+}
+
+// store is where the server keeps history, bookings and runtime config
+var store Store = NewMemoryStore()
+
+// OpenStore opens the embedded database at path, or a memory store for
+// ":memory:"
+func OpenStore(path string) (Store, error) {
+	if path == ":memory:" {
+		return NewMemoryStore(), nil
+	}
+	return OpenBoltStore(path)
+}
+
+// sameRoute reports whether a record is for the given cities; empty
+// matches any city
+func sameRoute(fromCity, toCity, recFrom, recTo string) bool {
+	return (fromCity == "" || strings.EqualFold(fromCity, recFrom)) &&
+		(toCity == "" || strings.EqualFold(toCity, recTo))
+}
+
+// recordSearch adds a finished search to the history, with a snapshot of
+// every route it found and its fares. Storage errors only cost history, so they are
+// logged rather than failing the search.
+func recordSearch(session *SearchSession) {
+	req := session.Request
+	rec := SearchRecord{
+		ID:         session.ID,
+		At:         session.CreatedAt,
+		FromCity:   req.FromCity,
+		ToCity:     req.ToCity,
+		Date:       req.Date.Format("2006-01-02"),
+		Request:    req,
+		RouteCount: len(session.Routes),
+		Platforms:  session.Platforms,
+		Partial:    session.Partial,
+	}
+	if err := store.SaveSearch(rec); err != nil {
+		fmt.Printf("Warning: could not save search %s: %v\n", session.ID, err)
+	}
+
+	if len(session.Routes) == 0 {
+		return
+	}
+	snap := RouteSnapshot{
+		SearchID: session.ID,
+		At:       session.CreatedAt,
+		FromCity: rec.FromCity,
+		ToCity:   rec.ToCity,
+		Date:     rec.Date,
+		Routes:   session.Routes,
+	}
+	if err := store.SaveSnapshot(snap); err != nil {
+		fmt.Printf("Warning: could not save routes of search %s: %v\n", session.ID, err)
+	}
+
+	// Fares served from cache were already recorded when they were fetched
+	fresh := map[string]bool{}
+	for _, platform := range session.Platforms {
+		fresh[platform.ID] = platform.Cache == CacheMiss
+	}
+	var fetched []Route
+	for _, route := range session.Routes {
+		if fresh[route.Provider] {
+			fetched = append(fetched, route)
+		}
+	}
+	if len(fetched) == 0 {
+		return
+	}
+	if err := store.SaveFareObservations(fareObservations(fetched, session.CreatedAt)); err != nil {
+		fmt.Printf("Warning: could not save fares of search %s: %v\n", session.ID, err)
+	}
+}
+
+// runRetention prunes the store every interval until ctx is done
+func runRetention(ctx context.Context, s Store, policy RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if removed, err := s.Prune(policy, time.Now()); err != nil {
+			fmt.Printf("Warning: pruning storage failed: %v\n", err)
+		} else if removed > 0 {
+			fmt.Printf("🧹 Pruned %d old records\n", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sampleHealth saves every provider's status every interval until ctx is
+// done. Samples are only taken for providers that have been called.
+func sampleHealth(ctx context.Context, s Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var samples []HealthSample
+		for _, status := range realPlatformManager.Load().ProviderStatuses() {
+			if status.Health.Calls == 0 {
+				continue
+			}
+			samples = append(samples, HealthSample{
+				Provider:     status.ID,
+				At:           now,
+				Status:       status.Status,
+				Calls:        status.Health.Calls,
+				SuccessRate:  status.Health.SuccessRate,
+				P95LatencyMS: status.Health.P95LatencyMS,
+				Breaker:      status.Breaker.State,
+			})
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		if err := s.SaveHealthSamples(samples); err != nil {
+			fmt.Printf("Warning: could not save provider health: %v\n", err)
+		}
+	}
+}
+
+// MemoryStore keeps everything in process memory. It backs tests and
+// BUS_SCANNER_DB=:memory:, and is the store until main opens another.
+type MemoryStore struct {
+	mu        sync.Mutex
+	searches  []SearchRecord
+	snapshots []RouteSnapshot
+	bookings  map[string]Booking
+	health    []HealthSample
+	fares     []FareObservation
+	alerts    map[string]AlertRule
+	config    *Config
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{bookings: map[string]Booking{}, alerts: map[string]AlertRule{}}
+}
+
+func (s *MemoryStore) SaveSearch(rec SearchRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searches = insertByTime(s.searches, rec, func(r SearchRecord) time.Time { return r.At })
+	return nil
+}
+
+func (s *MemoryStore) Searches(fromCity, toCity string, since time.Time, limit int) ([]SearchRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []SearchRecord
+	for i := len(s.searches) - 1; i >= 0 && (limit <= 0 || len(records) < limit); i-- {
+		rec := s.searches[i]
+		if rec.At.Before(since) {
+			break
+		}
+		if sameRoute(fromCity, toCity, rec.FromCity, rec.ToCity) {
+			records = append(records, rec)
+		}
+	}
+	slices.Reverse(records)
+	return records, nil
+}
+
+func (s *MemoryStore) SaveSnapshot(snap RouteSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots = insertByTime(s.snapshots, snap, func(r RouteSnapshot) time.Time { return r.At })
+	return nil
+}
+
+func (s *MemoryStore) Snapshots(fromCity, toCity string, since time.Time) ([]RouteSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var snaps []RouteSnapshot
+	for _, snap := range s.snapshots {
+		if !snap.At.Before(since) && sameRoute(fromCity, toCity, snap.FromCity, snap.ToCity) {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps, nil
+}
+
+func (s *MemoryStore) SaveBooking(b Booking) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bookings[b.ID] = b
+	return nil
+}
+
+func (s *MemoryStore) Bookings() ([]Booking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Booking, 0, len(s.bookings))
+	for _, b := range s.bookings {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (s *MemoryStore) SaveHealthSamples(samples []HealthSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sample := range samples {
+		s.health = insertByTime(s.health, sample, func(h HealthSample) time.Time { return h.At })
+	}
+	return nil
+}
+
+func (s *MemoryStore) HealthSamples(providerID string, since time.Time) ([]HealthSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var samples []HealthSample
+	for _, sample := range s.health {
+		if !sample.At.Before(since) && (providerID == "" || sample.Provider == providerID) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+func (s *MemoryStore) SaveFareObservations(observations []FareObservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range observations {
+		s.fares = insertByTime(s.fares, o, func(f FareObservation) time.Time { return f.ObservedAt })
+	}
+	return nil
+}
+
+func (s *MemoryStore) FareObservations(fromCity, toCity string, since time.Time) ([]FareObservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var observations []FareObservation
+	for _, o := range s.fares {
+		if !o.ObservedAt.Before(since) && sameRoute(fromCity, toCity, o.FromCity, o.ToCity) {
+			observations = append(observations, o)
+		}
+	}
+	return observations, nil
+}
+
+func (s *MemoryStore) SaveAlert(rule AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts[rule.ID] = rule
+	return nil
+}
+
+func (s *MemoryStore) DeleteAlert(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.alerts, id)
+	return nil
+}
+
+func (s *MemoryStore) Alerts() ([]AlertRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]AlertRule, 0, len(s.alerts))
+	for _, rule := range s.alerts {
+		list = append(list, rule)
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) SaveConfig(cfg Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = &cfg
+	return nil
+}
+
+func (s *MemoryStore) LoadConfig() (*Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config == nil {
+		return nil, nil
+	}
+	cfg := *s.config
+	return &cfg, nil
+}
+
+func (s *MemoryStore) Prune(policy RetentionPolicy, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy = policy.withDefaults()
+
+	removed := 0
+	s.searches, removed = pruneByTime(s.searches, cutoff(now, policy.Searches), removed, func(r SearchRecord) time.Time { return r.At })
+	s.snapshots, removed = pruneByTime(s.snapshots, cutoff(now, policy.Snapshots), removed, func(r RouteSnapshot) time.Time { return r.At })
+	s.health, removed = pruneByTime(s.health, cutoff(now, policy.HealthSamples), removed, func(h HealthSample) time.Time { return h.At })
+	s.fares, removed = pruneByTime(s.fares, cutoff(now, policy.Fares), removed, func(f FareObservation) time.Time { return f.ObservedAt })
+
+	before := cutoff(now, policy.Bookings)
+	for id, b := range s.bookings {
+		if b.State != BookingHeld && b.UpdatedAt.Before(before) {
+			delete(s.bookings, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+// insertByTime appends v keeping list in time order
+func insertByTime[T any](list []T, v T, at func(T) time.Time) []T {
+	i := sort.Search(len(list), func(i int) bool { return at(list[i]).After(at(v)) })
+	list = append(list, v)
+	copy(list[i+1:], list[i:])
+	list[i] = v
+	return list
+}
+
+// pruneByTime drops the records before cutoff from a time-ordered list,
+// adding the number dropped to removed
+func pruneByTime[T any](list []T, before time.Time, removed int, at func(T) time.Time) ([]T, int) {
+	i := sort.Search(len(list), func(i int) bool { return !at(list[i]).Before(before) })
+	return append([]T(nil), list[i:]...), removed + i
+}